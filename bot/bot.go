@@ -0,0 +1,341 @@
+//package bot connects integration bots to the chat server without the GUI
+package bot
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"multi-room_chat_system/shared"
+)
+
+//address of the chat server
+const DefaultAddr = "localhost:5461"
+
+//how many times a bot tries to reconnect before giving up, and the longest wait between tries
+const (
+	reconnectAttempts = 10
+	reconnectMaxDelay = 30 * time.Second
+)
+
+//returned by Run when the server ended the bot's session (a kick or ban)
+var ErrEnded = errors.New("the server ended the session")
+
+//handler of a chat message, args are the words after the command for CommandFunc
+type MessageFunc func(b *ClientAdapter, m *shared.Message)
+type CommandFunc func(b *ClientAdapter, m *shared.Message, args []string)
+
+//headless connection to the chat server that logs in with a bot account's token and calls back on what it receives
+type ClientAdapter struct {
+	Addr string
+	Token string
+	//commands are messages that start with this, "!" unless changed before Connect
+	Prefix string
+	//called for every chat message posted in the bot's room by someone else
+	OnMessage MessageFunc
+	//called for messages that mention the bot as "@name" and are not commands
+	OnMention MessageFunc
+	//called for every reply the server sends, e.g. to see why a /join was refused
+	OnReply func(b *ClientAdapter, msg any)
+
+	commands map[string]CommandFunc
+
+	//guards the connection, which is replaced when reconnecting
+	mu sync.Mutex
+	conn net.Conn
+	decoder *gob.Decoder
+	name string
+	room string
+	ended bool
+	closed chan struct{}
+}
+
+//function that returns a bot for the given server and bot token, Connect logs it in
+func New(addr string, token string) *ClientAdapter {
+	shared.Init()
+	return &ClientAdapter{
+		Addr: addr,
+		Token: token,
+		Prefix: "!",
+		commands: make(map[string]CommandFunc),
+		closed: make(chan struct{}),
+	}
+}
+
+//function that registers a command, "remind" answers messages like "!remind 10m stand-up"
+func (b *ClientAdapter) Command(name string, fn CommandFunc) {
+	b.commands[strings.ToLower(name)] = fn
+}
+
+//function that connects and logs in, the bot's name comes from its token
+func (b *ClientAdapter) Connect() error {
+	conn, err := net.Dial("tcp", b.Addr)
+	if err != nil {
+		return fmt.Errorf("could not connect: %w", err)
+	}
+	reader := bufio.NewReader(conn)
+	//answer the login prompt with the token instead of a name
+	if _, err := reader.ReadString('>'); err != nil {
+		conn.Close()
+		return fmt.Errorf("login prompt read failed: %w", err)
+	}
+	if _, err := conn.Write([]byte(shared.BotLoginCmd + " " + b.Token + "\n")); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed sending token: %w", err)
+	}
+	resp, err := reader.ReadString('>')
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed reading login response: %w", err)
+	}
+	if strings.Contains(resp, "PERMISSION DENIED") {
+		conn.Close()
+		return errors.New(strings.TrimSpace(strings.TrimSuffix(resp, ">")))
+	}
+	b.mu.Lock()
+	b.conn = conn
+	//decode from the same buffered reader so nothing read past the login response is lost
+	b.decoder = gob.NewDecoder(reader)
+	b.mu.Unlock()
+	return nil
+}
+
+//function that reads from the server and calls the bot's handlers until Close is called or the session ends, dropped connections are reconnected
+func (b *ClientAdapter) Run() error {
+	for {
+		msg, err := b.recv()
+		if err != nil {
+			if b.isClosed() {
+				return nil
+			}
+			if b.isEnded() {
+				return ErrEnded
+			}
+			if err := b.reconnect(); err != nil {
+				return err
+			}
+			continue
+		}
+		b.handle(msg)
+	}
+}
+
+//function that joins a room, the bot goes back to it after reconnecting
+func (b *ClientAdapter) Join(room string) error {
+	return b.write("/join " + room)
+}
+
+//function that posts text to the bot's room, lines are joined as a message is a single line
+func (b *ClientAdapter) Send(text string) error {
+	text = strings.Join(strings.Fields(text), " ")
+	if strings.HasPrefix(text, "/") {
+		return fmt.Errorf("text starting with '/' is a command, use Do")
+	}
+	return b.write(text)
+}
+
+//function that runs a chat command as the bot, e.g. Do("/listusers"), its reply goes to OnReply
+func (b *ClientAdapter) Do(command string) error {
+	return b.write(command)
+}
+
+//function that returns the bot's name, known once it has joined a room
+func (b *ClientAdapter) Name() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.name
+}
+
+//function that returns the room the bot is in, empty if it is in none
+func (b *ClientAdapter) Room() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.room
+}
+
+//function that logs the bot out and makes Run return
+func (b *ClientAdapter) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case <-b.closed:
+		return nil
+	default:
+		close(b.closed)
+	}
+	if b.conn == nil {
+		return nil
+	}
+	b.conn.Write([]byte("/quit\n"))
+	return b.conn.Close()
+}
+
+//function that writes a line to the server
+func (b *ClientAdapter) write(line string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+	_, err := b.conn.Write([]byte(line + "\n"))
+	return err
+}
+
+//function that reads the next message from the server
+func (b *ClientAdapter) recv() (any, error) {
+	b.mu.Lock()
+	decoder := b.decoder
+	b.mu.Unlock()
+	if decoder == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	var msg any
+	if err := decoder.Decode(&msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+//function that follows the bot's state and calls its handlers for a message from the server
+func (b *ClientAdapter) handle(msg any) {
+	switch m := msg.(type) {
+	//answer heartbeats so the server knows this connection is alive
+	case *shared.Ping:
+		b.write(shared.PongCmd)
+		return
+	case *shared.Message:
+		//joins and leaves are not chat, and the bot's own posts come back to it as replies, answering those could loop forever
+		if m.Flag || !m.Response.Status || m.UserName == b.Name() {
+			break
+		}
+		b.chat(m)
+		return
+	case *shared.JoinCmd:
+		if m.Reply.Status {
+			b.mu.Lock()
+			b.room, b.name = m.Room, m.UserName
+			b.mu.Unlock()
+		}
+	case *shared.LeaveCmd:
+		//left on purpose or the room was deleted
+		if m.Reply.Status {
+			b.setRoom("")
+		}
+	case *shared.DeleteCmd:
+		if m.Status && m.InRoom {
+			b.setRoom("")
+		}
+	//a shutdown is not the end, the bot reconnects once the server is back
+	case *shared.QuitCmd:
+		b.end()
+	case *shared.KickBanCmd:
+		if m.Status && !m.Sender {
+			b.end()
+		}
+	}
+	if b.OnReply != nil {
+		b.OnReply(b, msg)
+	}
+}
+
+//function that calls the handlers for a chat message
+func (b *ClientAdapter) chat(m *shared.Message) {
+	if b.OnMessage != nil {
+		b.OnMessage(b, m)
+	}
+	if b.Prefix != "" && strings.HasPrefix(m.Content, b.Prefix) {
+		fields := strings.Fields(strings.TrimPrefix(m.Content, b.Prefix))
+		if len(fields) > 0 {
+			if fn, exists := b.commands[strings.ToLower(fields[0])]; exists {
+				fn(b, m, fields[1:])
+				return
+			}
+		}
+	}
+	if b.OnMention != nil && Mentions(m.Content, b.Name()) {
+		b.OnMention(b, m)
+	}
+}
+
+//function that reports whether text mentions a user as "@name"
+func Mentions(text string, name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, word := range strings.Fields(text) {
+		if strings.TrimRight(word, ".,:;!?") == "@" + name {
+			return true
+		}
+	}
+	return false
+}
+
+//function that logs back in with backoff and rejoins the bot's room
+func (b *ClientAdapter) reconnect() error {
+	b.mu.Lock()
+	if b.conn != nil {
+		b.conn.Close()
+	}
+	room := b.room
+	b.mu.Unlock()
+	delay := time.Second
+	var err error
+	for attempt := 1; attempt <= reconnectAttempts; attempt++ {
+		select {
+		case <-b.closed:
+			return nil
+		case <-time.After(delay):
+		}
+		delay = min(delay * 2, reconnectMaxDelay)
+		if err = b.Connect(); err != nil {
+			log.Println("bot reconnect attempt", attempt, "failed:", err)
+			//a deleted bot or a replaced token will never get back in
+			if strings.Contains(err.Error(), "PERMISSION DENIED") {
+				return err
+			}
+			continue
+		}
+		if room != "" {
+			b.setRoom("")
+			return b.Join(room)
+		}
+		return nil
+	}
+	return fmt.Errorf("could not reconnect: %w", err)
+}
+
+//function that records the room the bot is in
+func (b *ClientAdapter) setRoom(room string) {
+	b.mu.Lock()
+	b.room = room
+	b.mu.Unlock()
+}
+
+//function that records that the server ended the session, so it is not reconnected
+func (b *ClientAdapter) end() {
+	b.mu.Lock()
+	b.ended = true
+	b.mu.Unlock()
+}
+
+//function that reports whether the server ended the session
+func (b *ClientAdapter) isEnded() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ended
+}
+
+//function that reports whether Close was called
+func (b *ClientAdapter) isClosed() bool {
+	select {
+	case <-b.closed:
+		return true
+	default:
+		return false
+	}
+}
@@ -7,10 +7,21 @@ import (
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"strings"
+	"sync"
+	"time"
 	"multi-room_chat_system/shared"
 )
 
+//how many times the client tries to reconnect before giving up, and the longest wait between tries
+const (
+	reconnectAttempts = 10
+	reconnectMaxDelay = 30 * time.Second
+)
+
+//how long to wait for the server to issue an upload token
+const uploadTokenWait = 10 * time.Second
 
 type ClientAdapter struct {
     Conn     net.Conn
@@ -19,53 +30,89 @@ type ClientAdapter struct {
     Term     chan struct{}
 	Encoder *gob.Encoder
 	Decoder *gob.Decoder
+
+	//guards the connection and session state, which are replaced when reconnecting
+	mu sync.Mutex
+	username string
+	session *shared.Session
+	//room the user is in and the last message seen there, used to fetch missed messages
+	room string
+	lastID int64
+	//set once the session ends on purpose (quit, kick, ban, shutdown) so no reconnect is attempted
+	ended bool
+	//signalled after each successful reconnect so a failed write can be retried
+	reconnected chan struct{}
+	//upload tokens the server issued, waiting to be used
+	uploadTokens chan string
 }
 
 func ConnectToServer(username string) (*ClientAdapter, string,  error){
 	//register gob
 	shared.Init()
-	//connect to the server
-	conn, err := net.Dial("tcp", "localhost:5461")
-    if err != nil {
-        return nil, "", fmt.Errorf("could not connect: %w", err)
-    }
 	//client adapter type
 	adapter := &ClientAdapter{
-        Conn:      conn,
-        Encoder:   gob.NewEncoder(conn),
-        Decoder:   gob.NewDecoder(conn),
         Incoming:  make(chan shared.ExecutableMessage),
         Outgoing:  make(chan string),
         Term:	   make(chan struct{}),
+		username: username,
+		reconnected: make(chan struct{}, 1),
+		uploadTokens: make(chan string, 1),
+    }
+	//connect to the server and log in
+	resp, err := adapter.dial(username)
+	if err != nil {
+		return nil, "", err
+	}
+	//if user is banned
+    if strings.Contains(resp, "PERMISSION DENIED"){
+        return nil, resp, nil
     }
+	//start goroutines to read/write from the GUI
+	go adapter.readLoop()
+    go adapter.writeLoop()
 
+	return adapter, resp, nil
+}
+
+//function that connects to the server and answers the login prompt, the connection is kept only if login succeeds
+func (c *ClientAdapter) dial(login string) (string, error) {
+	//connect to the server
+	conn, err := net.Dial("tcp", "localhost:5461")
+    if err != nil {
+        return "", fmt.Errorf("could not connect: %w", err)
+    }
 	reader := bufio.NewReader(conn)
 	//server requests username
 	_, err = reader.ReadString('>')
     if err != nil {
-        return nil, "", fmt.Errorf("login prompt read failed: %w", err)
+		conn.Close()
+        return "", fmt.Errorf("login prompt read failed: %w", err)
     }
-	// send username
-    _, err = conn.Write([]byte(username + "\n"))
+	// send username (or resume request)
+    _, err = conn.Write([]byte(login + "\n"))
     if err != nil {
-        return nil, "", fmt.Errorf("failed sending username: %w", err)
+		conn.Close()
+        return "", fmt.Errorf("failed sending username: %w", err)
     }
 	// read login response
     resp, err := reader.ReadString('>')
 	resp = strings.TrimSuffix(resp, "\n>")
     if err != nil {
-        return nil, "", fmt.Errorf("failed reading login response: %w", err)
+		conn.Close()
+        return "", fmt.Errorf("failed reading login response: %w", err)
     }
 	log.Println(resp)
-	//if user is banned
-    if strings.Contains(resp, "PERMISSION DENIED"){
-        return nil, resp, nil
-    }
-	//start goroutines to read/write from the GUI
-	go adapter.readLoop()
-    go adapter.writeLoop()
-
-	return adapter, resp, nil
+	if strings.Contains(resp, "PERMISSION DENIED") || strings.HasPrefix(resp, "RESUME FAILED") {
+		conn.Close()
+		return resp, nil
+	}
+	c.mu.Lock()
+	c.Conn = conn
+	c.Encoder = gob.NewEncoder(conn)
+	//decode from the same buffered reader so nothing read past the login response is lost
+	c.Decoder = gob.NewDecoder(reader)
+	c.mu.Unlock()
+	return resp, nil
 }
 
 //goroutine to send response to GUI to display for client
@@ -75,11 +122,37 @@ func (c * ClientAdapter) readLoop() {
         case <-c.Term:
             return
         default:
-            msg := recvExecutableMsg(c.Term, c.Decoder)
-			if msg == nil {
-				log.Println("client was terminated, do not forward nil to GUI")
+            msg, err := c.recv()
+			if err != nil {
+				//try to get the session back unless it was ended on purpose
+				if c.isEnded() || !c.reconnect() {
+					log.Println("client was terminated, do not forward nil to GUI")
+					safeClose(c.Term)
+					return
+				}
+				continue
+			}
+			//answer heartbeats so the server knows this connection is alive
+			if _, ok := msg.(*Ping); ok {
+				c.write(shared.PongCmd)
 				continue
 			}
+			if session, ok := msg.(*Session); ok {
+				session.prevRoom = c.setSession(session.Session)
+				//a new session has nothing to show, a resumed one shows what was missed
+				if !session.Resumed {
+					continue
+				}
+			}
+			//hand upload tokens to the upload waiting for one
+			if token, ok := msg.(*UploadTokenCmd); ok && token.Status {
+				select {
+				case c.uploadTokens <- token.Token:
+				default:
+				}
+				continue
+			}
+			c.track(msg)
 			log.Println("client received wrapped type")
             //err := c.Decoder.Decode(&msg)
 			log.Println("Decoded message:", msg)
@@ -95,33 +168,243 @@ func (c * ClientAdapter) writeLoop() {
         case <-c.Term:
             return
         case outgoing := <-c.Outgoing:
-            _, err := c.Conn.Write([]byte(outgoing + "\n"))
-            if err != nil {
-                close(c.Term)
-                return
-            }
+			for c.write(outgoing) != nil {
+				//connection dropped, wait for the read loop to reconnect then retry
+				select {
+				case <-c.Term:
+					return
+				case <-c.reconnected:
+				}
+			}
         }
     }
 }
 
+//function that writes a line to the current connection
+func (c *ClientAdapter) write(line string) error {
+	c.mu.Lock()
+	conn := c.Conn
+	c.mu.Unlock()
+	_, err := conn.Write([]byte(line + "\n"))
+	return err
+}
+
+//function that reads the next message from the current connection
+func (c *ClientAdapter) recv() (shared.ExecutableMessage, error) {
+	c.mu.Lock()
+	conn, decoder := c.Conn, c.Decoder
+	//the server pings regularly, so a silent connection is a dead one
+	if c.session != nil && c.session.HeartbeatInterval > 0 {
+		conn.SetReadDeadline(time.Now().Add(3 * c.session.HeartbeatInterval))
+	}
+	c.mu.Unlock()
+	return recvExecutableMsg(decoder)
+}
+
+//function that reconnects with exponential backoff, resuming the session when the server still has it
+func (c *ClientAdapter) reconnect() bool {
+	c.mu.Lock()
+	c.Conn.Close()
+	room := c.room
+	c.mu.Unlock()
+	c.Incoming <- &connStatus{room: room, text: "[CLIENT] Connection to the server was lost, reconnecting..."}
+
+	delay := time.Second
+	for attempt := 1; attempt <= reconnectAttempts; attempt++ {
+		select {
+		case <-c.Term:
+			return false
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+		resp, err := c.resume()
+		if err != nil {
+			log.Println("reconnect attempt", attempt, "failed:", err)
+			continue
+		}
+		if strings.Contains(resp, "PERMISSION DENIED") {
+			log.Println("reconnect attempt", attempt, "denied:", resp)
+			//a banned user will never get back in
+			if strings.Contains(resp, "banned") {
+				c.Incoming <- &connStatus{quit: true, text: resp}
+				return false
+			}
+			continue
+		}
+		//refresh the room list in case rooms changed while disconnected
+		c.Incoming <- &connStatus{rooms: getInitRooms(resp)}
+		//wake up a write that failed while disconnected
+		select {
+		case c.reconnected <- struct{}{}:
+		default:
+		}
+		return true
+	}
+	c.Incoming <- &connStatus{quit: true, text: "Could not reconnect to the server"}
+	return false
+}
+
+//function that resumes the session, falling back to a fresh login that rejoins the previous room
+func (c *ClientAdapter) resume() (string, error) {
+	c.mu.Lock()
+	session, room, lastID := c.session, c.room, c.lastID
+	c.mu.Unlock()
+	if session != nil {
+		resp, err := c.dial(fmt.Sprintf("%s %s %d", shared.ResumeCmd, session.Token, lastID))
+		if err != nil || !strings.HasPrefix(resp, "RESUME FAILED") {
+			return resp, err
+		}
+		log.Println(resp)
+	}
+	//the server no longer has the session (e.g. it restarted), so log in again
+	resp, err := c.dial(c.username)
+	if err != nil || strings.Contains(resp, "PERMISSION DENIED") {
+		return resp, err
+	}
+	c.mu.Lock()
+	c.session = nil
+	c.mu.Unlock()
+	//rejoining sends the room's full history, which includes anything missed
+	if room != "" {
+		c.write("/join " + room)
+	}
+	return resp, nil
+}
+
+//function that stores the session the server issued, returns the room the client was in before
+func (c *ClientAdapter) setSession(session *shared.Session) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev := c.room
+	c.session = session
+	return prev
+}
+
+//function that follows the room the user is in and the last message seen there
+func (c *ClientAdapter) track(msg shared.ExecutableMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch m := msg.(type) {
+	case *JoinCmd:
+		if m.Reply.Status {
+			c.room = m.Room
+			c.lastID = 0
+			for _, l := range m.Reply.Log {
+				c.lastID = max(c.lastID, l.ID)
+			}
+		}
+	case *Session:
+		c.room = m.Room
+		for _, l := range m.Missed {
+			c.lastID = max(c.lastID, l.ID)
+		}
+	case *Message:
+		if m.Response.Status && m.Response.CurrentRoom == c.room {
+			c.lastID = max(c.lastID, m.ID)
+		}
+	case *LeaveCmd:
+		if m.Reply.Status {
+			c.room = ""
+		}
+	case *DeleteCmd:
+		if m.Status && m.InRoom {
+			c.room = ""
+		}
+	case *QuitCmd:
+		c.ended = true
+	case *ShutdownCmd:
+		if m.Status {
+			c.ended = true
+		}
+	case *KickBanCmd:
+		if m.Status && !m.Sender {
+			c.ended = true
+		}
+	}
+}
+
+//function that asks the server for an upload token over the chat connection and waits for it
+func (c *ClientAdapter) UploadToken() (string, error) {
+	//a token left over from an earlier request may already have expired
+	select {
+	case <-c.uploadTokens:
+	default:
+	}
+	select {
+	case c.Outgoing <- "/uploadtoken":
+	case <-c.Term:
+		return "", fmt.Errorf("not connected to the server")
+	}
+	select {
+	case token := <-c.uploadTokens:
+		return token, nil
+	case <-time.After(uploadTokenWait):
+		return "", fmt.Errorf("the server did not issue an upload token")
+	case <-c.Term:
+		return "", fmt.Errorf("not connected to the server")
+	}
+}
+
+//function that downloads a link, files on the chat server are requested with this user's session
+func (c *ClientAdapter) FetchFile(link string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(link, fileServerURL + "/") {
+		c.mu.Lock()
+		if c.session != nil {
+			req.Header.Set("Authorization", "Bearer " + c.session.Token)
+		}
+		c.mu.Unlock()
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("download failed: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+//function that reports whether the session ended on purpose
+func (c *ClientAdapter) isEnded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ended
+}
 
 //function that decodes response from the server to be usable by the client
-func recvExecutableMsg(term chan struct{}, decoder *gob.Decoder) shared.ExecutableMessage {
+func recvExecutableMsg(decoder *gob.Decoder) (shared.ExecutableMessage, error) {
 	//create new message to return
 	var msg interface{}
 	err := decoder.Decode(&msg)
 	if err != nil {
 		if err == io.EOF {
-            fmt.Println("Server closed the connection. Exiting...")
+            fmt.Println("Server closed the connection.")
         } else {
             fmt.Println("Error decoding message from server:", err)
         }
-        close(term) // terminate client if decoding fails
-        return nil
+        return nil, err
 	}
 	log.Println("client received gob message from server")
 	//otherwise return executable to client
-	return wrapShared(msg)
+	return wrapShared(msg), nil
+}
+
+//safely close the client's termination channel
+func safeClose(ch chan struct{}) {
+    select {
+    case <-ch:
+        // already closed, do nothing
+    default:
+        close(ch)
+    }
 }
 
 //function that converts server-side executable to client-side executable
@@ -163,6 +446,38 @@ func wrapShared(msg interface{}) shared.ExecutableMessage {
 		return &GetLog{GetLog: m}
 	case *shared.UpdateLobby:
 		return &UpdateLobby{UpdateLobby: m}
+	case *shared.Ping:
+		return &Ping{Ping: m}
+	case *shared.Session:
+		return &Session{Session: m}
+	case *shared.MessageUpdate:
+		return &MessageUpdate{MessageUpdate: m}
+	case *shared.UploadTokenCmd:
+		return &UploadTokenCmd{UploadTokenCmd: m}
+	case *shared.RetentionCmd:
+		return &RetentionCmd{RetentionCmd: m}
+	case *shared.ExportCmd:
+		return &ExportCmd{ExportCmd: m}
+	case *shared.BackupCmd:
+		return &BackupCmd{BackupCmd: m}
+	case *shared.AuditCmd:
+		return &AuditCmd{AuditCmd: m}
+	case *shared.APITokenCmd:
+		return &APITokenCmd{APITokenCmd: m}
+	case *shared.BotCmd:
+		return &BotCmd{BotCmd: m}
+	case *shared.WebhookCmd:
+		return &WebhookCmd{WebhookCmd: m}
+	case *shared.CommandReply:
+		return &CommandReply{CommandReply: m}
+	case *shared.RoleCmd:
+		return &RoleCmd{RoleCmd: m}
+	case *shared.TransferOwnerCmd:
+		return &TransferOwnerCmd{TransferOwnerCmd: m}
+	case *shared.StatusCmd:
+		return &StatusCmd{StatusCmd: m}
+	case *shared.PresenceUpdate:
+		return &PresenceUpdate{PresenceUpdate: m}
     default:
         panic("error during wrapping: unknown shared type")
     }
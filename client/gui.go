@@ -1,9 +1,11 @@
 package client
 
 import (
+	"fmt"
 	"log"
 	"multi-room_chat_system/shared"
 	"net/url"
+	"path"
 	"regexp"
 	"strings"
 	"fyne.io/fyne/v2"
@@ -11,9 +13,7 @@ import (
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
-	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/widget"
-	"github.com/google/uuid"
     "fyne.io/fyne/v2/theme"
 )
 
@@ -33,11 +33,24 @@ type GUI struct {
 	rooms		[]string
 	bottomBar	*fyne.Container
 	selectedID  widget.ListItemID
+	adapter     *ClientAdapter
+	//presence of the users in each room, the current room's are shown in the member sidebar
+	members     map[string][]shared.Presence
+	memberList  *widget.List
+	memberPanel fyne.CanvasObject
 }
 
 //regex to detect URLs
 var urlRegex = regexp.MustCompile(`https?://[^\s]+`)
 
+//marks shown before a member's name for each presence
+var presenceMarks = map[string]string{
+	shared.PresenceOnline: "●",
+	shared.PresenceAway: "◐",
+	shared.PresenceDND: "⊘",
+	shared.PresenceOffline: "○",
+}
+
 //gui function to display single line output from the server
 func (g *GUI) Display(room string, text string, broadcast bool) {
 	if g.quitting {
@@ -132,46 +145,23 @@ func (g *GUI) DisplayJoin(room string, messages []shared.Message) {
 
     for _, msg := range messages {
         if msg.Image {
-            uri := storage.NewURI(msg.Content)
-            if uri == nil {
-                box.Add(widget.NewLabel("Could not load image: " + msg.Content))
-                continue
-            }
             //add image metadata
             lbl := widget.NewLabel(formatImgMetadata(msg.MsgMetadata))
             lbl.Wrapping = fyne.TextWrapWord
             box.Add(lbl)
 
-            //placeholder first
-            placeholder := widget.NewLabel("[loading image]")
-            box.Add(placeholder)
-
-            //async load of the image
-            go func(uri fyne.URI, ph *widget.Label, box *fyne.Container, scroll *container.Scroll) {
-                img := canvas.NewImageFromURI(uri)
-                img.FillMode = canvas.ImageFillContain
-                img.SetMinSize(fyne.NewSize(200, 200))
-
-                //replace placeholder with image
-                idx := -1
-                for i, o := range box.Objects {
-                    if o == ph {
-                        idx = i
-                        break
-                    }
-                }
-                if idx >= 0 {
-                    box.Objects[idx] = img
-                } else {
-                    box.Add(img)
-                }
-                box.Refresh()
-                scroll.Refresh()
-                scroll.ScrollToBottom()
-            }(uri, placeholder, box, scroll)
+            g.addImage(box, scroll, msg.Content, msg.Thumbnails)
 
             continue
         }
+        //attachments show who sent them and a row to save the file
+        if msg.Attachment != nil {
+            lbl := widget.NewLabel(formatImgMetadata(msg.MsgMetadata))
+            lbl.Wrapping = fyne.TextWrapWord
+            box.Add(lbl)
+            box.Add(g.newAttachmentRow(*msg.Attachment))
+            continue
+        }
 
         //handle text
         text := formatMessage(false, &msg, nil)
@@ -227,6 +217,10 @@ func (g *GUI) DisplayJoin(room string, messages []shared.Message) {
             label.Wrapping = fyne.TextWrapWord
             box.Add(label)
         }
+        //show the link's preview card under the message
+        if msg.Preview != nil {
+            box.Add(g.newPreviewCard(*msg.Preview))
+        }
     }
 
     //refresh once at the end
@@ -249,34 +243,34 @@ func (g *GUI) ensureRoom(room string) (*fyne.Container, *container.Scroll) {
     return g.roomBoxes[room], g.chatScrolls[room]
 }
 
-//gui function used to display a .jpg image when it is sent by the server
-func (g *GUI) DisplayImage(room string, url string) {
-    box, ok := g.roomBoxes[room]
-    if !ok {
-        box = container.NewVBox()
-        g.roomBoxes[room] = box
-        g.chatScrolls[room] = container.NewVScroll(box)
-    }
-
-    uri := storage.NewURI(url)
-    if uri == nil {
-        log.Println("Invalid URI for image:", url)
-        box.Add(widget.NewLabel("Could not load image: " + url))
+//gui function used to display an image when it is sent by the server
+func (g *GUI) DisplayImage(room string, url string, thumbnails []shared.Thumbnail) {
+    if g.quitting {
         return
     }
+    box, scroll := g.ensureRoom(room)
+    g.addImage(box, scroll, url, thumbnails)
+}
 
+//helper gui function that shows an image, loading its thumbnail first when there is one, tapping it opens the full image
+func (g *GUI) addImage(box *fyne.Container, scroll *container.Scroll, link string, thumbnails []shared.Thumbnail) {
+    shown := link
+    if len(thumbnails) > 0 {
+        shown = thumbnails[len(thumbnails) - 1].URL
+    }
     placeholder := widget.NewLabel("[loading image]")
     box.Add(placeholder)
 
-    go func(uri fyne.URI, ph *widget.Label, box *fyne.Container) {
-        img := canvas.NewImageFromURI(uri)
-        img.FillMode = canvas.ImageFillContain
-        img.SetMinSize(fyne.NewSize(200, 200))
+    go func() {
+        img := newTappableImage(g.loadImage(shown, fyne.NewSize(200, 200)), func() {
+            g.showFullImage(link)
+        })
 
+        //replace placeholder with image
         fyne.Do(func() {
             idx := -1
             for i, o := range box.Objects {
-                if o == ph {
+                if o == placeholder {
                     idx = i
                     break
                 }
@@ -287,11 +281,127 @@ func (g *GUI) DisplayImage(room string, url string) {
                 box.Add(img)
             }
             box.Refresh()
-            if scroll, ok := g.chatScrolls[room]; ok {
-                scroll.ScrollToBottom()
-            }
+            scroll.ScrollToBottom()
+        })
+    }()
+}
+
+//helper gui function that opens the full size image in its own window
+func (g *GUI) showFullImage(link string) {
+    win := fyne.CurrentApp().NewWindow(path.Base(link))
+    win.SetContent(widget.NewLabel("[loading image]"))
+    win.Resize(fyne.NewSize(800, 600))
+    win.Show()
+    go func() {
+        img := g.loadImage(link, fyne.NewSize(200, 200))
+        fyne.Do(func() {
+            win.SetContent(img)
         })
-    }(uri, placeholder, box)
+    }()
+}
+
+//image in the chat that can be tapped
+type tappableImage struct {
+    widget.BaseWidget
+    image *canvas.Image
+    onTapped func()
+}
+
+//helper gui function that makes an image tappable
+func newTappableImage(image *canvas.Image, onTapped func()) *tappableImage {
+    t := &tappableImage{image: image, onTapped: onTapped}
+    t.ExtendBaseWidget(t)
+    return t
+}
+
+func (t *tappableImage) CreateRenderer() fyne.WidgetRenderer {
+    return widget.NewSimpleRenderer(t.image)
+}
+
+func (t *tappableImage) Tapped(*fyne.PointEvent) {
+    t.onTapped()
+}
+
+//gui function used to display a link preview card sent as a follow-up to a message
+func (g *GUI) DisplayPreview(room string, preview shared.LinkPreview) {
+    if g.quitting {
+        return
+    }
+    box, scroll := g.ensureRoom(room)
+    box.Add(g.newPreviewCard(preview))
+    box.Refresh()
+    scroll.ScrollToBottom()
+}
+
+//gui function used to display a file attachment sent by the server
+func (g *GUI) DisplayAttachment(room string, attachment shared.Attachment) {
+    if g.quitting {
+        return
+    }
+    box, scroll := g.ensureRoom(room)
+    box.Add(g.newAttachmentRow(attachment))
+    box.Refresh()
+    scroll.ScrollToBottom()
+}
+
+//helper gui function that builds the row for an attachment, the file is only downloaded when the user saves it
+func (g *GUI) newAttachmentRow(attachment shared.Attachment) fyne.CanvasObject {
+    info := widget.NewLabel(fmt.Sprintf("%s (%s, %s)", attachment.Name, formatSize(attachment.Size), attachment.Type))
+    info.Wrapping = fyne.TextWrapWord
+    saveBtn := widget.NewButtonWithIcon("Save", theme.DownloadIcon(), func() {
+        save := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+            if err != nil || writer == nil {
+                return
+            }
+            go func() {
+                defer writer.Close()
+                if err := g.adapter.DownloadFile(attachment.URL, writer); err != nil {
+                    log.Println("download error:", err)
+                    fyne.Do(func() {
+                        dialog.ShowError(err, g.window)
+                    })
+                }
+            }()
+        }, g.window)
+        save.SetFileName(attachment.Name)
+        save.Show()
+    })
+    return container.NewBorder(nil, nil, widget.NewIcon(theme.FileIcon()), saveBtn, info)
+}
+
+//helper gui function that downloads an image, images on the chat server are fetched with this user's session
+func (g *GUI) loadImage(link string, size fyne.Size) *canvas.Image {
+    img := &canvas.Image{}
+    body, err := g.adapter.FetchFile(link)
+    if err != nil {
+        log.Println("could not load image:", link, err)
+    } else {
+        img = canvas.NewImageFromReader(body, path.Base(link))
+        body.Close()
+    }
+    img.FillMode = canvas.ImageFillContain
+    img.SetMinSize(size)
+    return img
+}
+
+//helper gui function that builds the card for a link preview, the thumbnail loads in the background
+func (g *GUI) newPreviewCard(preview shared.LinkPreview) fyne.CanvasObject {
+    var content fyne.CanvasObject = widget.NewLabel(preview.URL)
+    if parsed, err := url.Parse(preview.URL); err == nil {
+        content = widget.NewHyperlink(preview.URL, parsed)
+    }
+    desc := widget.NewLabel(preview.Description)
+    desc.Wrapping = fyne.TextWrapWord
+    card := widget.NewCard(preview.Title, "", container.NewVBox(desc, content))
+    if preview.Image != "" {
+        go func() {
+            img := g.loadImage(preview.Image, fyne.NewSize(120, 120))
+            fyne.Do(func() {
+                card.SetImage(img)
+            })
+        }()
+    }
+    return card
 }
 
 //gui function used to clear the room's box (message history) after leaving
@@ -351,8 +461,11 @@ func (g *GUI) DeselectRoom() {
 //gui function used to set the rooms on the GUI side pannel
 func (g *GUI) SetRooms(rooms []string) {
     g.rooms = rooms
-	//initialize per-room containers
+	//initialize per-room containers, keeping the history of rooms already shown
 	for _, room := range rooms {
+		if _, exists := g.roomBoxes[room]; exists {
+			continue
+		}
 		box := container.NewVBox()
         scroll := container.NewVScroll(box)
         scroll.SetMinSize(fyne.NewSize(600, 400))
@@ -385,6 +498,28 @@ func (g *GUI) RemoveRoom(room string) {
     g.listView.Refresh()
 }
 
+//gui function used to update the presence of a room's users in the member sidebar
+func (g *GUI) SetMembers(room string, members []shared.Presence) {
+	g.members[room] = members
+	if room == g.currentRoom {
+		g.memberList.Refresh()
+	}
+}
+
+//gui function used to show the current room's chat and members, or the lobby when not in a room
+func (g *GUI) showChat() {
+	var rightSide *fyne.Container
+	if g.currentRoom == "" {
+		rightSide = container.NewBorder(nil, g.bottomBar, nil, nil, g.lobbyScroll)
+	} else {
+		rightSide = container.NewBorder(nil, g.bottomBar, nil, g.memberPanel, g.chatScrolls[g.currentRoom])
+	}
+	g.memberList.Refresh()
+	split := container.NewHSplit(g.listView, rightSide)
+	split.Offset = 0.2
+	g.window.SetContent(split)
+}
+
 //gui function used to display the lobby after the user leaves a room
 func (g *GUI) ShowLobby() {
     rightSide := container.NewBorder(nil, g.bottomBar, nil, nil, g.lobbyScroll)
@@ -523,6 +658,8 @@ func MainWindow(a fyne.App, username string, adapter *ClientAdapter, rooms []str
 		window: mainWin,
 		currentRoom: "",
 		rooms: make([]string, 0),
+		adapter: adapter,
+		members: make(map[string][]shared.Presence),
 	}
 	//create lobby box
 	gui.lobbyBox = container.NewVBox()
@@ -546,10 +683,17 @@ func MainWindow(a fyne.App, username string, adapter *ClientAdapter, rooms []str
             filePath := reader.URI().Path()
 
             go func() {
-                uuid := uuid.New().String()
-                url, err := UploadImageToServer("http://localhost:8080", uuid, filePath)
+                var url string
+                token, err := adapter.UploadToken()
+                if err == nil {
+                    url, err = UploadImageToServer(fileServerURL, token, filePath)
+                }
+                //the server explains why an image was refused (not an image, too large)
                 if err != nil {
                     log.Println("upload error:", err)
+                    fyne.Do(func() {
+                        dialog.ShowError(err, mainWin)
+                    })
                     return
                 }
                 log.Println("path:", filePath)
@@ -561,6 +705,73 @@ func MainWindow(a fyne.App, username string, adapter *ClientAdapter, rooms []str
         }, mainWin)
     })
 
+    //attach file button, any allowed file is sent as-is
+    attachBtn := widget.NewButton("Attach", func() {
+        dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+            if err != nil || reader == nil {
+                return
+            }
+            defer reader.Close()
+
+            filePath := reader.URI().Path()
+
+            go func() {
+                token, err := adapter.UploadToken()
+                if err != nil {
+                    log.Println("upload error:", err)
+                    fyne.Do(func() {
+                        dialog.ShowError(err, mainWin)
+                    })
+                    return
+                }
+                attachment, err := UploadFileToServer(fileServerURL, token, filePath)
+                if err != nil {
+                    log.Println("upload error:", err)
+                    fyne.Do(func() {
+                        dialog.ShowError(err, mainWin)
+                    })
+                    return
+                }
+                //post the stored file to the current room
+                adapter.Outgoing <- "file:" + attachment.ID
+            }()
+        }, mainWin)
+    })
+
+    //export button, asks the server for a transcript of the current room
+    exportBtn := widget.NewButton("Export", func() {
+        if gui.currentRoom == "" {
+            dialog.ShowInformation("Export", "Join a room to export its transcript", mainWin)
+            return
+        }
+        room := gui.currentRoom
+        format := widget.NewSelect([]string{"txt", "json", "html", "md"}, nil)
+        format.SetSelected("txt")
+        from := widget.NewEntry()
+        from.SetPlaceHolder("YYYY-MM-DD (optional)")
+        to := widget.NewEntry()
+        to.SetPlaceHolder("YYYY-MM-DD (optional)")
+        items := []*widget.FormItem{
+            widget.NewFormItem("Format", format),
+            widget.NewFormItem("From", from),
+            widget.NewFormItem("To", to),
+        }
+        dialog.ShowForm("Export "+room, "Export", "Cancel", items, func(ok bool) {
+            if !ok {
+                return
+            }
+            //an empty end of the range is sent as "-" so the server keeps it open
+            start, end := strings.TrimSpace(from.Text), strings.TrimSpace(to.Text)
+            if start == "" {
+                start = "-"
+            }
+            if end == "" {
+                end = "-"
+            }
+            //the server replies with a row the transcript can be saved from
+            adapter.Outgoing <- strings.Join([]string{"/export", room, start, end, format.Selected}, " ")
+        }, mainWin)
+    })
 
     sendBtn := widget.NewButton("Send", func() {
         text := input.Text
@@ -580,7 +791,7 @@ func MainWindow(a fyne.App, username string, adapter *ClientAdapter, rooms []str
     })
     input.OnSubmitted = func(text string) { sendBtn.OnTapped() }
 
-    bottomBar := container.NewBorder(nil, nil, uploadBtn, sendBtn, input)
+    bottomBar := container.NewBorder(nil, nil, container.NewHBox(uploadBtn, attachBtn, exportBtn), sendBtn, input)
 	gui.bottomBar = bottomBar
 
     // --------------------------
@@ -595,6 +806,31 @@ func MainWindow(a fyne.App, username string, adapter *ClientAdapter, rooms []str
     )
 	gui.listView = listView
 	gui.rooms = rooms
+
+    // --------------------------
+    // RIGHT: Member sidebar
+    // --------------------------
+    memberList := widget.NewList(
+        func() int { return len(gui.members[gui.currentRoom]) },
+        func() fyne.CanvasObject {
+            label := widget.NewLabel("")
+            label.Truncation = fyne.TextTruncateEllipsis
+            return label
+        },
+        func(id widget.ListItemID, obj fyne.CanvasObject) {
+            member := gui.members[gui.currentRoom][id]
+            text := presenceMarks[member.State] + " " + member.User
+            if member.Status != "" {
+                text += " (" + member.Status + ")"
+            }
+            obj.(*widget.Label).SetText(text)
+        },
+    )
+    gui.memberList = memberList
+    //keep the sidebar wide enough to read names
+    sidebarWidth := canvas.NewRectangle(theme.Color(theme.ColorNameBackground))
+    sidebarWidth.SetMinSize(fyne.NewSize(180, 0))
+    gui.memberPanel = container.NewStack(sidebarWidth, container.NewBorder(widget.NewLabelWithStyle("Members", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}), nil, nil, nil, memberList))
     listView.OnSelected = func(id widget.ListItemID) {
 		gui.selectedID = id
 		selected := gui.rooms[id]
@@ -606,33 +842,13 @@ func MainWindow(a fyne.App, username string, adapter *ClientAdapter, rooms []str
         }
 		//set active room
 		gui.currentRoom = gui.rooms[id]
-
-		var rightSide *fyne.Container
-		if gui.currentRoom == "" {
-			rightSide = container.NewBorder(nil, bottomBar, nil, nil, gui.lobbyScroll)
-			
-		} else {
-			rightSide = container.NewBorder(nil, bottomBar, nil, nil, gui.chatScrolls[gui.currentRoom])
-		}
-        split := container.NewHSplit(listView, rightSide)
-        split.Offset = 0.2
-        mainWin.SetContent(split)
+		gui.showChat()
     }
 
     // --------------------------
     // RIGHT SIDE (default room)
     // --------------------------
-	var rightSide *fyne.Container
-	if gui.currentRoom == "" {
-		rightSide = container.NewBorder(nil, bottomBar, nil, nil, gui.lobbyScroll)
-		
-	} else {
-		rightSide = container.NewBorder(nil, bottomBar, nil, nil, gui.chatScrolls[gui.currentRoom])
-	}
-	split := container.NewHSplit(listView, rightSide)
-    split.Offset = 0.2
-
-    mainWin.SetContent(split)
+	gui.showChat()
 
     // --------------------------
     // RECEIVE PATH: listen for server messages
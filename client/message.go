@@ -22,7 +22,12 @@ func (m *Message) ExecuteClient(ui shared.ClientUI)() {
 	log.Println(m.Response.CurrentRoom)
 	if m.Image {
 		ui.Display(m.Response.CurrentRoom, formatImgMetadata(m.MsgMetadata), false)
-		ui.DisplayImage(m.Response.CurrentRoom, m.Content)
+		ui.DisplayImage(m.Response.CurrentRoom, m.Content, m.Thumbnails)
+		return
+	}
+	if m.Attachment != nil {
+		ui.Display(m.Response.CurrentRoom, formatImgMetadata(m.MsgMetadata), false)
+		ui.DisplayAttachment(m.Response.CurrentRoom, *m.Attachment)
 		return
 	}
 	ui.Display(m.Response.CurrentRoom, formatMessage(false, m.Message, nil), false)
@@ -46,12 +51,12 @@ func (j *JoinCmd) ExecuteClient(ui shared.ClientUI)() {
 	//print out entire message history to client
 	messages := make([]shared.Message, 0)
 	for _, msg := range j.Reply.Log {
-		temp := shared.Message{ MsgMetadata: shared.MsgMetadata{ UserName: msg.UserName, Timestamp: msg.Timestamp, Content: msg.Content, Flag: msg.Flag, Args: msg.Args}, Image: msg.Image, URL: msg.URL,}
+		temp := shared.Message{ MsgMetadata: shared.MsgMetadata{ UserName: msg.UserName, Timestamp: msg.Timestamp, Content: msg.Content, Flag: msg.Flag, Args: msg.Args}, Image: msg.Image, URL: msg.URL, Preview: msg.Preview, Attachment: msg.Attachment, Thumbnails: msg.Thumbnails,}
 		messages = append(messages, temp)
 		/*
 		if msg.Image {
 			ui.Display(j.Reply.CurrentRoom, formatImgMetadata(msg.MsgMetadata), false)
-			ui.DisplayImage(j.Reply.CurrentRoom, msg.Content)
+			ui.DisplayImage(j.Reply.CurrentRoom, msg.Content, msg.Thumbnails)
 			continue
 		}
 		ui.Display(j.Reply.CurrentRoom, formatMessage(false, &msg, nil), false)
@@ -100,8 +105,8 @@ func (lu *ListUsersCmd) ExecuteClient(ui shared.ClientUI)() {
 	//if successful print the current room and its users to the client
 	//fmt.Println("=======CURRENT USERS IN ROOM ",lu.Reply.Room,"=======")
 	ui.Display(lu.Reply.CurrentRoom, "======= CURRENT USERS IN ROOM " + lu.Reply.Room + " =======", false)
-	for _, user := range lu.Reply.Users {
-		ui.Display(lu.Reply.CurrentRoom, "\t" + user, false)
+	for _, user := range lu.Reply.Presence {
+		ui.Display(lu.Reply.CurrentRoom, "\t" + user.String(), false)
 	}
 }
 /////////////////////////////////////////////////////////////////////////////////////////////////
@@ -131,6 +136,10 @@ type QuitCmd struct {
 //user will always be able to quit
 func (q *QuitCmd) ExecuteServer() {}
 func (q *QuitCmd) ExecuteClient(ui shared.ClientUI)() {
+	if q.Reason != "" {
+		ui.UserQuit(q.Reason)
+		return
+	}
 	ui.UserQuit("You have been disconnected from the server")
 }
 /////////////////////////////////////////////////////////////////////////////////////////////////
@@ -324,6 +333,207 @@ func (u *UpdateLobby) ExecuteClient(ui shared.ClientUI) {
 	printLog(temp, ui)
 }
 
+//heartbeat from the server, answered by the adapter and never shown in the GUI
+type Ping struct {
+	*shared.Ping
+}
+func (p *Ping) ExecuteServer() {}
+func (p *Ping) ExecuteClient(ui shared.ClientUI) {}
+
+//session issued by the server, only a resumed session is passed on to the GUI
+type Session struct {
+	*shared.Session
+	//room the client was in before the connection dropped, set by the adapter
+	prevRoom string
+}
+func (s *Session) ExecuteServer() {}
+func (s *Session) ExecuteClient(ui shared.ClientUI) {
+	if !s.Resumed {
+		return
+	}
+	if s.Room == "" {
+		//the room could not be rejoined (deleted or no longer accessible)
+		if s.prevRoom != "" {
+			ui.ClearRoom(s.prevRoom)
+			ui.DeselectRoom()
+			ui.Display("", "======= LEFT ROOM " + s.prevRoom + " =======", false)
+		}
+		ui.Display("", "[CLIENT] Reconnected to the server", false)
+		return
+	}
+	//show only the messages missed while disconnected
+	ui.DisplayJoin(s.Room, s.Missed)
+	ui.Display(s.Room, "[CLIENT] Reconnected to the server", false)
+}
+
+//follow-up to a posted message once the server has inspected its link
+type MessageUpdate struct {
+	*shared.MessageUpdate
+}
+func (mu *MessageUpdate) ExecuteServer() {}
+func (mu *MessageUpdate) ExecuteClient(ui shared.ClientUI) {
+	//the link turned out to be an image, show it under the link
+	if mu.Image {
+		ui.DisplayImage(mu.Room, mu.URL, nil)
+		return
+	}
+	if mu.Preview != nil {
+		ui.DisplayPreview(mu.Room, *mu.Preview)
+	}
+}
+
+//presence of the users in a room, with who changed it if someone did
+type PresenceUpdate struct {
+	*shared.PresenceUpdate
+}
+func (pu *PresenceUpdate) ExecuteServer() {}
+func (pu *PresenceUpdate) ExecuteClient(ui shared.ClientUI) {
+	ui.SetMembers(pu.Room, pu.Users)
+	if pu.Changed != nil {
+		text := "SERVER: " + pu.Changed.User + " is now " + pu.Changed.State
+		if pu.Changed.Status != "" {
+			text += ": " + pu.Changed.Status
+		}
+		ui.Display(pu.Room, text, false)
+	}
+}
+
+//upload token the client asked for, successful ones are handed to the waiting upload instead of shown
+type UploadTokenCmd struct {
+	*shared.UploadTokenCmd
+}
+func (ut *UploadTokenCmd) ExecuteServer() {}
+func (ut *UploadTokenCmd) ExecuteClient(ui shared.ClientUI) {
+	if !ut.Status {
+		ui.Display(ut.CurrentRoom, ut.ErrMsg, false)
+	}
+}
+
+//room retention policy an admin asked to see or change
+type RetentionCmd struct {
+	*shared.RetentionCmd
+}
+func (r *RetentionCmd) ExecuteServer() {}
+func (r *RetentionCmd) ExecuteClient(ui shared.ClientUI) {
+	ui.Display(r.CurrentRoom, r.ErrMsg, false)
+}
+
+//backup the owner asked for, the archive stays on the server
+type BackupCmd struct {
+	*shared.BackupCmd
+}
+func (b *BackupCmd) ExecuteServer() {}
+func (b *BackupCmd) ExecuteClient(ui shared.ClientUI) {
+	ui.Display(b.CurrentRoom, b.ErrMsg, false)
+}
+
+//server log entries an admin searched for
+type AuditCmd struct {
+	*shared.AuditCmd
+}
+func (a *AuditCmd) ExecuteServer() {}
+func (a *AuditCmd) ExecuteClient(ui shared.ClientUI) {
+	ui.Display(a.CurrentRoom, a.ErrMsg, false)
+}
+
+//REST API token an admin asked for or revoked
+type APITokenCmd struct {
+	*shared.APITokenCmd
+}
+func (at *APITokenCmd) ExecuteServer() {}
+func (at *APITokenCmd) ExecuteClient(ui shared.ClientUI) {
+	ui.Display(at.CurrentRoom, at.ErrMsg, false)
+}
+
+//bot account an admin created, listed or changed
+type BotCmd struct {
+	*shared.BotCmd
+}
+func (b *BotCmd) ExecuteServer() {}
+func (b *BotCmd) ExecuteClient(ui shared.ClientUI) {
+	ui.Display(b.CurrentRoom, b.ErrMsg, false)
+}
+
+//webhooks an admin changed or looked at
+type WebhookCmd struct {
+	*shared.WebhookCmd
+}
+func (wh *WebhookCmd) ExecuteServer() {}
+func (wh *WebhookCmd) ExecuteClient(ui shared.ClientUI) {
+	ui.Display(wh.CurrentRoom, wh.ErrMsg, false)
+}
+
+//presence the user looked at or set
+type StatusCmd struct {
+	*shared.StatusCmd
+}
+func (st *StatusCmd) ExecuteServer() {}
+func (st *StatusCmd) ExecuteClient(ui shared.ClientUI) {
+	ui.Display(st.CurrentRoom, st.ErrMsg, false)
+}
+
+//ownership the user handed over
+type TransferOwnerCmd struct {
+	*shared.TransferOwnerCmd
+}
+func (t *TransferOwnerCmd) ExecuteServer() {}
+func (t *TransferOwnerCmd) ExecuteClient(ui shared.ClientUI) {
+	ui.Display(t.CurrentRoom, t.ErrMsg, false)
+}
+
+//custom role the owner changed or looked at
+type RoleCmd struct {
+	*shared.RoleCmd
+}
+func (r *RoleCmd) ExecuteServer() {}
+func (r *RoleCmd) ExecuteClient(ui shared.ClientUI) {
+	ui.Display(r.CurrentRoom, r.ErrMsg, false)
+}
+
+//reply to a command that only answers with text, or to one the user cannot run
+type CommandReply struct {
+	*shared.CommandReply
+}
+func (cr *CommandReply) ExecuteServer() {}
+func (cr *CommandReply) ExecuteClient(ui shared.ClientUI) {
+	ui.Display(cr.CurrentRoom, cr.ErrMsg, false)
+}
+
+//transcript the user exported, shown like an attachment so it can be saved
+type ExportCmd struct {
+	*shared.ExportCmd
+}
+func (e *ExportCmd) ExecuteServer() {}
+func (e *ExportCmd) ExecuteClient(ui shared.ClientUI) {
+	ui.Display(e.CurrentRoom, e.ErrMsg, false)
+	if e.Status && e.Transcript != nil {
+		ui.DisplayAttachment(e.CurrentRoom, *e.Transcript)
+	}
+}
+
+//client-only update about the connection to the server, never sent over the wire
+type connStatus struct {
+	room string
+	text string
+	//rooms the user can join after reconnecting
+	rooms []string
+	//set when the client gives up on the connection
+	quit bool
+}
+func (cs *connStatus) ExecuteServer() {}
+func (cs *connStatus) ExecuteClient(ui shared.ClientUI) {
+	if cs.quit {
+		ui.UserQuit(cs.text)
+		return
+	}
+	if cs.rooms != nil {
+		ui.SetRooms(cs.rooms)
+	}
+	if cs.text != "" {
+		ui.Display(cs.room, cs.text, false)
+	}
+}
+
 func ClearScreen() {
     fmt.Print("\033[2J\033[H\n")
 }
@@ -348,6 +558,9 @@ func formatMessage(broadcast bool, m *shared.Message, b *shared.BroadcastCmd) st
 		time := m.Timestamp.Format("2006-01-02 15:04:05")
 		if m.Flag {
 			resp = time + "\t\t" + m.UserName + m.Content
+		} else if m.Integration {
+			//posted by an external tool, not a user of that name
+			resp = time + "\t\t" + m.UserName + " [integration]:   " + m.Content
 		} else {
 			resp = time + "\t\t" + m.UserName + ":   " + m.Content
 		}
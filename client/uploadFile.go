@@ -0,0 +1,87 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"multi-room_chat_system/shared"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//address of the chat server's file server
+const fileServerURL = "http://localhost:8080"
+
+//UploadFileToServer sends any file as-is to the server's /attach endpoint and returns what the server stored
+func UploadFileToServer(serverURL, token, filePath string) (*shared.Attachment, error) {
+    file, err := os.Open(filePath)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    //stream the multipart form so large files are not held in memory
+    body, pw := io.Pipe()
+    writer := multipart.NewWriter(pw)
+    go func() {
+        part, err := writer.CreateFormFile("file", filepath.Base(filePath))
+        if err == nil {
+            _, err = io.Copy(part, file)
+        }
+        if err == nil {
+            err = writer.Close()
+        }
+        pw.CloseWithError(err)
+    }()
+
+    req, err := http.NewRequest("POST", serverURL+"/attach", body)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", writer.FormDataContentType())
+    req.Header.Set("Authorization", "Bearer " + token)
+
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        //the server explains why the file was refused (too large, type not allowed)
+        msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+        return nil, fmt.Errorf("upload failed: %s", strings.TrimSpace(string(msg)))
+    }
+
+    var attachment shared.Attachment
+    if err := json.NewDecoder(resp.Body).Decode(&attachment); err != nil {
+        return nil, err
+    }
+    return &attachment, nil
+}
+
+//DownloadFile saves an attachment to the writer the user picked
+func (c *ClientAdapter) DownloadFile(link string, dst io.Writer) error {
+    body, err := c.FetchFile(link)
+    if err != nil {
+        return err
+    }
+    defer body.Close()
+    _, err = io.Copy(dst, body)
+    return err
+}
+
+//helper function to format a file size for people
+func formatSize(size int64) string {
+    switch {
+    case size >= 1 << 20:
+        return fmt.Sprintf("%.1f MB", float64(size) / (1 << 20))
+    case size >= 1 << 10:
+        return fmt.Sprintf("%.1f KB", float64(size) / (1 << 10))
+    default:
+        return fmt.Sprintf("%d B", size)
+    }
+}
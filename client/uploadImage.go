@@ -1,17 +1,16 @@
 package client
 
 import (
-	"bytes"
+	"encoding/json"
 	"fmt"
-	"image"
-	"image/jpeg"
 	"io"
 	"mime/multipart"
+	"multi-room_chat_system/shared"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
-	"github.com/disintegration/imaging"
 	"github.com/google/uuid"
 )
 
@@ -27,56 +26,27 @@ func GenerateImageLink(baseURL, filename string) (string, string) {
     return fmt.Sprintf("%s/%s%s", baseURL, id, ext), id
 }
 
-//uploadImageToServer sends a file + UUID to the server's /upload endpoint
-func UploadImageToServer(serverURL, uuid, filePath string) (string, error) {
-    // Open the original file
+//uploadImageToServer sends an image as-is to the server's /upload endpoint, the server verifies and resizes it
+func UploadImageToServer(serverURL, token, filePath string) (string, error) {
     file, err := os.Open(filePath)
     if err != nil {
         return "", err
     }
     defer file.Close()
 
-    //decode the image
-    img, _, err := image.Decode(file)
-    if err != nil {
-        return "", fmt.Errorf("decode failed: %w", err)
-    }
-
-    //resize to max width 800px
-    resized := imaging.Resize(img, 800, 0, imaging.Lanczos)
-
-    //encode to JPEG with quality 70
-    buf := &bytes.Buffer{}
-    err = jpeg.Encode(buf, resized, &jpeg.Options{Quality: 70})
-    if err != nil {
-        return "", fmt.Errorf("encode failed: %w", err)
-    }
-
-    //prepare multipart form
-    body := &bytes.Buffer{}
-    writer := multipart.NewWriter(body)
-
-    //add UUID field
-    err = writer.WriteField("uuid", uuid)
-    if err != nil {
-        return "", err
-    }
-
-    //add file field (always .jpg now)
-    part, err := writer.CreateFormFile("file", uuid+".jpg")
-    if err != nil {
-        return "", err
-    }
-    _, err = io.Copy(part, buf)
-    if err != nil {
-        return "", err
-    }
-
-    //close writer
-    err = writer.Close()
-    if err != nil {
-        return "", err
-    }
+    //stream the multipart form so large images are not held in memory
+    body, pw := io.Pipe()
+    writer := multipart.NewWriter(pw)
+    go func() {
+        part, err := writer.CreateFormFile("file", filepath.Base(filePath))
+        if err == nil {
+            _, err = io.Copy(part, file)
+        }
+        if err == nil {
+            err = writer.Close()
+        }
+        pw.CloseWithError(err)
+    }()
 
     // Send POST request
     req, err := http.NewRequest("POST", serverURL+"/upload", body)
@@ -84,6 +54,7 @@ func UploadImageToServer(serverURL, uuid, filePath string) (string, error) {
         return "", err
     }
     req.Header.Set("Content-Type", writer.FormDataContentType())
+    req.Header.Set("Authorization", "Bearer " + token)
 
     resp, err := http.DefaultClient.Do(req)
     if err != nil {
@@ -92,10 +63,14 @@ func UploadImageToServer(serverURL, uuid, filePath string) (string, error) {
     defer resp.Body.Close()
 
     if resp.StatusCode != http.StatusOK {
-        return "", fmt.Errorf("upload failed: %s", resp.Status)
+        msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+        return "", fmt.Errorf("upload failed: %s", strings.TrimSpace(string(msg)))
     }
 
-    //build usable URL
-    usableURL := fmt.Sprintf("%s/uploads/%s.jpg", serverURL, uuid)
-    return usableURL, nil
-}
\ No newline at end of file
+    //the server names the stored image
+    var uploaded shared.UploadedImage
+    if err := json.NewDecoder(resp.Body).Decode(&uploaded); err != nil {
+        return "", err
+    }
+    return uploaded.URL, nil
+}
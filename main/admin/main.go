@@ -0,0 +1,14 @@
+package main
+
+import (
+	"fmt"
+	"multi-room_chat_system/server"
+	"os"
+)
+
+func main() {
+	if err := server.RunAdmin(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "admin:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"multi-room_chat_system/bot"
+	"multi-room_chat_system/shared"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+//longest reminder the bot keeps, reminders are lost if it restarts
+const maxReminder = 24 * time.Hour
+
+//example bot that echoes text and reminds people of things, create its account with "/bot create {name}"
+func main() {
+	addr := flag.String("addr", bot.DefaultAddr, "chat server address")
+	token := flag.String("token", os.Getenv("CHAT_BOT_TOKEN"), "bot token (default $CHAT_BOT_TOKEN)")
+	room := flag.String("room", "#general", "room to join")
+	flag.Parse()
+	if *token == "" {
+		fmt.Fprintln(os.Stderr, "bot: a token is required, an admin can create one with /bot create {name}")
+		os.Exit(2)
+	}
+
+	b := bot.New(*addr, *token)
+	b.Command("echo", func(b *bot.ClientAdapter, m *shared.Message, args []string) {
+		if len(args) == 0 {
+			b.Send("@" + m.UserName + " usage: !echo {text}")
+			return
+		}
+		b.Send(strings.Join(args, " "))
+	})
+	b.Command("remind", func(b *bot.ClientAdapter, m *shared.Message, args []string) {
+		if len(args) < 2 {
+			b.Send("@" + m.UserName + " usage: !remind {in, e.g. 10m} {text}")
+			return
+		}
+		in, err := time.ParseDuration(args[0])
+		if err != nil || in <= 0 || in > maxReminder {
+			b.Send("@" + m.UserName + " " + args[0] + " is not a time between 1s and 24h, e.g. 90s or 10m")
+			return
+		}
+		text := strings.Join(args[1:], " ")
+		time.AfterFunc(in, func() { b.Send("@" + m.UserName + " reminder: " + text) })
+		b.Send("@" + m.UserName + " I will remind you at " + time.Now().Add(in).Format("15:04:05"))
+	})
+	b.OnMention = func(b *bot.ClientAdapter, m *shared.Message) {
+		b.Send("@" + m.UserName + " try !echo {text} or !remind {in} {text}")
+	}
+	b.OnReply = func(b *bot.ClientAdapter, msg any) {
+		if join, ok := msg.(*shared.JoinCmd); ok && !join.Reply.Status {
+			log.Println("could not join", *room + ":", join.Reply.ErrMsg)
+		}
+	}
+
+	if err := b.Connect(); err != nil {
+		log.Fatal("bot: ", err)
+	}
+	if err := b.Join(*room); err != nil {
+		log.Fatal("bot: ", err)
+	}
+	//log out cleanly on ctrl-c
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		b.Close()
+	}()
+	if err := b.Run(); err != nil && !errors.Is(err, bot.ErrEnded) {
+		log.Fatal("bot: ", err)
+	}
+	log.Println("bot: session ended")
+}
@@ -1,7 +1,19 @@
 package main
 
-import "multi-room_chat_system/server"
+import (
+	"flag"
+	"log"
+	"multi-room_chat_system/server"
+)
 
 func main() {
+	restore := flag.String("restore", "", "backup archive to restore before starting")
+	flag.Parse()
+	//a restore replaces the saved state, so it has to happen before the server loads it
+	if *restore != "" {
+		if err := server.RestoreBackup(*restore); err != nil {
+			log.Fatal("Could not restore backup: ", err)
+		}
+	}
 	server.StartServer()
-}
\ No newline at end of file
+}
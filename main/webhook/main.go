@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"multi-room_chat_system/server"
+	"net/http"
+	"os"
+	"time"
+)
+
+//local stand-in for a webhook endpoint, prints each delivery and checks its signature
+func main() {
+	addr := flag.String("addr", "localhost:9090", "address to listen on")
+	secret := flag.String("secret", os.Getenv("CHAT_WEBHOOK_SECRET"), "the webhook's secret (default $CHAT_WEBHOOK_SECRET), deliveries are not checked without it")
+	status := flag.Int("status", http.StatusOK, "status to answer with, e.g. 500 to see retries")
+	flag.Parse()
+
+	http.HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1 << 20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		verified := "not checked"
+		if *secret != "" {
+			verified = "valid"
+			if !server.VerifyWebhook(*secret, body, r.Header.Get("X-Chat-Timestamp"), r.Header.Get("X-Chat-Signature"), time.Now()) {
+				verified = "INVALID"
+			}
+		}
+		var pretty bytes.Buffer
+		if json.Indent(&pretty, body, "", "  ") != nil {
+			pretty.Write(body)
+		}
+		fmt.Printf("%s delivery %s, signature %s\n%s\n", r.Header.Get("X-Chat-Event"), r.Header.Get("X-Chat-Delivery"), verified, pretty.String())
+		if verified == "INVALID" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(*status)
+	})
+	log.Println("webhook: listening on http://" + *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -0,0 +1,432 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//usage of the offline admin tool
+const adminUsage = `usage: admin [-state serverState.json] {command} [args]
+
+commands:
+	users                                   list users and their roles
+	adduser {user} [{role}]                 add a user (member, admin, owner or banned; default member)
+	removeuser {user}                       remove a user
+	setrole {user} {role}                   change a user's role, to a built-in or custom role
+	unban {user}                            make a banned user a member again
+	rooms                                   list rooms
+	createroom {room} {all or staff}        create a room
+	deleteroom {room}                       delete a room and its messages
+	renameroom {room} {new name}            rename a room, keeping its messages
+	prune {room or all} {max age or off} {max messages or off}
+	                                        remove old messages from rooms now, e.g. prune all 30d off
+	prunelog {max age}                      remove server log entries older than max age, e.g. prunelog 90d
+	validate                                check the file for problems
+`
+
+//names of the roles as they are typed into the admin tool
+var roleNames = map[Role]string{RoleBanned: "banned", RoleMember: "member", RoleAdmin: "admin", RoleOwner: "owner"}
+
+//function that runs the offline admin tool on a saved server state, the server has to be stopped to change it
+func RunAdmin(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("admin", flag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.Usage = func() { fmt.Fprint(out, adminUsage) }
+	path := flags.String("state", statePath, "saved server state to work on")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		return fmt.Errorf("no command given")
+	}
+	p, version, err := readState(*path)
+	if err != nil {
+		return err
+	}
+	if p.Users == nil {
+		p.Users = make(map[string]PersistUser)
+	}
+	if p.Rooms == nil {
+		p.Rooms = make(map[string]PersistRoom)
+	}
+
+	//files shared in rooms are recorded next to the state, by room name
+	accessPath := filepath.Join(filepath.Dir(*path), fileAccessPath)
+	access, err := readFileAccess(accessPath)
+	if err != nil {
+		return err
+	}
+
+	cmd, args := args[0], args[1:]
+	//the server writes its state back when it shuts down, which would undo any change
+	readOnly := cmd == "users" || cmd == "rooms" || cmd == "validate"
+	if !readOnly && serverRunning() {
+		return fmt.Errorf("the server is running, stop it before changing %s", *path)
+	}
+	changed, err := runAdminCmd(&p, access, cmd, args, out, time.Now())
+	if err != nil || !changed {
+		return err
+	}
+	//written first, a room renamed in the state but not here would lose its files
+	if access != nil {
+		if err := writeFileAccess(accessPath, access); err != nil {
+			return err
+		}
+	}
+	if version < stateVersion {
+		if err := backupState(*path, version); err != nil {
+			return err
+		}
+	}
+	return writeState(*path, p)
+}
+
+//function that runs one admin command on the state and the file records (nil when there are none), returns whether either was changed
+func runAdminCmd(p *PersistState, access map[string]*fileRecord, cmd string, args []string, out io.Writer, now time.Time) (bool, error) {
+	wantArgs := map[string][]int{
+		"users": {0}, "adduser": {1, 2}, "removeuser": {1}, "setrole": {2}, "unban": {1},
+		"rooms": {0}, "createroom": {2}, "deleteroom": {1}, "renameroom": {2},
+		"prune": {3}, "prunelog": {1}, "validate": {0},
+	}
+	counts, known := wantArgs[cmd]
+	if !known {
+		fmt.Fprint(out, adminUsage)
+		return false, fmt.Errorf("unknown command %q", cmd)
+	}
+	if !slices.Contains(counts, len(args)) {
+		fmt.Fprint(out, adminUsage)
+		return false, fmt.Errorf("wrong number of arguments for %s", cmd)
+	}
+
+	switch cmd {
+	case "users":
+		names := sortedKeys(p.Users)
+		for _, name := range names {
+			role := p.Users[name].roleName()
+			if p.Users[name].Bot {
+				fmt.Fprintf(out, "%s\t%s\tbot\n", name, role)
+				continue
+			}
+			fmt.Fprintf(out, "%s\t%s\n", name, role)
+		}
+		return false, nil
+	case "adduser":
+		name := args[0]
+		if !validName(name) || strings.HasPrefix(name, "#") || strings.HasPrefix(name, "/") {
+			return false, fmt.Errorf("invalid username %q", name)
+		}
+		if _, exists := p.Users[name]; exists {
+			return false, fmt.Errorf("user %s already exists", name)
+		}
+		for _, room := range p.Rooms {
+			for _, ih := range room.IncomingHooks {
+				if ih.Name == name {
+					return false, fmt.Errorf("%s is the name of an incoming webhook of %s", name, room.Name)
+				}
+			}
+		}
+		role, custom := RoleMember, ""
+		if len(args) == 2 {
+			var err error
+			if role, custom, err = parseAnyRole(p, args[1]); err != nil {
+				return false, err
+			}
+		}
+		p.Users[name] = PersistUser{Username: name, Role: role, CustomRole: custom}
+		fmt.Fprintf(out, "added %s as %s\n", name, p.Users[name].roleName())
+	case "removeuser":
+		user, err := findUser(p, args[0])
+		if err != nil {
+			return false, err
+		}
+		if user.Role == RoleOwner && countRole(p, RoleOwner) == 1 {
+			return false, fmt.Errorf("%s is the only owner and cannot be removed", user.Username)
+		}
+		delete(p.Users, args[0])
+		fmt.Fprintf(out, "removed %s\n", args[0])
+	case "setrole":
+		user, err := findUser(p, args[0])
+		if err != nil {
+			return false, err
+		}
+		role, custom, err := parseAnyRole(p, args[1])
+		if err != nil {
+			return false, err
+		}
+		if user.Role == RoleOwner && role != RoleOwner && countRole(p, RoleOwner) == 1 {
+			return false, fmt.Errorf("%s is the only owner and cannot lose that role", user.Username)
+		}
+		if user.Bot && role == RoleOwner {
+			return false, fmt.Errorf("%s is a bot and cannot be an owner", user.Username)
+		}
+		user.Role, user.CustomRole = role, custom
+		p.Users[args[0]] = user
+		fmt.Fprintf(out, "%s is now %s\n", args[0], user.roleName())
+	case "unban":
+		user, err := findUser(p, args[0])
+		if err != nil {
+			return false, err
+		}
+		if user.Role != RoleBanned {
+			return false, fmt.Errorf("%s is not banned", args[0])
+		}
+		user.Role = RoleMember
+		p.Users[args[0]] = user
+		fmt.Fprintf(out, "unbanned %s\n", args[0])
+	case "rooms":
+		for _, name := range sortedKeys(p.Rooms) {
+			room := p.Rooms[name]
+			access := "all"
+			if room.Permission >= RoleAdmin {
+				access = "staff"
+			}
+			maxCount := "off"
+			if room.MaxCount > 0 {
+				maxCount = strconv.Itoa(room.MaxCount)
+			}
+			fmt.Fprintf(out, "%s\t%s\t%d messages\tmax age %s, max messages %s\n", name, access, len(room.Log), formatRetentionAge(time.Duration(room.MaxAge)), maxCount)
+		}
+		return false, nil
+	case "createroom":
+		name := args[0]
+		if !validName(name) || !strings.HasPrefix(name, "#") {
+			return false, fmt.Errorf("invalid room name %q, must begin with '#'", name)
+		}
+		if _, exists := p.Rooms[name]; exists {
+			return false, fmt.Errorf("room %s already exists", name)
+		}
+		permission := convToRole(args[1])
+		if permission == RoleBanned {
+			return false, fmt.Errorf("invalid permission %q, must be 'all' or 'staff'", args[1])
+		}
+		p.Rooms[name] = PersistRoom{Name: name, Permission: permission, Log: make([]PersistMessage, 0)}
+		fmt.Fprintf(out, "created %s\n", name)
+	case "deleteroom":
+		if _, exists := p.Rooms[args[0]]; !exists {
+			return false, fmt.Errorf("room %s does not exist", args[0])
+		}
+		delete(p.Rooms, args[0])
+		renameFileRoom(access, args[0], "")
+		fmt.Fprintf(out, "deleted %s\n", args[0])
+	case "renameroom":
+		room, exists := p.Rooms[args[0]]
+		if !exists {
+			return false, fmt.Errorf("room %s does not exist", args[0])
+		}
+		name := args[1]
+		if !validName(name) || !strings.HasPrefix(name, "#") {
+			return false, fmt.Errorf("invalid room name %q, must begin with '#'", name)
+		}
+		if _, exists := p.Rooms[name]; exists {
+			return false, fmt.Errorf("room %s already exists", name)
+		}
+		delete(p.Rooms, args[0])
+		room.Name = name
+		p.Rooms[name] = room
+		//files shared in the room stay visible to its members
+		shared := renameFileRoom(access, args[0], name)
+		fmt.Fprintf(out, "renamed %s to %s (%d shared files)\n", args[0], name, shared)
+	case "prune":
+		maxAge, err := parseRetentionAge(args[1])
+		if err != nil {
+			return false, err
+		}
+		maxCount, err := parseRetentionCount(args[2])
+		if err != nil {
+			return false, err
+		}
+		names := []string{args[0]}
+		if args[0] == "all" {
+			names = sortedKeys(p.Rooms)
+		} else if _, exists := p.Rooms[args[0]]; !exists {
+			return false, fmt.Errorf("room %s does not exist", args[0])
+		}
+		pruned := 0
+		for _, name := range names {
+			room := p.Rooms[name]
+			cut := retentionCut(len(room.Log), maxAge, maxCount, now, func(i int) time.Time { return room.Log[i].Timestamp })
+			if cut == 0 {
+				continue
+			}
+			room.Log = append(make([]PersistMessage, 0, len(room.Log) - cut), room.Log[cut:]...)
+			p.Rooms[name] = room
+			p.Log = append(p.Log, Log{Event: fmt.Sprintf("%d messages pruned from %s by the admin tool", cut, name), Timestamp: now, Action: ActionPrune, Room: name})
+			fmt.Fprintf(out, "pruned %d messages from %s\n", cut, name)
+			pruned += cut
+		}
+		return pruned > 0, nil
+	case "prunelog":
+		maxAge, err := parseRetentionAge(args[0])
+		if err != nil || maxAge == 0 {
+			return false, fmt.Errorf("invalid max age %q", args[0])
+		}
+		cut := retentionCut(len(p.Log), maxAge, 0, now, func(i int) time.Time { return p.Log[i].Timestamp })
+		p.Log = append(make([]Log, 0, len(p.Log) - cut), p.Log[cut:]...)
+		fmt.Fprintf(out, "pruned %d server log entries\n", cut)
+		return cut > 0, nil
+	case "validate":
+		problems := append(validateState(p), validateFileAccess(p, access)...)
+		for _, problem := range problems {
+			fmt.Fprintln(out, problem)
+		}
+		if len(problems) > 0 {
+			return false, fmt.Errorf("found %d problems", len(problems))
+		}
+		fmt.Fprintf(out, "ok: %d users, %d rooms, %d server log entries\n", len(p.Users), len(p.Rooms), len(p.Log))
+		return false, nil
+	}
+	return true, nil
+}
+
+//function that lists everything in a saved state the server would trip over or that breaks its rules
+func validateState(p *PersistState) []string {
+	var problems []string
+	for _, name := range sortedKeys(p.Users) {
+		user := p.Users[name]
+		if user.Username != name {
+			problems = append(problems, fmt.Sprintf("user %s is saved under the name %q", name, user.Username))
+		}
+		if !validName(name) || strings.HasPrefix(name, "#") || strings.HasPrefix(name, "/") {
+			problems = append(problems, fmt.Sprintf("user %q has an invalid name", name))
+		}
+		if _, ok := roleNames[user.Role]; !ok {
+			problems = append(problems, fmt.Sprintf("user %s has unknown role %d", name, user.Role))
+		}
+		if user.CustomRole != "" && !slices.ContainsFunc(p.Roles, func(r customRole) bool { return r.Name == user.CustomRole }) {
+			problems = append(problems, fmt.Sprintf("user %s has custom role %s, which does not exist", name, user.CustomRole))
+		}
+		if user.Bot && user.Role == RoleOwner {
+			problems = append(problems, fmt.Sprintf("bot %s is an owner", name))
+		}
+		if user.Presence != "" && !slices.Contains(presenceStates, user.Presence) {
+			problems = append(problems, fmt.Sprintf("user %s has unknown presence %q", name, user.Presence))
+		}
+	}
+	if countRole(p, RoleOwner) == 0 {
+		problems = append(problems, "there is no owner, nobody can shut the server down")
+	}
+	for _, name := range sortedKeys(p.Rooms) {
+		room := p.Rooms[name]
+		if room.Name != name {
+			problems = append(problems, fmt.Sprintf("room %s is saved under the name %q", name, room.Name))
+		}
+		if !validName(name) || !strings.HasPrefix(name, "#") {
+			problems = append(problems, fmt.Sprintf("room %q has an invalid name", name))
+		}
+		if room.Permission != RoleMember && room.Permission != RoleAdmin {
+			problems = append(problems, fmt.Sprintf("room %s has invalid permission %d", name, room.Permission))
+		}
+		if room.MaxAge < 0 || room.MaxCount < 0 {
+			problems = append(problems, fmt.Sprintf("room %s has a negative retention policy", name))
+		}
+		//messages saved before ids existed have none and are numbered when loaded
+		var lastID int64
+		for i, msg := range room.Log {
+			if msg.ID == 0 {
+				continue
+			}
+			if msg.ID <= lastID {
+				problems = append(problems, fmt.Sprintf("room %s message %d has id %d, which is not after %d", name, i, msg.ID, lastID))
+			}
+			lastID = max(lastID, msg.ID)
+		}
+	}
+	return problems
+}
+
+//function that lists file records that point at rooms the state does not have
+func validateFileAccess(p *PersistState, access map[string]*fileRecord) []string {
+	var problems []string
+	for _, file := range sortedKeys(access) {
+		for _, room := range access[file].Rooms {
+			if _, exists := p.Rooms[room]; !exists {
+				problems = append(problems, fmt.Sprintf("file %s is shared in room %s, which does not exist", file, room))
+			}
+		}
+	}
+	return problems
+}
+
+//function that parses a role name typed into the admin tool
+func parseRoleName(name string) (Role, error) {
+	for role, roleName := range roleNames {
+		if roleName == name {
+			return role, nil
+		}
+	}
+	return RoleBanned, fmt.Errorf("unknown role %q, must be member, admin, owner or banned", name)
+}
+
+//function that parses a built-in or custom role typed into the admin tool, custom roles are given to members
+func parseAnyRole(p *PersistState, name string) (Role, string, error) {
+	role, err := parseRoleName(name)
+	if err == nil {
+		return role, "", nil
+	}
+	if slices.ContainsFunc(p.Roles, func(r customRole) bool { return r.Name == name }) {
+		return RoleMember, name, nil
+	}
+	return RoleBanned, "", fmt.Errorf("unknown role %q, must be member, admin, owner, banned or a custom role", name)
+}
+
+//function that returns the name of a saved user's role
+func (u PersistUser) roleName() string {
+	if u.CustomRole != "" {
+		return u.CustomRole
+	}
+	return roleNames[u.Role]
+}
+
+//function that returns a saved user
+func findUser(p *PersistState, name string) (PersistUser, error) {
+	user, exists := p.Users[name]
+	if !exists {
+		return user, fmt.Errorf("user %s does not exist", name)
+	}
+	return user, nil
+}
+
+//function that counts the saved users with a role
+func countRole(p *PersistState, role Role) int {
+	n := 0
+	for _, user := range p.Users {
+		if user.Role == role {
+			n++
+		}
+	}
+	return n
+}
+
+//function that checks a user or room name can be typed into a command
+func validName(name string) bool {
+	return name != "" && len(strings.Fields(name)) == 1 && name != "#"
+}
+
+//function that returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+//function that checks whether the chat server is listening, in which case it owns the saved state
+func serverRunning() bool {
+	listener, err := net.Listen("tcp", ":5461")
+	if err != nil {
+		return true
+	}
+	listener.Close()
+	return false
+}
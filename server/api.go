@@ -0,0 +1,383 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"multi-room_chat_system/shared"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+//largest request body the REST API reads
+const apiMaxBody = 1 << 20
+
+//messages GET /api/rooms/{room}/messages returns when no limit is given
+const apiHistoryLimit = 100
+
+//actions POST /api/users/{user}/{action} runs, each is the command of the same name
+var apiUserActions = []string{"kick", "ban", "unban", "promote", "demote", "transferowner"}
+
+//REST API RPC request (http handler -> server), Run is called on the server goroutine with the token's user
+type APIRequest struct {
+	Token string
+	Run func(user *Member, now time.Time) (int, any)
+	Resp chan apiResponse
+}
+
+//status and JSON body of a REST API reply
+type apiResponse struct {
+	status int
+	body any
+}
+
+//body of a REST API reply that failed
+type apiError struct {
+	Error string
+}
+
+//body of a REST API reply that ran a command, Message is what the command would have shown in the chat
+type apiResult struct {
+	Message string
+}
+
+//user as listed by GET /api/users
+type apiUser struct {
+	Username string
+	Role string
+	Active bool
+	Bot bool `json:",omitempty"`
+	Room string `json:",omitempty"`
+	Presence string
+	Status string `json:",omitempty"`
+}
+
+//room as listed by GET /api/rooms
+type apiRoom struct {
+	Name string
+	//"all" or "staff", as given to /create
+	Access string
+	Users []string
+}
+
+//body of POST /api/rooms
+type apiNewRoom struct {
+	Name string
+	Access string
+}
+
+//body of POST /api/rooms/{room}/messages
+type apiNewMessage struct {
+	Content string
+}
+
+//body of POST /api/users/{user}/{action}, the reason is only used by kick and ban, confirm by changes that involve an owner
+type apiUserAction struct {
+	Reason string
+	Confirm bool
+}
+
+//function that returns how an API token is kept, the token itself is only shown when it is issued
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+//function that returns the user an API token was issued to, nil if it is not a current token (server goroutine only)
+func (s *ServerState) apiUser(token string) *Member {
+	if token == "" {
+		return nil
+	}
+	hash := hashToken(token)
+	for name, stored := range s.apiTokens {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			return s.users[name]
+		}
+	}
+	return nil
+}
+
+//function that authenticates a REST API request and runs it (server goroutine only)
+func (s *ServerState) serveAPI(req APIRequest, now time.Time) apiResponse {
+	user := s.apiUser(req.Token)
+	if user == nil {
+		return apiResponse{http.StatusUnauthorized, apiError{"Invalid API token"}}
+	}
+	if user.Role == RoleBanned {
+		return apiResponse{http.StatusForbidden, apiError{"You are banned"}}
+	}
+	status, body := req.Run(user, now)
+	return apiResponse{status, body}
+}
+
+//function that runs a chat command as the API user, so it is checked and logged exactly like one typed in the chat (server goroutine only)
+func (s *ServerState) runAPICommand(user *Member, content string, now time.Time) (int, any) {
+	msg := CommandFactory(shared.MsgMetadata{UserName: user.Username, Content: content, Timestamp: now}, s)
+	msg.ExecuteServer()
+	var status bool
+	var text string
+	switch m := msg.(type) {
+	case *KickBanCmd:
+		status, text = m.Status, m.ErrMsg
+	case *UnBanCmd:
+		status, text = m.Status, m.ErrMsg
+	case *CreateCmd:
+		status, text = m.Status, m.ErrMsg
+	case *DeleteCmd:
+		status, text = m.Status, m.ErrMsg
+	case *PromoteDemoteCmd:
+		status, text = m.Status, m.ErrMsg
+	case *TransferOwnerCmd:
+		status, text = m.Status, m.ErrMsg
+	//the user cannot run the command
+	case *CommandReply:
+		status, text = m.Status, m.ErrMsg
+	default:
+		return http.StatusInternalServerError, apiError{"Unexpected command " + content}
+	}
+	if !status {
+		return http.StatusForbidden, apiError{text}
+	}
+	//the user's own client updates as if they had typed the command, e.g. a room they created appears in their list
+	if user.Active {
+		user.send(msg)
+	}
+	//a successful kick or ban has nothing to say to the sender in the chat
+	if text == "" {
+		text = "SERVER: " + content + " done"
+	}
+	return http.StatusOK, apiResult{text}
+}
+
+//REST API RPC stub, returns false once the server is shutting down
+func (s *ServerState) CallAPI(token string, run func(user *Member, now time.Time) (int, any)) (int, any, bool) {
+	req := APIRequest{Token: token, Run: run, Resp: make(chan apiResponse, 1)}
+	select {
+	case s.recvAPI <- req:
+		resp := <-req.Resp
+		return resp.status, resp.body, true
+	case <-s.term:
+		return 0, nil, false
+	}
+}
+
+//function that adds the REST API's routes to the file server
+func registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/users", apiListUsers)
+	mux.HandleFunc("POST /api/users/{user}/{action}", apiModerate)
+	mux.HandleFunc("GET /api/rooms", apiListRooms)
+	mux.HandleFunc("POST /api/rooms", apiCreateRoom)
+	mux.HandleFunc("DELETE /api/rooms/{room}", apiDeleteRoom)
+	mux.HandleFunc("GET /api/rooms/{room}/messages", apiHistory)
+	mux.HandleFunc("POST /api/rooms/{room}/messages", apiPost)
+}
+
+//function that runs a REST API request on the server goroutine and writes its reply
+func serveAPI(w http.ResponseWriter, r *http.Request, run func(user *Member, now time.Time) (int, any)) {
+	token := bearerToken(r)
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPI(w, http.StatusUnauthorized, apiError{"Missing API token"})
+		return
+	}
+	status, body, ok := GetServerState().CallAPI(token, run)
+	if !ok {
+		writeAPI(w, http.StatusServiceUnavailable, apiError{"Server is shutting down"})
+		return
+	}
+	writeAPI(w, status, body)
+}
+
+//function that writes a REST API reply as JSON
+func writeAPI(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+//function that reads a request's JSON body, an empty body leaves v as it is
+func readAPIBody(w http.ResponseWriter, r *http.Request, v any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, apiMaxBody)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+//function that reads a room from the request's path, the leading '#' can be left out so it need not be escaped
+func apiRoomName(r *http.Request) string {
+	room := r.PathValue("room")
+	if !strings.HasPrefix(room, "#") {
+		room = "#" + room
+	}
+	return room
+}
+
+//function that checks a name taken from a request can be passed on as one word of a command
+func apiWord(name string) bool {
+	return name != "" && !strings.ContainsFunc(name, unicode.IsSpace)
+}
+
+//GET /api/users: every user with their role and the room they are in (staff)
+func apiListUsers(w http.ResponseWriter, r *http.Request) {
+	serveAPI(w, r, func(user *Member, now time.Time) (int, any) {
+		s := GetServerState()
+		if !user.isStaff() {
+			return http.StatusForbidden, apiError{"PERMISSION DENIED: You do not have permission to list users"}
+		}
+		users := make([]apiUser, 0, len(s.users))
+		for _, name := range sortedKeys(s.users) {
+			u := s.users[name]
+			p := s.presenceOf(name)
+			users = append(users, apiUser{Username: name, Role: s.roleName(name, u.Role), Active: u.Active, Bot: u.Bot, Room: u.CurrentRoom, Presence: p.State, Status: p.Status})
+		}
+		return http.StatusOK, users
+	})
+}
+
+//GET /api/rooms: every room with who is in it (staff)
+func apiListRooms(w http.ResponseWriter, r *http.Request) {
+	serveAPI(w, r, func(user *Member, now time.Time) (int, any) {
+		s := GetServerState()
+		if !user.isStaff() {
+			return http.StatusForbidden, apiError{"PERMISSION DENIED: You do not have permission to list rooms"}
+		}
+		rooms := make([]apiRoom, 0, len(s.rooms))
+		for _, name := range sortedKeys(s.rooms) {
+			access := "all"
+			if s.rooms[name].permission > RoleMember {
+				access = "staff"
+			}
+			members := s.rooms[name].members()
+			if members == nil {
+				members = make([]string, 0)
+			}
+			rooms = append(rooms, apiRoom{Name: name, Access: access, Users: members})
+		}
+		return http.StatusOK, rooms
+	})
+}
+
+//POST /api/rooms: create a room, as /create
+func apiCreateRoom(w http.ResponseWriter, r *http.Request) {
+	var body apiNewRoom
+	if err := readAPIBody(w, r, &body); err != nil {
+		writeAPI(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+	if !apiWord(body.Name) || !apiWord(body.Access) {
+		writeAPI(w, http.StatusBadRequest, apiError{"Name and Access (all or staff) are required"})
+		return
+	}
+	serveAPI(w, r, func(user *Member, now time.Time) (int, any) {
+		return GetServerState().runAPICommand(user, "/create " + body.Name + " " + body.Access, now)
+	})
+}
+
+//DELETE /api/rooms/{room}: delete a room, as /delete
+func apiDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	room := apiRoomName(r)
+	if !apiWord(room) {
+		writeAPI(w, http.StatusBadRequest, apiError{"Invalid room " + room})
+		return
+	}
+	serveAPI(w, r, func(user *Member, now time.Time) (int, any) {
+		return GetServerState().runAPICommand(user, "/delete " + room, now)
+	})
+}
+
+//POST /api/users/{user}/{action}: kick, ban, unban, promote or demote a user, as the command of the same name
+func apiModerate(w http.ResponseWriter, r *http.Request) {
+	target, action := r.PathValue("user"), r.PathValue("action")
+	if !contains(apiUserActions, action) {
+		writeAPI(w, http.StatusNotFound, apiError{"Unknown action " + action + ", must be one of " + strings.Join(apiUserActions, ", ")})
+		return
+	}
+	var body apiUserAction
+	if err := readAPIBody(w, r, &body); err != nil {
+		writeAPI(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+	if !apiWord(target) {
+		writeAPI(w, http.StatusBadRequest, apiError{"Invalid user " + target})
+		return
+	}
+	content := "/" + action + " " + target
+	if reason := strings.Join(strings.Fields(body.Reason), " "); reason != "" && (action == "kick" || action == "ban") {
+		content += " " + reason
+	}
+	if body.Confirm && (action == "promote" || action == "demote" || action == "transferowner") {
+		content += " " + confirmWord
+	}
+	serveAPI(w, r, func(user *Member, now time.Time) (int, any) {
+		return GetServerState().runAPICommand(user, content, now)
+	})
+}
+
+//GET /api/rooms/{room}/messages?limit={n}: the latest messages of a room the user can join
+func apiHistory(w http.ResponseWriter, r *http.Request) {
+	room := apiRoomName(r)
+	limit := apiHistoryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeAPI(w, http.StatusBadRequest, apiError{"limit must be a positive number"})
+			return
+		}
+		limit = n
+	}
+	serveAPI(w, r, func(user *Member, now time.Time) (int, any) {
+		rm, exists := GetServerState().rooms[room]
+		if !exists {
+			return http.StatusNotFound, apiError{"PERMISSION DENIED: Room " + room + " does not exist"}
+		}
+		if !user.allowedIn(rm.permission) {
+			return http.StatusForbidden, apiError{"PERMISSION DENIED: User role does not have access to room"}
+		}
+		history := rm.history()
+		if len(history) > limit {
+			history = history[len(history) - limit:]
+		}
+		//messages are listed as they are in a json transcript
+		return http.StatusOK, newTranscript(room, history, time.Time{}, time.Time{}, now).Messages
+	})
+}
+
+//POST /api/rooms/{room}/messages: post a message to a room the user can join, without joining it
+func apiPost(w http.ResponseWriter, r *http.Request) {
+	room := apiRoomName(r)
+	var body apiNewMessage
+	if err := readAPIBody(w, r, &body); err != nil {
+		writeAPI(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+	if strings.TrimSpace(body.Content) == "" {
+		writeAPI(w, http.StatusBadRequest, apiError{"Content is required"})
+		return
+	}
+	serveAPI(w, r, func(user *Member, now time.Time) (int, any) {
+		rm, exists := GetServerState().rooms[room]
+		if !exists {
+			return http.StatusNotFound, apiError{"PERMISSION DENIED: Room " + room + " does not exist"}
+		}
+		if !user.allowedIn(rm.permission) {
+			return http.StatusForbidden, apiError{"PERMISSION DENIED: User role does not have access to room"}
+		}
+		m := &Message{Message: &shared.Message{MsgMetadata: shared.MsgMetadata{UserName: user.Username, Timestamp: now, Content: body.Content}}}
+		if !rm.postExternal(m) {
+			return http.StatusNotFound, apiError{"PERMISSION DENIED: Room " + room + " does not exist"}
+		}
+		//links are inspected afterwards, as for messages posted in the chat
+		inspectLater(rm, m.Message)
+		return http.StatusCreated, newTranscript(room, []shared.Message{*m.Message}, time.Time{}, time.Time{}, now).Messages[0]
+	})
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//function that serves the REST API of the test server, answering API requests the way the server goroutine does
+func newTestAPI(t *testing.T, s *ServerState) *httptest.Server {
+	s.recvAPI = make(chan APIRequest)
+	go func() {
+		for {
+			select {
+			case req := <-s.recvAPI:
+				req.Resp <- s.serveAPI(req, time.Now())
+			case <-s.term:
+				return
+			}
+		}
+	}()
+	mux := http.NewServeMux()
+	registerAPI(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		srv.Close()
+		close(s.term)
+	})
+	return srv
+}
+
+//function that sends a REST API request with the given token, "" sends none, and returns the status and body
+func callTestAPI(t *testing.T, srv *httptest.Server, method string, path string, token string, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL + path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer " + token)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestAPIAuth(t *testing.T) {
+	s := newTestServer(t)
+	addTestRoom(t, s, "#general", RoleMember)
+	addTestRoom(t, s, "#staff", RoleAdmin)
+	for name, role := range map[string]Role{"owner": RoleOwner, "admin": RoleAdmin, "bob": RoleMember, "gone": RoleBanned} {
+		addTestUser(s, name, role)
+		s.apiTokens[name] = hashToken(name + "-token")
+	}
+	srv := newTestAPI(t, s)
+
+	tests := []struct {
+		name string
+		method, path, token, body string
+		status int
+		want string
+	}{
+		{"no token", "GET", "/api/users", "", "", http.StatusUnauthorized, "Missing API token"},
+		{"unknown token", "GET", "/api/users", "nope", "", http.StatusUnauthorized, "Invalid API token"},
+		{"token hash", "GET", "/api/users", hashToken("admin-token"), "", http.StatusUnauthorized, "Invalid API token"},
+		{"banned", "GET", "/api/rooms/general/messages", "gone-token", "", http.StatusForbidden, "banned"},
+		{"member lists users", "GET", "/api/users", "bob-token", "", http.StatusForbidden, "PERMISSION DENIED"},
+		{"admin lists users", "GET", "/api/users", "admin-token", "", http.StatusOK, `"Username":"bob"`},
+		{"member reads staff room", "GET", "/api/rooms/staff/messages", "bob-token", "", http.StatusForbidden, "does not have access"},
+		{"member posts", "POST", "/api/rooms/general/messages", "bob-token", `{"Content":"hi from the api"}`, http.StatusCreated, `"Content":"hi from the api"`},
+		{"member bans", "POST", "/api/users/admin/ban", "bob-token", "", http.StatusForbidden, "PERMISSION DENIED"},
+		{"admin bans owner", "POST", "/api/users/owner/ban", "admin-token", "", http.StatusForbidden, "Only owners"},
+		{"unknown field", "POST", "/api/rooms", "admin-token", `{"Name":"#new","Access":"all","Extra":1}`, http.StatusBadRequest, "invalid request body"},
+		{"admin creates room", "POST", "/api/rooms", "admin-token", `{"Name":"#new","Access":"all"}`, http.StatusOK, "#new"},
+		{"unknown action", "POST", "/api/users/bob/explode", "admin-token", "", http.StatusNotFound, "Unknown action"},
+	}
+	for _, tt := range tests {
+		status, body := callTestAPI(t, srv, tt.method, tt.path, tt.token, tt.body)
+		if status != tt.status || !strings.Contains(body, tt.want) {
+			t.Errorf("%s: %d %s, want %d containing %q", tt.name, status, body, tt.status, tt.want)
+		}
+	}
+
+	//the posted message is in the room's history
+	status, body := callTestAPI(t, srv, "GET", "/api/rooms/general/messages?limit=1", "bob-token", "")
+	var history []transcriptEntry
+	if err := json.Unmarshal([]byte(body), &history); status != http.StatusOK || err != nil {
+		t.Fatalf("history %d %s", status, body)
+	}
+	if len(history) != 1 || history[0].Username != "bob" || history[0].Content != "hi from the api" {
+		t.Fatalf("history %+v", history)
+	}
+	rm, exists := s.rooms["#new"]
+	if !exists {
+		t.Fatal("room created through the API is missing")
+	}
+	rm.stop()
+}
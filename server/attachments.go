@@ -0,0 +1,260 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"multi-room_chat_system/shared"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"github.com/google/uuid"
+)
+
+//where attachments are stored, kept apart from ./uploads so they are only served through the download handler
+const attachmentDir = "attachments"
+
+//address clients download attachments from
+const fileServerURL = "http://localhost:8080"
+
+//prefix of the chat line a client sends to post an uploaded attachment: "file:{id}"
+const attachmentPrefix = "file:"
+
+//attachment as kept in the index, along with where its contents are stored
+type storedAttachment struct {
+	shared.Attachment
+	//sha256 of the contents, empty for attachments stored before uploads with the same contents were shared
+	Hash string `json:",omitempty"`
+}
+
+//index of every stored attachment, shared by the http handlers and the rooms
+type attachmentIndex struct {
+	mu sync.Mutex
+	files map[string]storedAttachment
+}
+
+//attachments that have been uploaded
+var attachments = &attachmentIndex{files: make(map[string]storedAttachment)}
+
+//function that loads the attachment index, a missing index means nothing has been uploaded yet
+func loadAttachments() error {
+	if err := os.MkdirAll(attachmentDir, 0755); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(attachmentDir, "index.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	attachments.mu.Lock()
+	defer attachments.mu.Unlock()
+	return json.Unmarshal(data, &attachments.files)
+}
+
+//function that records a new attachment and writes the index back to disk
+func (idx *attachmentIndex) add(a storedAttachment) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.files[a.ID] = a
+	return idx.save()
+}
+
+//function that forgets attachments that were garbage collected
+func (idx *attachmentIndex) remove(ids []string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, id := range ids {
+		delete(idx.files, id)
+	}
+	return idx.save()
+}
+
+//function that writes the index back to disk (caller holds the lock)
+func (idx *attachmentIndex) save() error {
+	data, err := json.MarshalIndent(idx.files, "", " ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(attachmentDir, "index.json"), data, 0644)
+}
+
+//function that looks up an attachment by id
+func (idx *attachmentIndex) get(id string) (storedAttachment, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	a, ok := idx.files[id]
+	return a, ok
+}
+
+//function that returns the file holding an attachment's contents
+func (a storedAttachment) blob() string {
+	if a.Hash == "" {
+		return filepath.Join(attachmentDir, a.ID)
+	}
+	return filepath.Join(attachmentDir, a.Hash)
+}
+
+//function that checks a sniffed content type against the allowed types, "image/*" style patterns match a whole family
+func allowedType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range config.AllowedTypes {
+		if allowed == mediaType || allowed == "*/*" {
+			return true
+		}
+		if family, ok := strings.CutSuffix(allowed, "/*"); ok && strings.HasPrefix(mediaType, family + "/") {
+			return true
+		}
+	}
+	return false
+}
+
+//upload an attachment, the type is sniffed from the content rather than trusted from the client
+func attachmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	//only users holding an upload token issued over their chat connection can upload
+	username, ok := files.claimToken(bearerToken(r))
+	if !ok {
+		http.Error(w, "Invalid or expired upload token", http.StatusUnauthorized)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, config.MaxUploadSize + 1 << 20)
+	file, handler, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Could not get file (files may be at most " + formatSize(config.MaxUploadSize) + ")", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	if handler.Size > config.MaxUploadSize {
+		http.Error(w, "File is larger than " + formatSize(config.MaxUploadSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	//sniff the type from the first bytes
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		http.Error(w, "Could not read file", http.StatusBadRequest)
+		return
+	}
+	contentType := http.DetectContentType(head[:n])
+	if !allowedType(contentType) {
+		http.Error(w, "Files of type " + contentType + " are not allowed", http.StatusUnsupportedMediaType)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, "Could not read file", http.StatusInternalServerError)
+		return
+	}
+
+	//contents are stored under their hash so uploads of the same file share it
+	tmp, hash, size, err := spoolFile(file, attachmentDir)
+	if err != nil {
+		log.Println("Error writing attachment:", err)
+		http.Error(w, "Could not write file", http.StatusInternalServerError)
+		return
+	}
+	blob := filepath.Join(attachmentDir, hash)
+	release, ok := files.reserve(username, blob, size)
+	if !ok {
+		os.Remove(tmp)
+		http.Error(w, "Storage quota of " + formatSize(config.UserQuota) + " would be exceeded", http.StatusInsufficientStorage)
+		return
+	}
+	defer release()
+	if err := commitFile(tmp, blob); err != nil {
+		log.Println("Error storing attachment:", err)
+		http.Error(w, "Could not save file", http.StatusInternalServerError)
+		return
+	}
+	id := uuid.New().String()
+	a := storedAttachment{
+		Attachment: shared.Attachment{
+			ID: id,
+			Name: path.Base(filepath.ToSlash(handler.Filename)),
+			Size: size,
+			Type: contentType,
+			URL: fileServerURL + "/files/" + id,
+		},
+		Hash: hash,
+	}
+	if err := attachments.add(a); err != nil {
+		log.Println("Error saving attachment index:", err)
+		http.Error(w, "Could not save file", http.StatusInternalServerError)
+		return
+	}
+	files.add(fileKey(a.URL), username, size, time.Now())
+	metrics.uploaded("attachment", size)
+	log.Println("attachment uploaded:", a.Name, a.Type, formatSize(a.Size))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.Attachment)
+}
+
+//download an attachment under its original name
+func downloadHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/files/")
+	a, ok := attachments.get(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := os.Open(a.blob())
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", a.Type)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+	//never let a browser run an uploaded file as something else
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, a.Name, info.ModTime(), file)
+}
+
+//attachment factory, takes in message metadata and the server state, returns an executableMessage
+func AttachmentFactory(input shared.MsgMetadata, s *ServerState) shared.ExecutableMessage {
+	id := strings.TrimSpace(strings.TrimPrefix(input.Content, attachmentPrefix))
+	msg := &Message{Message: &shared.Message{MsgMetadata: input}}
+	a, ok := attachments.get(id)
+	if !ok {
+		msg.Response = shared.ResponseMD{Status: false, ErrMsg: "PERMISSION DENIED: Attachment does not exist"}
+		return msg
+	}
+	if !files.owns(fileKey(a.URL), input.UserName) {
+		msg.Response = shared.ResponseMD{Status: false, ErrMsg: "PERMISSION DENIED: You can only post files you uploaded"}
+		return msg
+	}
+	msg.Attachment = &a.Attachment
+	//readable in clients that do not show attachments (e.g. IRC)
+	msg.Content = fmt.Sprintf("[file] %s (%s) %s", a.Name, formatSize(a.Size), a.URL)
+	return msg
+}
+
+//helper function to format a file size for people
+func formatSize(size int64) string {
+	switch {
+	case size >= 1 << 20:
+		return fmt.Sprintf("%.1f MB", float64(size) / (1 << 20))
+	case size >= 1 << 10:
+		return fmt.Sprintf("%.1f KB", float64(size) / (1 << 10))
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
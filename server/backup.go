@@ -0,0 +1,294 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"multi-room_chat_system/shared"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//where /backup writes its archives and where a restore keeps what it replaced
+const backupDir = "backups"
+
+//everything a backup holds besides the saved state, a restore replaces those the archive has a copy of
+var backupContents = []string{fileAccessPath, "uploads", attachmentDir}
+
+//backup whose state was taken on the server goroutine, written to disk off it
+type backupJob struct {
+	User string
+	Name string
+	//the saved state and the file records, as they were when the backup was asked for
+	Records map[string][]byte
+	//stored files the rooms refer to, they never change once written
+	Files []string
+}
+
+//backup RPC request (backup writer -> server), sent once the archive is written or failed
+type BackupResult struct {
+	Job *backupJob
+	Size int64
+	Err error
+}
+
+//function that takes what a backup needs while the server's state cannot change (server goroutine only)
+func (s *ServerState) prepareBackup(username string, now time.Time) (*backupJob, error) {
+	state, err := encodeState(s.snapshot())
+	if err != nil {
+		return nil, err
+	}
+	records := map[string][]byte{statePath: state}
+	files.mu.Lock()
+	records[fileAccessPath], err = json.MarshalIndent(files.files, "", " ")
+	files.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	attachments.mu.Lock()
+	records[filepath.Join(attachmentDir, "index.json")], err = json.MarshalIndent(attachments.files, "", " ")
+	attachments.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Join(backupDir, "backup-" + now.Format("20060102-150405") + ".tar.gz")
+	return &backupJob{User: username, Name: name, Records: records, Files: backupFiles(s.fileRefs())}, nil
+}
+
+//function that writes a backup off the server goroutine and reports how it went back to the server
+func (s *ServerState) writeBackupLater(job *backupJob) {
+	go func() {
+		size, err := job.write()
+		select {
+		case s.recvBackup <- BackupResult{Job: job, Size: size, Err: err}:
+		case <-s.term:
+		}
+	}()
+}
+
+//function that writes the archive, returns its size
+func (job *backupJob) write() (int64, error) {
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(backupDir, "backup-*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	err = writeArchive(tmp, job.Records, job.Files)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), job.Name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	info, err := os.Stat(job.Name)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+//function that reports a finished backup to the admin who asked for it and logs it (server goroutine only)
+func (s *ServerState) finishBackup(res BackupResult, now time.Time) {
+	s.backingUp = false
+	notice := &BackupCmd{BackupCmd: &shared.BackupCmd{}}
+	if res.Err != nil {
+		log.Println("Error writing backup:", res.Err)
+		notice.ErrMsg = "SERVER: Could not write the backup"
+	} else {
+		notice.Status = true
+		notice.Path, notice.Size = res.Job.Name, res.Size
+		notice.ErrMsg = "SERVER: backup written to " + res.Job.Name + " (" + formatSize(res.Size) + ")"
+		s.audit(Log{Event: "Server backed up to " + res.Job.Name + " by " + res.Job.User, Timestamp: now, Actor: res.Job.User, Action: ActionBackup, Target: res.Job.Name})
+	}
+	if user := s.users[res.Job.User]; user != nil && user.Active {
+		notice.CurrentRoom = user.CurrentRoom
+		user.send(notice)
+	}
+}
+
+//function that lists the stored files a backup needs: the contents and thumbnails the rooms refer to
+func backupFiles(refs map[string]bool) []string {
+	var names []string
+	for file := range refs {
+		names = append(names, blobPath(file))
+		if strings.HasPrefix(file, "uploads/") {
+			for _, width := range thumbnailWidths {
+				names = append(names, filepath.Join("uploads", thumbnailDir, strconv.Itoa(width), path.Base(file)))
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+//function that writes the records held in memory and the given files into a gzipped tar, files that do not exist are left out
+func writeArchive(w io.Writer, records map[string][]byte, names []string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	var err error
+	for _, name := range sortedKeys(records) {
+		if err != nil {
+			break
+		}
+		err = tw.WriteHeader(&tar.Header{Name: filepath.ToSlash(name), Mode: 0644, Size: int64(len(records[name])), ModTime: time.Now()})
+		if err == nil {
+			_, err = tw.Write(records[name])
+		}
+	}
+	for _, name := range names {
+		if err != nil {
+			break
+		}
+		err = addToArchive(tw, name)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	return err
+}
+
+//function that copies one file into an archive under its path relative to the server's directory
+func addToArchive(tw *tar.Writer, name string) error {
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(name)
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+//function that replaces the server's state and stored files with a backup, must run before the server starts
+func RestoreBackup(archive string) error {
+	staging, err := os.MkdirTemp(".", "restore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	if err := extractArchive(archive, staging); err != nil {
+		return fmt.Errorf("reading %s: %w", archive, err)
+	}
+
+	//refuse a backup the server could not load
+	p, _, err := readState(filepath.Join(staging, statePath))
+	if err != nil {
+		return fmt.Errorf("%s does not hold a usable %s: %w", archive, statePath, err)
+	}
+	if problems := validateState(&p); len(problems) > 0 {
+		return fmt.Errorf("%s holds an invalid %s: %s", archive, statePath, strings.Join(problems, "; "))
+	}
+
+	//keep what is replaced so the restore can be undone by hand, what the archive has no copy of stays in place
+	aside := filepath.Join(backupDir, "pre-restore-" + time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(aside, 0755); err != nil {
+		return err
+	}
+	for _, name := range append([]string{statePath}, backupContents...) {
+		if _, err := os.Stat(filepath.Join(staging, name)); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(name, filepath.Join(aside, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Rename(filepath.Join(staging, name), name); err != nil {
+			return err
+		}
+	}
+	log.Println("restored", archive, "with", len(p.Users), "users and", len(p.Rooms), "rooms, the previous state was moved to", aside)
+	return nil
+}
+
+//function that unpacks a backup into dir, anything that is not part of a backup is refused
+func extractArchive(archive string, dir string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	hasState := false
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("unexpected entry %q", header.Name)
+		}
+		name := path.Clean(header.Name)
+		if !backupEntry(name) {
+			return fmt.Errorf("unexpected file %q", header.Name)
+		}
+		hasState = hasState || name == statePath
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(dst, os.O_CREATE | os.O_EXCL | os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		os.Chtimes(dst, header.ModTime, header.ModTime)
+	}
+	if !hasState {
+		return fmt.Errorf("no %s in the archive", statePath)
+	}
+	return nil
+}
+
+//function that checks an archive entry is one of the files a backup holds
+func backupEntry(name string) bool {
+	if name == statePath || name == fileAccessPath {
+		return true
+	}
+	if !filepath.IsLocal(name) {
+		return false
+	}
+	return strings.HasPrefix(name, "uploads/") || strings.HasPrefix(name, attachmentDir + "/")
+}
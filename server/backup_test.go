@@ -0,0 +1,122 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//function that returns a saved state with an owner, encoded as it is written to disk
+func testState(t *testing.T, owner string) []byte {
+	data, err := encodeState(PersistState{Users: map[string]PersistUser{owner: {Username: owner, Role: RoleOwner}}, Rooms: map[string]PersistRoom{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+//function that writes a gzipped tar holding the given entries, with the given types
+func writeTestArchive(t *testing.T, name string, entries map[string]string, types map[string]byte) {
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	for _, entry := range sortedKeys(entries) {
+		header := &tar.Header{Name: entry, Mode: 0644, Size: int64(len(entries[entry])), Typeflag: tar.TypeReg}
+		if typ, ok := types[entry]; ok {
+			header.Typeflag, header.Size, header.Linkname = typ, 0, "/etc/passwd"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			tw.Write([]byte(entries[entry]))
+		}
+	}
+	tw.Close()
+	gz.Close()
+}
+
+func TestRestoreBackup(t *testing.T) {
+	t.Chdir(t.TempDir())
+	os.WriteFile(statePath, testState(t, "current"), 0644)
+	os.WriteFile(fileAccessPath, []byte("{}"), 0644)
+	os.MkdirAll("uploads", 0755)
+	os.WriteFile("uploads/kept.png", []byte("current upload"), 0644)
+
+	//a backup written by /backup, without the file records
+	archive, err := os.Create("backup.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeArchive(archive, map[string][]byte{statePath: testState(t, "restored"), "uploads/cat.png": []byte("backed up upload")}, nil)
+	archive.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RestoreBackup("backup.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	p, _, err := readState(statePath)
+	if err != nil || p.Users["restored"].Role != RoleOwner {
+		t.Fatalf("restored state %+v, %v", p.Users, err)
+	}
+	if data, _ := os.ReadFile("uploads/cat.png"); string(data) != "backed up upload" {
+		t.Fatalf("upload not restored: %q", data)
+	}
+	if _, err := os.Stat("uploads/kept.png"); !os.IsNotExist(err) {
+		t.Fatal("uploads are replaced as a whole")
+	}
+	//what the backup has no copy of stays in place, what it replaced is moved aside
+	if _, err := os.Stat(fileAccessPath); err != nil {
+		t.Fatal("file records without a copy in the backup were removed")
+	}
+	aside, _ := filepath.Glob(filepath.Join(backupDir, "pre-restore-*", statePath))
+	if len(aside) != 1 {
+		t.Fatalf("previous state not kept: %v", aside)
+	}
+	if p, _, err := readState(aside[0]); err != nil || p.Users["current"].Role != RoleOwner {
+		t.Fatalf("kept state %+v, %v", p.Users, err)
+	}
+}
+
+func TestRestoreBackupRefusesBadArchives(t *testing.T) {
+	t.Chdir(t.TempDir())
+	state := string(testState(t, "restored"))
+	noOwner := strings.Replace(state, `"Role": 3`, `"Role": 1`, 1)
+	tests := []struct {
+		name string
+		entries map[string]string
+		types map[string]byte
+		want string
+	}{
+		{"no state", map[string]string{"uploads/a.png": "x"}, nil, "no " + statePath},
+		{"outside the server", map[string]string{statePath: state, "../evil": "x"}, nil, "unexpected file"},
+		{"absolute path", map[string]string{statePath: state, "/etc/evil": "x"}, nil, "unexpected file"},
+		{"not a backup file", map[string]string{statePath: state, "serverConfig.json": "{}"}, nil, "unexpected file"},
+		{"symlink", map[string]string{statePath: state, "uploads/link": ""}, map[string]byte{"uploads/link": tar.TypeSymlink}, "unexpected entry"},
+		{"unreadable state", map[string]string{statePath: "not json"}, nil, "usable"},
+		{"invalid state", map[string]string{statePath: noOwner}, nil, "no owner"},
+	}
+	os.WriteFile(statePath, testState(t, "current"), 0644)
+	for _, tt := range tests {
+		writeTestArchive(t, "bad.tar.gz", tt.entries, tt.types)
+		err := RestoreBackup("bad.tar.gz")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: %v, want %q", tt.name, err, tt.want)
+		}
+		if p, _, err := readState(statePath); err != nil || p.Users["current"].Role != RoleOwner {
+			t.Fatalf("%s: state was replaced", tt.name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join("..", "evil")); !os.IsNotExist(err) {
+		t.Fatal("archive wrote outside the server's directory")
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"multi-room_chat_system/shared"
+	"strings"
+	"time"
+)
+
+//function that returns who a login line logs in as and why it is refused, if it is, bots send "/bot {token}" instead of their name (server goroutine only)
+func (s *ServerState) loginName(line string) (string, string) {
+	token, isBot := strings.CutPrefix(line, shared.BotLoginCmd + " ")
+	if !isBot {
+		//nobody can pass themselves off as a bot by typing its name
+		user, exists := s.users[line]
+		if exists && user.Bot {
+			return line, "PERMISSION DENIED: " + line + " is a bot account, bots log in with their token\n>"
+		}
+		//nor as an integration by registering its name
+		if !exists && s.integrationName(line) {
+			return line, "PERMISSION DENIED: " + line + " is the name of an incoming webhook, pick another name\n>"
+		}
+		return line, ""
+	}
+	user := s.apiUser(strings.TrimSpace(token))
+	if user == nil || !user.Bot {
+		return "", "PERMISSION DENIED: Invalid bot token\n>"
+	}
+	return user.Username, ""
+}
+
+//function that creates a bot account, returns its token (server goroutine only)
+func (s *ServerState) createBot(name string) string {
+	bot := UserFactory(name, RoleMember)
+	bot.Bot = true
+	s.users[name] = bot
+	return s.issueToken(name)
+}
+
+//function that issues a new REST API token for a user, replacing any earlier one, only its hash is kept (server goroutine only)
+func (s *ServerState) issueToken(name string) string {
+	token := newToken()
+	s.apiTokens[name] = hashToken(token)
+	return token
+}
+
+//function that removes a bot account, disconnecting it first if it is logged in (server goroutine only)
+func (s *ServerState) deleteBot(name string, timestamp time.Time) {
+	bot := s.users[name]
+	if bot.Active {
+		if bot.CurrentRoom != "" {
+			room := bot.CurrentRoom
+			remove(name, room)
+			broadcast(name, "left", timestamp, room, "")
+		}
+		bot.Active = false
+		s.endSession(bot)
+		safeClose(bot.Term)
+	}
+	delete(s.apiTokens, name)
+	delete(s.userRoles, name)
+	delete(s.presence, name)
+	delete(s.users, name)
+}
+
+//function that lists the bot accounts and whether they are logged in (server goroutine only)
+func (s *ServerState) listBots() []string {
+	var bots []string
+	for _, name := range sortedKeys(s.users) {
+		if !s.users[name].Bot {
+			continue
+		}
+		if s.users[name].Active {
+			bots = append(bots, name + " (online)")
+		} else {
+			bots = append(bots, name)
+		}
+	}
+	return bots
+}
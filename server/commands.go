@@ -0,0 +1,219 @@
+package server
+
+import (
+	"fmt"
+	"multi-room_chat_system/shared"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+//chat command as the server knows it, dispatch, the role check and /help all come from its registration
+type Command struct {
+	//what is typed to run it, e.g. "/join"
+	Name string
+	//forms /help shows, e.g. "/join {room}"
+	Usage []string
+	//least role that can run it, RoleBanned for commands everyone can run
+	Role Role
+	//what a custom role needs to run it, the name without the '/' unless set
+	Permission string
+	//builds the command from what the user typed, its ExecuteServer runs on the server goroutine
+	Handler func(input shared.MsgMetadata) shared.ExecutableMessage
+}
+
+//function a TextCommand runs with the user and the words after the command, the text it returns is shown to the user and an error is shown as a denial
+type TextHandler func(user *Member, args []string) (string, error)
+
+//registered commands, in the order /help lists them
+type commandRegistry struct {
+	mu sync.RWMutex
+	byName map[string]*Command
+	order []*Command
+}
+
+//every command the server runs, the built-in ones first
+var commands = newRegistry(builtinCommands())
+
+//function that returns a registry holding the given commands
+func newRegistry(cmds []Command) *commandRegistry {
+	r := &commandRegistry{byName: make(map[string]*Command)}
+	for _, cmd := range cmds {
+		if err := r.add(cmd); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+//function that adds a command, a name can only be registered once
+func (r *commandRegistry) add(cmd Command) error {
+	if !strings.HasPrefix(cmd.Name, "/") || len(strings.Fields(cmd.Name)) != 1 || cmd.Name == shared.PongCmd {
+		return fmt.Errorf("command name %q must be a single word starting with '/'", cmd.Name)
+	}
+	if cmd.Handler == nil {
+		return fmt.Errorf("command %s has no handler", cmd.Name)
+	}
+	if len(cmd.Usage) == 0 {
+		cmd.Usage = []string{cmd.Name}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.byName[cmd.Name]; exists {
+		return fmt.Errorf("command %s is already registered", cmd.Name)
+	}
+	r.byName[cmd.Name] = &cmd
+	r.order = append(r.order, &cmd)
+	return nil
+}
+
+//function that returns the command with the given name, nil if there is none
+func (r *commandRegistry) lookup(name string) *Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byName[name]
+}
+
+//function that returns the usage of every command the user can run, in registration order (server goroutine only)
+func (r *commandRegistry) usage(user *Member) []string {
+	r.mu.RLock()
+	order := slices.Clone(r.order)
+	r.mu.RUnlock()
+	var usage []string
+	for _, cmd := range order {
+		if user.can(cmd) {
+			usage = append(usage, cmd.Usage...)
+		}
+	}
+	return usage
+}
+
+//function that adds a command to the server, e.g. from a plugin package, before StartServer is called,
+//commands from other packages use a TextCommand handler, a reply type of their own would also have to be added to
+//shared.Init, unwrapShared and the client
+func RegisterCommand(cmd Command) error {
+	return commands.add(cmd)
+}
+
+//function that makes a Handler for a command that only answers its user with text, so it needs no types of its own
+func TextCommand(fn TextHandler) func(input shared.MsgMetadata) shared.ExecutableMessage {
+	return func(input shared.MsgMetadata) shared.ExecutableMessage {
+		return &CommandReply{CommandReply: &shared.CommandReply{MsgMetadata: input}, run: fn}
+	}
+}
+
+//function that reports whether the user can run a command (server goroutine only)
+func (m *Member) can(cmd *Command) bool {
+	perm := cmd.permission()
+	return perm == "" || m.has(perm)
+}
+
+//function that returns the reply to a command the user cannot run
+func denied(input shared.MsgMetadata) shared.ExecutableMessage {
+	return &CommandReply{CommandReply: &shared.CommandReply{MsgMetadata: input}}
+}
+
+//function that lists the built-in commands
+func builtinCommands() []Command {
+	return []Command{
+		{Name: "/join", Usage: []string{"/join {room}"}, Role: RoleMember, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &JoinCmd{JoinCmd: &shared.JoinCmd{MsgMetadata: input}}
+		}},
+		{Name: "/leave", Role: RoleMember, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &LeaveCmd{LeaveCmd: &shared.LeaveCmd{MsgMetadata: input}}
+		}},
+		{Name: "/listusers", Role: RoleMember, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &ListUsersCmd{ListUsersCmd: &shared.ListUsersCmd{MsgMetadata: input, Reply: shared.LUResp{}}}
+		}},
+		{Name: "/listrooms", Role: RoleMember, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &ListRoomsCmd{ListRoomsCmd: &shared.ListRoomsCmd{MsgMetadata: input}}
+		}},
+		{Name: "/uploadtoken", Role: RoleMember, Permission: "upload", Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &UploadTokenCmd{UploadTokenCmd: &shared.UploadTokenCmd{MsgMetadata: input}}
+		}},
+		{Name: "/export", Usage: []string{"/export {room} [{from} [{to}]] [{txt, json, html or md}]"}, Role: RoleMember, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &ExportCmd{ExportCmd: &shared.ExportCmd{MsgMetadata: input}}
+		}},
+		{Name: "/status", Usage: []string{"/status [{online, away or dnd} [{text}]]", "/status {user}"}, Role: RoleMember, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &StatusCmd{StatusCmd: &shared.StatusCmd{MsgMetadata: input, Away: false}}
+		}},
+		{Name: "/away", Usage: []string{"/away [{text}]"}, Role: RoleMember, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &StatusCmd{StatusCmd: &shared.StatusCmd{MsgMetadata: input, Away: true}}
+		}},
+		//everyone can see what they can run
+		{Name: "/help", Role: RoleBanned, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &HelpCmd{HelpCmd: &shared.HelpCmd{MsgMetadata: input, Invalid: false}}
+		}},
+		{Name: "/kick", Usage: []string{"/kick {user} [{reason}]"}, Role: RoleAdmin, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &KickBanCmd{KickBanCmd: &shared.KickBanCmd{MsgMetadata: input, Ban: false}}
+		}},
+		{Name: "/ban", Usage: []string{"/ban {user} [{reason}]"}, Role: RoleAdmin, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &KickBanCmd{KickBanCmd: &shared.KickBanCmd{MsgMetadata: input, Ban: true}}
+		}},
+		{Name: "/unban", Usage: []string{"/unban {user}"}, Role: RoleAdmin, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &UnBanCmd{UnBanCmd: &shared.UnBanCmd{MsgMetadata: input}}
+		}},
+		{Name: "/create", Usage: []string{"/create {room} {all or staff}"}, Role: RoleAdmin, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &CreateCmd{CreateCmd: &shared.CreateCmd{MsgMetadata: input}}
+		}},
+		{Name: "/delete", Usage: []string{"/delete {room}"}, Role: RoleAdmin, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &DeleteCmd{DeleteCmd: &shared.DeleteCmd{MsgMetadata: input}}
+		}},
+		{Name: "/broadcast", Usage: []string{"/broadcast {message}"}, Role: RoleAdmin, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			//the message is a single argument
+			input.Args = len(strings.SplitN(input.Content, " ", 2))
+			return &BroadcastCmd{BroadcastCmd: &shared.BroadcastCmd{MsgMetadata: input}}
+		}},
+		{Name: "/retention", Usage: []string{"/retention {room} [{max age or off} {max messages or off}]"}, Role: RoleAdmin, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &RetentionCmd{RetentionCmd: &shared.RetentionCmd{MsgMetadata: input}}
+		}},
+		{Name: "/audit", Usage: []string{"/audit [{user}] [{action}] [{since}]"}, Role: RoleAdmin, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &AuditCmd{AuditCmd: &shared.AuditCmd{MsgMetadata: input}}
+		}},
+		{Name: "/apitoken", Usage: []string{"/apitoken [revoke]"}, Role: RoleAdmin, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &APITokenCmd{APITokenCmd: &shared.APITokenCmd{MsgMetadata: input}}
+		}},
+		{Name: "/bot", Usage: []string{"/bot {create, token or delete} {name}", "/bot list"}, Role: RoleAdmin, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &BotCmd{BotCmd: &shared.BotCmd{MsgMetadata: input}}
+		}},
+		{Name: "/webhook", Usage: []string{"/webhook add {room} {url} [{events}]", "/webhook incoming {room} {name}", "/webhook {remove, log or test} {id}", "/webhook list [{room}]"}, Role: RoleAdmin, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &WebhookCmd{WebhookCmd: &shared.WebhookCmd{MsgMetadata: input}}
+		}},
+		{Name: "/promote", Usage: []string{"/promote {user} [confirm]"}, Role: RoleOwner, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &PromoteDemoteCmd{PromoteDemoteCmd: &shared.PromoteDemoteCmd{MsgMetadata: input, Promote: true}}
+		}},
+		{Name: "/demote", Usage: []string{"/demote {user} [confirm]"}, Role: RoleOwner, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &PromoteDemoteCmd{PromoteDemoteCmd: &shared.PromoteDemoteCmd{MsgMetadata: input, Promote: false}}
+		}},
+		{Name: "/transferowner", Usage: []string{"/transferowner {user} [confirm]"}, Role: RoleOwner, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &TransferOwnerCmd{TransferOwnerCmd: &shared.TransferOwnerCmd{MsgMetadata: input}}
+		}},
+		{Name: "/backup", Role: RoleOwner, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &BackupCmd{BackupCmd: &shared.BackupCmd{MsgMetadata: input}}
+		}},
+		{Name: "/shutdown", Role: RoleOwner, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &ShutdownCmd{ShutdownCmd: &shared.ShutdownCmd{MsgMetadata: input}}
+		}},
+		{Name: "/role", Usage: []string{"/role {create or edit} {role} {permissions}", "/role delete {role}", "/role assign {user} {role or member}", "/role list"}, Role: RoleOwner, Permission: "roles", Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &RoleCmd{RoleCmd: &shared.RoleCmd{MsgMetadata: input}}
+		}},
+		//user will always be able to quit
+		{Name: "/quit", Role: RoleBanned, Handler: func(input shared.MsgMetadata) shared.ExecutableMessage {
+			return &QuitCmd{QuitCmd: &shared.QuitCmd{MsgMetadata: input}}
+		}},
+	}
+}
+
+//function that returns what follows the first n words of a command, however they are spaced, e.g. the reason of "/kick {user} {reason}"
+func afterWords(content string, n int) string {
+	rest := strings.TrimLeftFunc(content, unicode.IsSpace)
+	for range n {
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end < 0 {
+			return ""
+		}
+		rest = strings.TrimLeftFunc(rest[end:], unicode.IsSpace)
+	}
+	return strings.TrimSpace(rest)
+}
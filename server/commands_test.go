@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+	"time"
+
+	"multi-room_chat_system/shared"
+)
+
+func ExampleRegisterCommand() {
+	err := RegisterCommand(Command{
+		Name: "/roll", Usage: []string{"/roll {sides}"}, Role: RoleMember,
+		//a TextCommand needs no types of its own, its reply is sent to the client as a CommandReply
+		Handler: TextCommand(func(user *Member, args []string) (string, error) {
+			if len(args) != 1 {
+				return "", errors.New("Incorrect usage, enter /help for more information")
+			}
+			sides, err := strconv.Atoi(args[0])
+			if err != nil || sides < 2 {
+				return "", fmt.Errorf("%s is not a number of sides", args[0])
+			}
+			return fmt.Sprintf("%s rolled %d", user.Username, rand.IntN(sides) + 1), nil
+		}),
+	})
+	fmt.Println(err)
+	// Output: <nil>
+}
+
+func TestTextCommand(t *testing.T) {
+	s := newTestServer(t)
+	addTestUser(s, "bob", RoleMember)
+	cmd := Command{Name: "/echo", Handler: TextCommand(func(user *Member, args []string) (string, error) {
+		if len(args) == 0 {
+			return "", errors.New("nothing to echo")
+		}
+		return user.Username + ": " + args[0], nil
+	})}
+	for _, tt := range []struct {
+		content string
+		status bool
+		reply string
+	}{
+		{"/echo hi", true, "bob: hi"},
+		{"/echo", false, "PERMISSION DENIED: nothing to echo"},
+	} {
+		reply := cmd.Handler(shared.MsgMetadata{UserName: "bob", Content: tt.content}).(*CommandReply)
+		reply.ExecuteServer()
+		if reply.Status != tt.status || reply.ErrMsg != tt.reply {
+			t.Errorf("%s replied %v %q, want %v %q", tt.content, reply.Status, reply.ErrMsg, tt.status, tt.reply)
+		}
+	}
+}
+
+//every registered command's reply is sent to the client, so it has to unwrap to a type shared.Init registers
+func TestCommandRepliesReachClient(t *testing.T) {
+	newTestServer(t)
+	commands.mu.RLock()
+	order := append([]*Command(nil), commands.order...)
+	commands.mu.RUnlock()
+	for _, cmd := range order {
+		msg := cmd.Handler(shared.MsgMetadata{UserName: "bob", Content: cmd.Name, Timestamp: time.Now()})
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%s: %v", cmd.Name, r)
+				}
+			}()
+			concrete := unwrapShared(msg)
+			if err := gob.NewEncoder(&bytes.Buffer{}).Encode(&concrete); err != nil {
+				t.Errorf("%s: %v", cmd.Name, err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+//server configuration, read from serverConfig.json when the server starts
+type Config struct {
+	//name the server uses to identify itself to IRC clients
+	ServerName string
+	//address of the optional IRC gateway, empty disables it
+	IRCAddr string
+	//how often connections are pinged, 0 disables heartbeats
+	HeartbeatInterval Duration
+	//how long a connection may go without sending anything (including pongs) before it is dropped
+	HeartbeatTimeout Duration
+	//how long a user may go without sending any input before being disconnected, 0 disables it
+	IdleTimeout Duration
+	//how long a user may go without sending any input before being shown as away, 0 disables it
+	AwayAfter Duration
+	//how long a dropped session can be resumed before the user is treated as having quit, 0 disables resuming
+	ResumeGrace Duration
+	//how many messages may wait to be written to a single connection, 0 means unbounded
+	OutboundQueueSize int
+	//what to do when a connection's queue is full: "drop-oldest" or "disconnect"
+	OutboundOverflow string
+	//whether links posted in rooms are inspected for images and preview cards
+	LinkPreviews bool
+	//how long inspecting a link may take
+	LinkTimeout Duration
+	//largest attachment that can be uploaded, in bytes
+	MaxUploadSize int64
+	//types of attachment that can be uploaded, sniffed from the file's content, "image/*" allows a whole family
+	AllowedTypes []string
+	//how long an upload token issued over the chat connection can be used
+	UploadTokenTTL Duration
+	//largest width or height of a stored image, larger images are scaled down, 0 keeps every image's size
+	MaxImageDimension int
+	//how much each user can store on the file server, in bytes, 0 means unlimited
+	UserQuota int64
+	//how often stored files no room refers to are removed, 0 disables it
+	StorageGCInterval Duration
+	//how often rooms' retention policies are applied, 0 disables it
+	RetentionSweepInterval Duration
+	//server log entries older than this are removed, 0 keeps them all
+	ServerLogMaxAge Duration
+	//how long a transcript made with /export can be downloaded
+	ExportTTL Duration
+	//whether the file server exposes /metrics for Prometheus, off by default since it names every room
+	Metrics bool
+	//how long a webhook endpoint has to answer a delivery
+	WebhookTimeout Duration
+	//how many times a failed webhook delivery is retried, waiting twice as long each time
+	WebhookRetries int
+}
+
+//duration that can be written in the config file as a string ("30s", "5m") or a number of seconds
+type Duration time.Duration
+
+//active configuration for the server
+var config = defaultConfig()
+
+//function that returns the configuration used when no config file is present
+func defaultConfig() Config {
+	return Config{
+		ServerName: "multi-room-chat",
+		IRCAddr: "",
+		HeartbeatInterval: Duration(15 * time.Second),
+		HeartbeatTimeout: Duration(45 * time.Second),
+		IdleTimeout: 0,
+		AwayAfter: Duration(10 * time.Minute),
+		ResumeGrace: Duration(60 * time.Second),
+		OutboundQueueSize: 256,
+		OutboundOverflow: OverflowDropOldest,
+		LinkPreviews: true,
+		LinkTimeout: Duration(5 * time.Second),
+		MaxUploadSize: 25 << 20,
+		AllowedTypes: []string{"image/*", "text/*", "application/pdf", "application/json", "application/zip", "application/x-gzip", "application/x-rar-compressed"},
+		UploadTokenTTL: Duration(time.Minute),
+		MaxImageDimension: 2048,
+		UserQuota: 100 << 20,
+		StorageGCInterval: Duration(time.Hour),
+		RetentionSweepInterval: Duration(time.Minute),
+		ServerLogMaxAge: 0,
+		ExportTTL: Duration(10 * time.Minute),
+		Metrics: false,
+		WebhookTimeout: Duration(5 * time.Second),
+		WebhookRetries: 3,
+	}
+}
+
+//function that loads the server configuration from a file, missing fields keep their defaults
+func loadConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Println("no", path, "found, using default configuration")
+			return nil
+		}
+		return err
+	}
+	cfg := defaultConfig()
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return err
+	}
+	if cfg.OutboundOverflow != OverflowDropOldest && cfg.OutboundOverflow != OverflowDisconnect {
+		return fmt.Errorf("invalid OutboundOverflow %q, must be %q or %q", cfg.OutboundOverflow, OverflowDropOldest, OverflowDisconnect)
+	}
+	config = cfg
+	return nil
+}
+
+//function that decodes a config duration from either a string or a number of seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(time.Duration(value * float64(time.Second)))
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration: %s", string(data))
+	}
+	return nil
+}
+
+//function that encodes a config duration as a readable string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
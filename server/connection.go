@@ -7,7 +7,9 @@ import (
 	"log"
 	"multi-room_chat_system/shared"
 	"net"
+	"strconv"
 	"strings"
+	"time"
 )
 
 //function to synchronously check a user's connection before allowing them to input commands to the server
@@ -18,19 +20,26 @@ func handleNewConnection(conn net.Conn) {
 	//prompt user for username
 	writer.WriteString("Enter your username: >")
 	writer.Flush()
-	//read the entered username
+	//read the entered username, dropping clients that never answer
+	setReadDeadline(conn)
 	username, err := reader.ReadString('\n')
 	username = strings.TrimSpace(username)
 	if err != nil {
         fmt.Println("Failed to read username:", err)
+        conn.Close()
         return
     }
 	//get server state
 	s := GetServerState()
-	//send JoinRPC to the server state
 	resp := &ServerJoinResponse{}
-	s.JoinServer(username, resp)
-	//if user is banned
+	if strings.HasPrefix(username, shared.ResumeCmd + " ") {
+		//client is reconnecting, send ResumeRPC to the server state
+		s.ResumeServer(parseResumeRequest(username), resp)
+	} else {
+		//send JoinRPC to the server state
+		s.JoinServer(username, resp)
+	}
+	//if user is banned or the session could not be resumed
 	if !resp.Status {
 		log.Println("user is banned")
 		log.Println(resp.Message)
@@ -43,64 +52,147 @@ func handleNewConnection(conn net.Conn) {
 	writer.WriteString(resp.Message)
     writer.Flush()
 	//otherwise start goroutine to handle client requests
-	go handleConnection(reader, conn, resp.Role)
+	go handleConnection(reader, conn, resp.Role, resp.Session)
+}
+
+//helper function to parse "/resume {token} {last message id}" sent at the login prompt
+func parseResumeRequest(line string) ResumeRequest {
+	parts := strings.Fields(line)
+	req := ResumeRequest{}
+	if len(parts) > 1 {
+		req.Token = parts[1]
+	}
+	if len(parts) > 2 {
+		req.LastID, _ = strconv.ParseInt(parts[2], 10, 64)
+	}
+	return req
 }
 
 //function to asynchronously handle connections once they are verified
-func handleConnection(reader *bufio.Reader, conn net.Conn, user *Member) {
+func handleConnection(reader *bufio.Reader, conn net.Conn, user *Member, session *shared.Session) {
 	//start listener goroutine to listen for user input
 	userInput := make(chan string)
-	go getUserInput(reader, user, userInput)
+	go getUserInput(reader, conn, user, userInput)
 	//get server state (for RPCs)
 	s := GetServerState()
-	//create encoder for gob usage
-	encoder := gob.NewEncoder(conn)
-	//start listener goroutine to listen for user input
+	//start the writer that sends everything queued for this user to the client
+	written := make(chan struct{})
+	go writeToClient(conn, user, written)
+	//flush what is still queued before closing the connection
+	closeConn := func() {
+		user.out.close()
+		//a client that stopped reading does not get to hold the connection open
+		select {
+		case <-written:
+		case <-time.After(outboundFlushTimeout):
+		}
+		conn.Close()
+		<-written
+		close(user.ToServer)
+	}
+	//start heartbeat and idle timers
+	timers := newConnTimers(user)
+	defer timers.stop()
+	//give the client its session token before anything else
+	user.out.pushReply(&Session{Session: session})
 
 	for {
 		select{
-		//listen for commands from the server/room
-		case msg := <-user.RecvServer:
-			//send client a response from the server
-			forwardToClient(encoder, msg)
-
 		//listen for input from the user
 		case input := <-userInput:
+			//a user shown as away for being idle is back before their input runs
+			if timers.activity() {
+				s.SetIdle(user, false)
+			}
 			log.Println("client connectionHandler reveived:", input)
-			//convert raw input to metadata (no timestamp)
-			rawInput := shared.MsgMetadata{UserName: user.Username, Content: input}
-			//send raw data to server
-			var reply shared.ExecutableMessage
-			log.Println("client connectionHandler sent to server:", input)
-			s.RecvMessage(&rawInput, &reply)
+			//anything the input causes is held back until its reply is queued
+			user.out.hold()
+			reply := submitInput(user, input)
 			log.Println("client connectionHandler recv response from server for :", input)
 
-			//once have response, forward to client
-			forwardToClient(encoder, reply)
-			
-		//if user/server is terminated
-		case <-user.Term:
-			log.Println("user terminated, sending quit")
+			//once have response, queue it for the client
+			user.out.pushReply(reply)
+
+		//ping the client so dead connections are noticed even when nothing is being sent
+		case <-timers.heartbeatC():
+			user.send(&Ping{Ping: &shared.Ping{Timestamp: time.Now()}})
+
+		//user has not sent any input for a while
+		case <-timers.awayC():
+			timers.wentAway()
+			s.SetIdle(user, true)
+
+		//user has not sent any input for too long
+		case <-timers.idleC():
+			log.Println(user.Username, "was idle for", time.Duration(config.IdleTimeout), "disconnecting")
+			user.out.pushReply(&QuitCmd{QuitCmd: &shared.QuitCmd{Reason: "You were disconnected after being idle for " + time.Duration(config.IdleTimeout).String()}})
+			//an idle user quits for good, the session is not kept for resuming
 			var reply shared.ExecutableMessage
 			rawInput := shared.MsgMetadata{UserName: user.Username, Content: "/quit"}
 			s.RecvMessage(&rawInput, &reply)
-			close(user.RecvServer)
-			close(user.ToServer)
-			conn.Close()
+
+		//if user/server is terminated
+		case <-user.Term:
+			log.Println("user terminated, cleaning up connection")
+			//a dropped connection keeps its session for a while so the client can resume it
+			s.Disconnect(user, true)
+			closeConn()
 			return
 		case <-s.term:
 			log.Println("server terminated, exiting connection loop for", user.Username)
-			close(user.RecvServer)
-			close(user.ToServer)
-			conn.Close()
+			closeConn()
 			return
 		}
 	}
 
 }
 
+//function that sends user input to be executed, chat goes to the user's room and commands go to the server
+func submitInput(user *Member, input string) shared.ExecutableMessage {
+	s := GetServerState()
+	//convert raw input to metadata (no timestamp)
+	rawInput := shared.MsgMetadata{UserName: user.Username, Content: input}
+	var reply shared.ExecutableMessage
+	log.Println("client connectionHandler sent to server:", input)
+	if input != "" && input[0] != '/' {
+		s.RecvRoomMessage(user, &rawInput, &reply)
+	} else {
+		s.RecvMessage(&rawInput, &reply)
+	}
+	return reply
+}
+
+//goroutine that writes a user's queued messages to the client, so a slow client never blocks the server
+func writeToClient(conn net.Conn, user *Member, written chan struct{}) {
+	defer close(written)
+	//create encoder for gob usage
+	encoder := gob.NewEncoder(conn)
+	//a failed write means the client is gone, clean up the same way as a quit
+	write := func() bool {
+		for _, msg := range user.out.drain() {
+			if err := forwardToClient(conn, encoder, msg); err != nil {
+				safeClose(user.Term)
+				return false
+			}
+		}
+		return true
+	}
+	for {
+		select {
+		case <-user.out.ready:
+			if !write() {
+				return
+			}
+		//connection is closing, send whatever is left (e.g. the reply to /quit)
+		case <-user.out.done:
+			write()
+			return
+		}
+	}
+}
+
 //function to continuously intercept user input from GUI
-func getUserInput(reader *bufio.Reader, user *Member, userInput chan string) {
+func getUserInput(reader *bufio.Reader, conn net.Conn, user *Member, userInput chan string) {
 	s := GetServerState()
 	for {
 		select {
@@ -110,27 +202,44 @@ func getUserInput(reader *bufio.Reader, user *Member, userInput chan string) {
 		case <-user.Term:
 			return
 		default:
-			//read line from client
+			//read line from client, a client that stops answering heartbeats times out
+			setReadDeadline(conn)
 			line, err := reader.ReadString('\n')
 			//detect if client disconnects
 			if err != nil {
-				fmt.Println("Client disconnected")
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					fmt.Println("Client connection timed out:", user.Username)
+				} else {
+					fmt.Println("Client disconnected")
+				}
 				safeClose(user.Term)
 				return
 			}
 			//format input
 			input := strings.TrimSpace(line)
+			//heartbeat replies only keep the connection alive
+			if input == shared.PongCmd {
+				continue
+			}
 			log.Println("received client input:",input)
 			//send input to handleConnection
-			userInput <- input
+			select {
+			case userInput <- input:
+			case <-user.Term:
+				return
+			case <-s.term:
+				return
+			}
 		}
 	}
 }
 
 //function to forward the server response to the client
-func forwardToClient(encoder *gob.Encoder, msg shared.ExecutableMessage) error {
+func forwardToClient(conn net.Conn, encoder *gob.Encoder, msg shared.ExecutableMessage) error {
 	//unwrap the server to the shared type to send to client
 	concrete := unwrapShared(msg)
+	//do not block forever on a client that stopped reading
+	setWriteDeadline(conn)
 	err := encoder.Encode(&concrete)
 	if err != nil {
         fmt.Println("Error sending ExecutableMessage:", err)
@@ -140,7 +249,8 @@ func forwardToClient(encoder *gob.Encoder, msg shared.ExecutableMessage) error {
     return nil
 }
 
-//extract raw data (the shared type) from server response to be sent to the client
+//extract raw data (the shared type) from server response to be sent to the client, every type here is also registered
+//in shared.Init, registered commands without a type here answer with a TextCommand's CommandReply
 func unwrapShared(msg interface{}) interface{} {
     switch m := msg.(type) {
     case *HelpCmd:
@@ -179,8 +289,40 @@ func unwrapShared(msg interface{}) interface{} {
 		return m.GetLog
 	case *UpdateLobby:
 		return m.UpdateLobby
+	case *Ping:
+		return m.Ping
+	case *Session:
+		return m.Session
+	case *MessageUpdate:
+		return m.MessageUpdate
+	case *PresenceUpdate:
+		return m.PresenceUpdate
+	case *UploadTokenCmd:
+		return m.UploadTokenCmd
+	case *RetentionCmd:
+		return m.RetentionCmd
+	case *ExportCmd:
+		return m.ExportCmd
+	case *BackupCmd:
+		return m.BackupCmd
+	case *AuditCmd:
+		return m.AuditCmd
+	case *APITokenCmd:
+		return m.APITokenCmd
+	case *BotCmd:
+		return m.BotCmd
+	case *WebhookCmd:
+		return m.WebhookCmd
+	case *CommandReply:
+		return m.CommandReply
+	case *RoleCmd:
+		return m.RoleCmd
+	case *TransferOwnerCmd:
+		return m.TransferOwnerCmd
+	case *StatusCmd:
+		return m.StatusCmd
     default:
-        panic("error during unwrapping: unknown command type")
+        panic(fmt.Sprintf("error during unwrapping: unknown command type %T, commands added with RegisterCommand must use TextCommand", msg))
     }
 }
 
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"multi-room_chat_system/shared"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+//where transcripts are written until they expire, emptied whenever the server starts
+const exportDir = "exports"
+
+//content type of each transcript format /export can produce
+var exportFormats = map[string]string{
+	"txt": "text/plain; charset=utf-8",
+	"json": "application/json",
+	"html": "text/html; charset=utf-8",
+	"md": "text/markdown; charset=utf-8",
+}
+
+//transcript waiting to be downloaded by the user who exported it
+type exportRecord struct {
+	username string
+	name string
+	contentType string
+	expires time.Time
+}
+
+//transcripts that can still be downloaded, shared by the server goroutine and the http handler
+type exportStore struct {
+	mu sync.Mutex
+	exports map[string]exportRecord
+}
+
+//transcripts that can still be downloaded
+var exports = &exportStore{exports: make(map[string]exportRecord)}
+
+//function that removes transcripts left over from the last run
+func resetExports() error {
+	if err := os.RemoveAll(exportDir); err != nil {
+		return err
+	}
+	return os.MkdirAll(exportDir, 0755)
+}
+
+//function that records a written transcript, transcripts that expired are removed
+func (es *exportStore) add(id string, rec exportRecord, now time.Time) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	for old, r := range es.exports {
+		if now.After(r.expires) {
+			delete(es.exports, old)
+			os.Remove(filepath.Join(exportDir, old))
+		}
+	}
+	es.exports[id] = rec
+}
+
+//function that returns a transcript that has not expired
+func (es *exportStore) get(id string) (exportRecord, bool) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	rec, exists := es.exports[id]
+	if !exists || time.Now().After(rec.expires) {
+		return exportRecord{}, false
+	}
+	return rec, true
+}
+
+//one message of a transcript
+type transcriptEntry struct {
+	ID int64
+	Timestamp time.Time
+	Username string
+	Content string
+	//user actions such as joining or leaving the room
+	Event bool `json:",omitempty"`
+	Image string `json:",omitempty"`
+	Attachment *shared.Attachment `json:",omitempty"`
+	Preview *shared.LinkPreview `json:",omitempty"`
+	//Username is the name of the incoming webhook that posted it
+	Integration bool `json:",omitempty"`
+}
+
+//transcript of a room's log between two times
+type transcript struct {
+	Room string
+	//left out when the transcript starts at the beginning of the log or runs to its end
+	From *time.Time `json:",omitempty"`
+	To *time.Time `json:",omitempty"`
+	Exported time.Time
+	Messages []transcriptEntry
+}
+
+//function that builds the transcript of the messages posted in [from, to), a zero time leaves that end open
+func newTranscript(room string, messages []shared.Message, from time.Time, to time.Time, now time.Time) *transcript {
+	t := &transcript{Room: room, Exported: now, Messages: make([]transcriptEntry, 0)}
+	if !from.IsZero() {
+		t.From = &from
+	}
+	if !to.IsZero() {
+		t.To = &to
+	}
+	for _, msg := range messages {
+		if (!from.IsZero() && msg.Timestamp.Before(from)) || (!to.IsZero() && !msg.Timestamp.Before(to)) {
+			continue
+		}
+		entry := transcriptEntry{ID: msg.ID, Timestamp: msg.Timestamp, Username: msg.UserName, Content: strings.TrimSpace(msg.Content), Event: msg.Flag, Attachment: msg.Attachment, Preview: msg.Preview, Integration: msg.Integration}
+		if msg.Image {
+			entry.Image = entry.Content
+		}
+		t.Messages = append(t.Messages, entry)
+	}
+	return t
+}
+
+//function that writes a transcript in one of the export formats
+func (t *transcript) write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", " ")
+		return enc.Encode(t)
+	case "html":
+		return transcriptHTML.Execute(w, t)
+	case "md":
+		return t.writeText(w, markdownText, markdownURL, "# Transcript of %s\n\n", "- %s **%s**: %s\n", "- *%s %s %s*\n", "![image](%s)", "[%s](%s)")
+	}
+	plain := func(s string) string { return s }
+	return t.writeText(w, plain, plain, "Transcript of %s\n\n", "%s  %s: %s\n", "%s  * %s %s\n", "%s", "%s <%s>")
+}
+
+//characters that mean something in markdown, escaped wherever users wrote them
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "{", `\{`, "}", `\}`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`,
+	"#", `\#`, "+", `\+`, "-", `\-`, "!", `\!`, "|", `\|`, "<", `\<`, ">", `\>`, "~", `\~`, "=", `\=`)
+
+//a line starting like an ordered list item, e.g. "1." or "2)"
+var markdownOrdered = regexp.MustCompile(`(?m)^(\s*\d+)([.)])`)
+
+//characters that would end a markdown link's URL early
+var markdownURLEscaper = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E")
+
+//function that escapes text users wrote so it shows as written in a markdown transcript, later lines stay in the same list item
+func markdownText(s string) string {
+	s = markdownOrdered.ReplaceAllString(markdownEscaper.Replace(s), `$1\$2`)
+	return strings.ReplaceAll(s, "\n", "\\\n  ")
+}
+
+//function that escapes a URL put into a markdown link
+func markdownURL(s string) string {
+	return markdownURLEscaper.Replace(s)
+}
+
+//function that writes a transcript line by line, the layouts differ between plain text and markdown, text and url escape what users wrote
+func (t *transcript) writeText(w io.Writer, text func(string) string, url func(string) string, title string, message string, event string, image string, file string) error {
+	if _, err := fmt.Fprintf(w, title, text(t.Room)); err != nil {
+		return err
+	}
+	for _, m := range t.Messages {
+		stamp := m.Timestamp.Format("2006-01-02 15:04:05")
+		var err error
+		switch {
+		case m.Event:
+			_, err = fmt.Fprintf(w, event, stamp, text(m.Username), text(m.Content))
+		case m.Image != "":
+			_, err = fmt.Fprintf(w, message, stamp, text(m.Username), fmt.Sprintf(image, url(m.Image)))
+		case m.Attachment != nil:
+			_, err = fmt.Fprintf(w, message, stamp, text(m.Username), fmt.Sprintf(file, text(m.Attachment.Name), url(m.Attachment.URL)))
+		default:
+			_, err = fmt.Fprintf(w, message, stamp, text(m.Username), text(m.Content))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//page an html transcript is rendered into, everything users wrote is escaped by html/template,
+//images are only named since the file server does not serve them to a browser opening the file
+var transcriptHTML = template.Must(template.New("transcript").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Transcript of {{.Room}}</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: auto; }
+.time { color: #888; }
+.event { color: #666; font-style: italic; }
+</style>
+</head>
+<body>
+<h1>Transcript of {{.Room}}</h1>
+{{range .Messages}}<p{{if .Event}} class="event"{{end}}><span class="time">{{.Timestamp.Format "2006-01-02 15:04:05"}}</span>
+{{if .Event}}{{.Username}} {{.Content}}{{else}}<b>{{.Username}}</b>:
+{{if .Image}}image {{.Image}}{{else if .Attachment}}<a href="{{.Attachment.URL}}">{{.Attachment.Name}}</a>{{else}}{{.Content}}{{end}}
+{{with .Preview}}<br><a href="{{.URL}}">{{.Title}}</a>{{end}}{{end}}</p>
+{{end}}</body>
+</html>
+`))
+
+//function that parses a date (2006-01-02, in the server's time zone) or a time (RFC 3339) given to /export, "-" leaves that end open
+func parseExportTime(value string, end bool) (time.Time, error) {
+	if value == "-" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	//a date as the end of the range includes that whole day
+	if end {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
+
+//function that writes a transcript for a user to download, returns where it can be downloaded
+func saveExport(t *transcript, format string, username string) (*shared.Attachment, error) {
+	id := newToken()
+	name := fmt.Sprintf("%s-%s.%s", strings.TrimPrefix(t.Room, "#"), t.Exported.Format("20060102-150405"), format)
+	file, err := os.OpenFile(filepath.Join(exportDir, id), os.O_CREATE | os.O_EXCL | os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = t.write(file, format)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+	info, err := os.Stat(file.Name())
+	if err != nil {
+		return nil, err
+	}
+	exports.add(id, exportRecord{username: username, name: name, contentType: exportFormats[format], expires: t.Exported.Add(time.Duration(config.ExportTTL))}, t.Exported)
+	return &shared.Attachment{ID: id, Name: name, Size: info.Size(), Type: exportFormats[format], URL: fileServerURL + "/exports/" + id}, nil
+}
+
+//export RPC request (transcript writer -> server), sent once the transcript is written or failed
+type ExportResult struct {
+	User string
+	Room string
+	Format string
+	Count int
+	Transcript *shared.Attachment
+	Expires time.Time
+	Err error
+}
+
+//function that renders and writes a transcript of the messages taken from the room off the server goroutine, and reports how it went back to the server
+func (s *ServerState) writeExportLater(username string, room string, messages []shared.Message, from time.Time, to time.Time, format string, now time.Time) {
+	go func() {
+		t := newTranscript(room, messages, from, to, now)
+		res := ExportResult{User: username, Room: room, Format: format, Count: len(t.Messages), Expires: now.Add(time.Duration(config.ExportTTL))}
+		res.Transcript, res.Err = saveExport(t, format, username)
+		select {
+		case s.recvExport <- res:
+		case <-s.term:
+		}
+	}()
+}
+
+//function that sends a written transcript to the user who exported it (server goroutine only)
+func (s *ServerState) finishExport(res ExportResult) {
+	delete(s.exporting, res.User)
+	notice := &ExportCmd{ExportCmd: &shared.ExportCmd{Room: res.Room, Format: res.Format, Count: res.Count}}
+	if res.Err != nil {
+		log.Println("Error writing export:", res.Err)
+		notice.ErrMsg = "SERVER: Could not write the transcript"
+	} else {
+		notice.Status = true
+		notice.Transcript = res.Transcript
+		notice.ErrMsg = fmt.Sprintf("SERVER: transcript of %s with %d messages is ready at %s (expires %s)", res.Room, res.Count, res.Transcript.URL, res.Expires.Format("15:04:05"))
+	}
+	if user := s.users[res.User]; user != nil && user.Active {
+		notice.CurrentRoom = user.CurrentRoom
+		user.send(notice)
+	}
+}
+
+//serve a transcript as a download with its name
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/exports/")
+	rec, ok := exports.get(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := os.Open(filepath.Join(exportDir, id))
+	if err != nil {
+		log.Println("Error opening export:", id, err)
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Type", rec.contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": rec.name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, rec.name, time.Time{}, file)
+}
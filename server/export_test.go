@@ -0,0 +1,79 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"multi-room_chat_system/shared"
+)
+
+func TestExportIsWrittenOffServerGoroutine(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := resetExports(); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t)
+	rm := addTestRoom(t, s, "#general", RoleMember)
+	now := time.Now()
+	rm.restore([]shared.Message{
+		{MsgMetadata: shared.MsgMetadata{UserName: "bob", Timestamp: now, Content: "hello <b>there</b>"}},
+		{MsgMetadata: shared.MsgMetadata{UserName: "bob", Timestamp: now, Content: fileServerURL + "/uploads/cat.png"}, Image: true},
+	})
+	user := addTestUser(s, "bob", RoleMember)
+	loginTestUser(s, user, "")
+
+	cmd := runTestCmd(s, "bob", "/export #general html").(*ExportCmd)
+	if !cmd.Status || cmd.Transcript != nil {
+		t.Fatalf("/export replied %v %q with transcript %v", cmd.Status, cmd.ErrMsg, cmd.Transcript)
+	}
+	if again := runTestCmd(s, "bob", "/export #general").(*ExportCmd); again.Status {
+		t.Fatal("second export started while the first was being written")
+	}
+
+	select {
+	case res := <-s.recvExport:
+		s.finishExport(res)
+	case <-time.After(5 * time.Second):
+		t.Fatal("export never finished")
+	}
+	var notice *ExportCmd
+	for _, msg := range user.out.drain() {
+		if msg, ok := msg.(*ExportCmd); ok {
+			notice = msg
+		}
+	}
+	if notice == nil || !notice.Status || notice.Transcript == nil || notice.Count != 2 {
+		t.Fatalf("user was sent %+v", notice)
+	}
+	data, err := os.ReadFile(filepath.Join(exportDir, notice.Transcript.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(data)
+	if strings.Contains(page, "<img") || !strings.Contains(page, "hello &lt;b&gt;there&lt;/b&gt;") {
+		t.Fatalf("transcript:\n%s", page)
+	}
+	if s.exporting["bob"] {
+		t.Fatal("user still marked as exporting")
+	}
+}
+
+func TestMarkdownText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"*bold* _it_", `\*bold\* \_it\_`},
+		{"[x](y)", `\[x\]\(y\)`},
+		{"1. item", `1\. item`},
+		{"a\nb", "a\\\n  b"},
+	}
+	for _, tt := range tests {
+		if got := markdownText(tt.in); got != tt.want {
+			t.Errorf("markdownText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
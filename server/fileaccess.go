@@ -0,0 +1,308 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+//where the uploader and rooms of every stored file are kept
+const fileAccessPath = "fileAccess.json"
+
+//who uploaded a file, the rooms it has been posted to and where its contents are stored
+type fileRecord struct {
+	//users who uploaded these contents
+	Owners []string
+	Rooms []string
+	//file on disk holding the contents, shared by every upload of the same bytes
+	Blob string
+	Size int64
+	//last time the file was uploaded or posted, recently used files are never collected
+	LastUsed time.Time
+	//uploader recorded before files could have several owners
+	Owner string `json:",omitempty"`
+}
+
+//upload token issued to a user over their chat connection
+type uploadGrant struct {
+	username string
+	expires time.Time
+}
+
+//access rules for the file server, shared by the http handlers, the rooms and the server goroutine
+type fileAccess struct {
+	mu sync.Mutex
+	//unused upload tokens
+	tokens map[string]uploadGrant
+	//stored files by their path on the file server (e.g. "uploads/{uuid}.jpg" or "files/{id}")
+	files map[string]*fileRecord
+	//bytes of each user's uploads that are being written and are not recorded yet
+	reserved map[string]int64
+}
+
+//access rules for the files on the file server
+var files = &fileAccess{tokens: make(map[string]uploadGrant), files: make(map[string]*fileRecord), reserved: make(map[string]int64)}
+
+//file access RPC request (http handler -> server)
+type FileAuthRequest struct {
+	Token string //session token the client sent
+	File string //path of the requested file
+	Resp chan bool
+}
+
+//function that loads the file records, a missing file means nothing has been uploaded yet
+func loadFileAccess() error {
+	data, err := os.ReadFile(fileAccessPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	files.mu.Lock()
+	defer files.mu.Unlock()
+	if err := json.Unmarshal(data, &files.files); err != nil {
+		return err
+	}
+	//fill in records written before contents were shared between uploads
+	for file, rec := range files.files {
+		if rec.Owner != "" {
+			rec.Owners = append(rec.Owners, rec.Owner)
+			rec.Owner = ""
+		}
+		if rec.Blob == "" {
+			rec.Blob = blobPath(file)
+			if info, err := os.Stat(rec.Blob); err == nil {
+				rec.Size = info.Size()
+				rec.LastUsed = info.ModTime()
+			}
+		}
+	}
+	return nil
+}
+
+//function that writes the file records back to disk (caller holds the lock)
+func (fa *fileAccess) save() {
+	data, err := json.MarshalIndent(fa.files, "", " ")
+	if err == nil {
+		err = os.WriteFile(fileAccessPath, data, 0644)
+	}
+	if err != nil {
+		log.Println("Error saving file access:", err)
+	}
+}
+
+//function that issues a single use upload token to a user
+func (fa *fileAccess) issueToken(username string, now time.Time) (string, time.Time) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	//forget tokens that were never used
+	for token, grant := range fa.tokens {
+		if now.After(grant.expires) {
+			delete(fa.tokens, token)
+		}
+	}
+	token := newToken()
+	expires := now.Add(time.Duration(config.UploadTokenTTL))
+	fa.tokens[token] = uploadGrant{username: username, expires: expires}
+	return token, expires
+}
+
+//function that uses up an upload token, returns the user it was issued to
+func (fa *fileAccess) claimToken(token string) (string, bool) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	grant, exists := fa.tokens[token]
+	if !exists {
+		return "", false
+	}
+	delete(fa.tokens, token)
+	if time.Now().After(grant.expires) {
+		return "", false
+	}
+	return grant.username, true
+}
+
+//function that records who uploaded a file, uploading contents that are already stored adds another owner
+func (fa *fileAccess) add(file string, owner string, size int64, now time.Time) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	rec, exists := fa.files[file]
+	if !exists {
+		rec = &fileRecord{Blob: blobPath(file), Size: size}
+		fa.files[file] = rec
+	}
+	if !slices.Contains(rec.Owners, owner) {
+		rec.Owners = append(rec.Owners, owner)
+	}
+	rec.LastUsed = now
+	fa.save()
+}
+
+//function that records a room a file was posted to, files logged before uploads had owners are claimed by their poster
+func (fa *fileAccess) post(file string, room string, username string) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	rec, exists := fa.files[file]
+	if !exists {
+		rec = &fileRecord{Owners: []string{username}, Blob: blobPath(file)}
+		if info, err := os.Stat(rec.Blob); err == nil {
+			rec.Size = info.Size()
+		}
+		fa.files[file] = rec
+	}
+	rec.LastUsed = time.Now()
+	if slices.Contains(rec.Rooms, room) {
+		return
+	}
+	rec.Rooms = append(rec.Rooms, room)
+	fa.save()
+}
+
+//function that forgets a deleted room, so a room created later under its name is not shown its files
+func (fa *fileAccess) forgetRoom(room string) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	if renameFileRoom(fa.files, room, "") > 0 {
+		fa.save()
+	}
+}
+
+//function that renames a room in file records, "" removes it, returns how many records changed
+func renameFileRoom(records map[string]*fileRecord, room string, name string) int {
+	changed := 0
+	for _, rec := range records {
+		i := slices.Index(rec.Rooms, room)
+		if i < 0 {
+			continue
+		}
+		if name == "" || slices.Contains(rec.Rooms, name) {
+			rec.Rooms = slices.Delete(rec.Rooms, i, i + 1)
+		} else {
+			rec.Rooms[i] = name
+		}
+		changed++
+	}
+	return changed
+}
+
+//function that reads the file records the admin tool works on, nil if nothing has been uploaded yet
+func readFileAccess(path string) (map[string]*fileRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	records := make(map[string]*fileRecord)
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return records, nil
+}
+
+//function that writes the file records the admin tool changed
+func writeFileAccess(path string, records map[string]*fileRecord) error {
+	data, err := json.MarshalIndent(records, "", " ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+//function that reports whether a user uploaded a file
+func (fa *fileAccess) owns(file string, username string) bool {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	rec, exists := fa.files[file]
+	return exists && slices.Contains(rec.Owners, username)
+}
+
+//function that returns a copy of a file's record
+func (fa *fileAccess) record(file string) (fileRecord, bool) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	rec, exists := fa.files[file]
+	if !exists {
+		return fileRecord{}, false
+	}
+	return fileRecord{Owners: slices.Clone(rec.Owners), Rooms: slices.Clone(rec.Rooms), Blob: rec.Blob, Size: rec.Size, LastUsed: rec.LastUsed}, true
+}
+
+//function that returns the path of a file stored on this server's file server, or "" for any other link
+func fileKey(link string) string {
+	if !strings.HasPrefix(link, fileServerURL + "/") {
+		return ""
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Path, "/")
+}
+
+//function that returns the bearer token sent with a request
+func bearerToken(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return strings.TrimSpace(token)
+}
+
+//function that only serves a file to the user who uploaded it and to users allowed in a room it was posted to
+func requireFileAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !GetServerState().AuthorizeFile(token, strings.TrimPrefix(r.URL.Path, "/")) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//function that checks whether the user holding a session may download a file (server goroutine only)
+func (s *ServerState) canDownload(req FileAuthRequest) bool {
+	sess, exists := s.sessions[req.Token]
+	if !exists {
+		return false
+	}
+	user, exists := s.users[sess.username]
+	if !exists || user.Role == RoleBanned {
+		return false
+	}
+	//transcripts are only for the user who exported them
+	if id, ok := strings.CutPrefix(req.File, "exports/"); ok {
+		rec, exists := exports.get(id)
+		return exists && rec.username == user.Username
+	}
+	//thumbnails can be seen by whoever can see the image
+	rec, exists := files.record(thumbnailSource(req.File))
+	if !exists {
+		return false
+	}
+	if slices.Contains(rec.Owners, user.Username) {
+		return true
+	}
+	for _, name := range rec.Rooms {
+		if rm, exists := s.rooms[name]; exists && user.allowedIn(rm.permission) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net"
+	"time"
+)
+
+//timers a connection handler uses to send heartbeats and to detect idle users
+type connTimers struct {
+	heartbeat *time.Ticker
+	idle *time.Timer
+	away *time.Timer
+	//the away timer fired since the user last sent input
+	isAway bool
+}
+
+//function that starts the heartbeat ticker and idle timer for a connection based on the config
+func newConnTimers(user *Member) *connTimers {
+	t := &connTimers{}
+	if interval := time.Duration(config.HeartbeatInterval); interval > 0 {
+		t.heartbeat = time.NewTicker(interval)
+	}
+	//bots are expected to sit quietly until something happens
+	if timeout := time.Duration(config.IdleTimeout); timeout > 0 && !user.Bot {
+		t.idle = time.NewTimer(timeout)
+	}
+	if after := time.Duration(config.AwayAfter); after > 0 && !user.Bot {
+		t.away = time.NewTimer(after)
+	}
+	return t
+}
+
+//channel that fires when the connection should be pinged, nil (never fires) when heartbeats are disabled
+func (t *connTimers) heartbeatC() <-chan time.Time {
+	if t.heartbeat == nil {
+		return nil
+	}
+	return t.heartbeat.C
+}
+
+//channel that fires when the user has been idle too long, nil (never fires) when idle timeouts are disabled
+func (t *connTimers) idleC() <-chan time.Time {
+	if t.idle == nil {
+		return nil
+	}
+	return t.idle.C
+}
+
+//channel that fires when the user should be shown as away, nil (never fires) when auto-away is disabled
+func (t *connTimers) awayC() <-chan time.Time {
+	if t.away == nil {
+		return nil
+	}
+	return t.away.C
+}
+
+//function that records that the user is now shown as away
+func (t *connTimers) wentAway() {
+	t.isAway = true
+}
+
+//function that records user activity, restarting the idle and away timers, returns true if the user was shown as away until now
+func (t *connTimers) activity() bool {
+	if t.idle != nil {
+		t.idle.Reset(time.Duration(config.IdleTimeout))
+	}
+	if t.away != nil {
+		t.away.Reset(time.Duration(config.AwayAfter))
+	}
+	back := t.isAway
+	t.isAway = false
+	return back
+}
+
+//function that stops the timers once the connection is closed
+func (t *connTimers) stop() {
+	if t.heartbeat != nil {
+		t.heartbeat.Stop()
+	}
+	if t.idle != nil {
+		t.idle.Stop()
+	}
+	if t.away != nil {
+		t.away.Stop()
+	}
+}
+
+//function that sets how long the next read may block before the peer is considered dead
+func setReadDeadline(conn net.Conn) {
+	if time.Duration(config.HeartbeatInterval) > 0 && time.Duration(config.HeartbeatTimeout) > 0 {
+		conn.SetReadDeadline(time.Now().Add(time.Duration(config.HeartbeatTimeout)))
+	}
+}
+
+//function that sets how long the next write may block before the peer is considered dead
+func setWriteDeadline(conn net.Conn) {
+	if time.Duration(config.HeartbeatTimeout) > 0 {
+		conn.SetWriteDeadline(time.Now().Add(time.Duration(config.HeartbeatTimeout)))
+	}
+}
@@ -1,14 +1,16 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
-	"io"
+	"log"
+	"multi-room_chat_system/shared"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 //helper function to extract the uuid and file extension
@@ -38,42 +40,67 @@ func MakeImageLink(baseURL, uuid, ext string) string {
     return fmt.Sprintf("%s/uploads/%s.%s", baseURL, uuid, ext)
 }
 
-//upload the image to the local file server
+//upload the image to the local file server, it is verified and re-encoded before being stored
 func uploadHandler(w http.ResponseWriter, r *http.Request) {
-    err := r.ParseMultipartForm(10 << 20) // 10 MB max
-    if err != nil {
-        http.Error(w, "Could not parse form", http.StatusBadRequest)
+    if r.Method != http.MethodPost {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
         return
     }
-
+    //only users holding an upload token issued over their chat connection can upload
+    username, ok := files.claimToken(bearerToken(r))
+    if !ok {
+        http.Error(w, "Invalid or expired upload token", http.StatusUnauthorized)
+        return
+    }
+    r.Body = http.MaxBytesReader(w, r.Body, config.MaxUploadSize + 1 << 20)
     file, handler, err := r.FormFile("file")
     if err != nil {
-        http.Error(w, "Could not get file", http.StatusBadRequest)
+        http.Error(w, "Could not get file (files may be at most " + formatSize(config.MaxUploadSize) + ")", http.StatusBadRequest)
         return
     }
     defer file.Close()
+    if handler.Size > config.MaxUploadSize {
+        http.Error(w, "File is larger than " + formatSize(config.MaxUploadSize), http.StatusRequestEntityTooLarge)
+        return
+    }
 
-    uuid := r.FormValue("uuid")
-    if uuid == "" {
-        http.Error(w, "Missing UUID", http.StatusBadRequest)
+    img, err := processImage(file)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
         return
     }
 
-    savePath := fmt.Sprintf("uploads/%s%s", uuid, filepath.Ext(handler.Filename))
-    out, err := os.Create(savePath)
+    data, err := img.encode()
     if err != nil {
-		fmt.Println("Error creating file:", savePath, err)
+		log.Println("Error encoding image:", err)
 		http.Error(w, "Could not save file", http.StatusInternalServerError)
 		return
-	}
-    defer out.Close()
-
-	_, err = io.Copy(out, file)
-	if err != nil {
-		fmt.Println("Error writing file:", err)
-		http.Error(w, "Could not write file", http.StatusInternalServerError)
+    }
+    //images are named by their contents so the same image is only stored once
+    id := contentName(data)
+    ext := imageExtensions[img.format]
+    savePath := fmt.Sprintf("uploads/%s.%s", id, ext)
+    release, ok := files.reserve(username, filepath.FromSlash(savePath), int64(len(data)))
+    if !ok {
+        http.Error(w, "Storage quota of " + formatSize(config.UserQuota) + " would be exceeded", http.StatusInsufficientStorage)
+        return
+    }
+    defer release()
+    if err := storeFile(savePath, data); err != nil {
+		log.Println("Error writing file:", savePath, err)
+		http.Error(w, "Could not save file", http.StatusInternalServerError)
 		return
 	}
+    thumbnails, err := img.saveThumbnails(id, ext)
+    if err != nil {
+		log.Println("Error writing thumbnails:", savePath, err)
+		http.Error(w, "Could not save file", http.StatusInternalServerError)
+		return
+    }
+    files.add(savePath, username, int64(len(data)), time.Now())
+    metrics.uploaded("image", int64(len(data)))
 
-    fmt.Fprintf(w, "File uploaded successfully: %s\n", savePath)
+    log.Println("image uploaded:", savePath, img.format, len(thumbnails), "thumbnails")
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(shared.UploadedImage{URL: MakeImageLink(fileServerURL, id, ext), Thumbnails: thumbnails})
 }
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"multi-room_chat_system/shared"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"github.com/disintegration/imaging"
+)
+
+//largest image, in pixels, that is decoded at all, so a small file cannot expand into a huge image
+const maxImagePixels = 50_000_000
+
+//where thumbnails are stored inside ./uploads, one directory per width
+const thumbnailDir = "thumbs"
+
+//widths of the thumbnails made for every uploaded image, smallest first
+var thumbnailWidths = []int{160, 480}
+
+//file extension stored images get for each format they are kept in
+var imageExtensions = map[string]string{"jpeg": "jpg", "png": "png", "gif": "gif"}
+
+//uploaded image that decoded cleanly, ready to be written back in its original format
+type processedImage struct {
+	//"jpeg", "png" or "gif"
+	format string
+	//the image, nil for animations
+	still image.Image
+	//every frame of an animated gif
+	animation *gif.GIF
+	//what the image looks like, used for thumbnails
+	preview image.Image
+}
+
+//function that decodes and verifies an uploaded image, still images larger than config.MaxImageDimension are scaled down
+func processImage(r io.ReadSeeker) (*processedImage, error) {
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, fmt.Errorf("File is not an image")
+	}
+	if _, ok := imageExtensions[format]; !ok {
+		return nil, fmt.Errorf("Only JPEG, PNG and GIF images can be uploaded")
+	}
+	if cfg.Width * cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("Image is too large")
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img := &processedImage{format: format}
+	maxDim := config.MaxImageDimension
+	if format == "gif" {
+		g, err := gif.DecodeAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("Image data is corrupt")
+		}
+		if len(g.Image) > 1 {
+			//resizing an animation frame by frame is not supported, so it has to fit as is
+			if maxDim > 0 && (cfg.Width > maxDim || cfg.Height > maxDim) {
+				return nil, fmt.Errorf("Animated GIFs may be at most %dx%d pixels", maxDim, maxDim)
+			}
+			img.animation = g
+			img.preview = firstFrame(g)
+			return img, nil
+		}
+		img.still = g.Image[0]
+	} else {
+		//jpeg orientation lives in the EXIF data that is stripped, so apply it to the pixels first
+		img.still, err = imaging.Decode(r, imaging.AutoOrientation(true))
+		if err != nil {
+			return nil, fmt.Errorf("Image data is corrupt")
+		}
+	}
+	if b := img.still.Bounds(); maxDim > 0 && (b.Dx() > maxDim || b.Dy() > maxDim) {
+		img.still = imaging.Fit(img.still, maxDim, maxDim, imaging.Lanczos)
+	}
+	img.preview = img.still
+	return img, nil
+}
+
+//function that draws the first frame of an animation onto the full canvas
+func firstFrame(g *gif.GIF) image.Image {
+	frame := g.Image[0]
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+	return canvas
+}
+
+//function that encodes the image in its original format, re-encoding it leaves out any EXIF, GPS or text metadata
+func (img *processedImage) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if img.animation != nil {
+		err = gif.EncodeAll(&buf, img.animation)
+	} else {
+		err = encodeImage(&buf, img.still, img.format)
+	}
+	return buf.Bytes(), err
+}
+
+//function that writes a thumbnail for every width smaller than the image, returns the thumbnails made
+func (img *processedImage) saveThumbnails(id string, ext string) ([]shared.Thumbnail, error) {
+	thumbnails := make([]shared.Thumbnail, 0, len(thumbnailWidths))
+	for _, width := range thumbnailWidths {
+		if width >= img.preview.Bounds().Dx() {
+			break
+		}
+		dir := filepath.Join("uploads", thumbnailDir, strconv.Itoa(width))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return thumbnails, err
+		}
+		thumb := &processedImage{format: img.format, still: imaging.Resize(img.preview, width, 0, imaging.Lanczos)}
+		data, err := thumb.encode()
+		if err != nil {
+			return thumbnails, err
+		}
+		if err := storeFile(filepath.Join(dir, id + "." + ext), data); err != nil {
+			return thumbnails, err
+		}
+		thumbnails = append(thumbnails, shared.Thumbnail{Width: width, URL: thumbnailLink(width, id, ext)})
+	}
+	return thumbnails, nil
+}
+
+//function that encodes a still image in the given format
+func encodeImage(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	}
+	return fmt.Errorf("unsupported image format %q", format)
+}
+
+//create a thumbnail link for an uploaded image
+func thumbnailLink(width int, uuid string, ext string) string {
+	return fmt.Sprintf("%s/uploads/%s/%d/%s.%s", fileServerURL, thumbnailDir, width, uuid, ext)
+}
+
+//function that returns the thumbnails stored for an uploaded image, images uploaded before thumbnails existed have none
+func imageThumbnails(uuid string, ext string) []shared.Thumbnail {
+	var thumbnails []shared.Thumbnail
+	for _, width := range thumbnailWidths {
+		if _, err := os.Stat(filepath.Join("uploads", thumbnailDir, strconv.Itoa(width), uuid + "." + ext)); err == nil {
+			thumbnails = append(thumbnails, shared.Thumbnail{Width: width, URL: thumbnailLink(width, uuid, ext)})
+		}
+	}
+	return thumbnails
+}
+
+//function that returns the image a thumbnail's path on the file server was made from, other paths are returned as is
+func thumbnailSource(file string) string {
+	dir, name := path.Split(file)
+	if path.Dir(path.Clean(dir)) != "uploads/" + thumbnailDir {
+		return file
+	}
+	return "uploads/" + name
+}
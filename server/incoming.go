@@ -0,0 +1,177 @@
+package server
+
+import (
+	"crypto/subtle"
+	"io"
+	"maps"
+	"mime"
+	"multi-room_chat_system/shared"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//longest text an incoming webhook can post
+const maxIncomingText = 4000
+
+//URL that external tools post to so their messages appear in a room under the integration's name (owned by the room's goroutine)
+type incomingHook struct {
+	ID string
+	//sender shown for its messages, never a user's name
+	Name string
+	//hash of the token in its URL, the URL is only shown when it is added
+	TokenHash string
+}
+
+//room an incoming webhook belongs to, indexed by the webhook's id so a post does not look through every room
+type incomingRef struct {
+	room *Room
+	hook *incomingHook
+}
+
+//incoming webhook RPC request (http handler -> server)
+type HookRequest struct {
+	ID string
+	Token string
+	Text string
+	Resp chan apiResponse
+}
+
+//body of a JSON post to an incoming webhook, plain text bodies are posted as they are
+type incomingMessage struct {
+	Text string
+}
+
+//function that describes an incoming webhook for /webhook list
+func (ih *incomingHook) String() string {
+	return ih.ID + " incoming as " + ih.Name
+}
+
+//function that returns the URL an incoming webhook is posted to
+func incomingURL(id string, token string) string {
+	return fileServerURL + "/hooks/" + id + "/" + token
+}
+
+//function that adds an incoming webhook to the room
+func (rm *Room) addIncoming(ih *incomingHook) {
+	rm.do(func() { rm.incoming = append(rm.incoming, ih) })
+}
+
+//function that removes an incoming webhook from the room
+func (rm *Room) removeIncoming(id string) {
+	rm.do(func() {
+		rm.incoming = slices.DeleteFunc(rm.incoming, func(ih *incomingHook) bool { return ih.ID == id })
+	})
+}
+
+//get the room's incoming webhooks
+func (rm *Room) incomingHooks() []*incomingHook {
+	var hooks []*incomingHook
+	rm.do(func() { hooks = slices.Clone(rm.incoming) })
+	return hooks
+}
+
+//function that adds an incoming webhook to a room and indexes it (server goroutine only)
+func (s *ServerState) addIncoming(rm *Room, ih *incomingHook) {
+	rm.addIncoming(ih)
+	s.incoming[ih.ID] = incomingRef{room: rm, hook: ih}
+}
+
+//function that removes an incoming webhook from its room and the index (server goroutine only)
+func (s *ServerState) removeIncoming(id string) {
+	if ref, exists := s.incoming[id]; exists {
+		ref.room.removeIncoming(id)
+		delete(s.incoming, id)
+	}
+}
+
+//function that drops a deleted room's incoming webhooks from the index (server goroutine only)
+func (s *ServerState) forgetIncoming(rm *Room) {
+	maps.DeleteFunc(s.incoming, func(_ string, ref incomingRef) bool { return ref.room == rm })
+}
+
+//function that finds the room an incoming webhook belongs to (server goroutine only)
+func (s *ServerState) findIncoming(id string) (*Room, *incomingHook) {
+	ref, exists := s.incoming[id]
+	if !exists {
+		return nil, nil
+	}
+	return ref.room, ref.hook
+}
+
+//function that reports whether an incoming webhook posts under a name, users and bots cannot take it (server goroutine only)
+func (s *ServerState) integrationName(name string) bool {
+	for _, ref := range s.incoming {
+		if ref.hook.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+//function that posts an incoming webhook's text to its room as the integration (server goroutine only)
+func (s *ServerState) serveHook(req HookRequest, now time.Time) apiResponse {
+	rm, ih := s.findIncoming(req.ID)
+	if ih == nil || subtle.ConstantTimeCompare([]byte(ih.TokenHash), []byte(hashToken(req.Token))) != 1 {
+		return apiResponse{http.StatusNotFound, apiError{"No such webhook"}}
+	}
+	m := &Message{Message: &shared.Message{MsgMetadata: shared.MsgMetadata{UserName: ih.Name, Timestamp: now, Content: req.Text}, Integration: true}}
+	if !rm.postExternal(m) {
+		return apiResponse{http.StatusNotFound, apiError{"No such webhook"}}
+	}
+	inspectLater(rm, m.Message)
+	return apiResponse{http.StatusCreated, newTranscript(rm.name, []shared.Message{*m.Message}, time.Time{}, time.Time{}, now).Messages[0]}
+}
+
+//function that sends an incoming webhook's post to the server goroutine, returns false if the server is shutting down
+func (s *ServerState) CallHook(id string, token string, text string) (int, any, bool) {
+	req := HookRequest{ID: id, Token: token, Text: text, Resp: make(chan apiResponse, 1)}
+	select {
+	case s.recvHook <- req:
+		resp := <-req.Resp
+		return resp.status, resp.body, true
+	case <-s.term:
+		return 0, nil, false
+	}
+}
+
+//handler for posts to incoming webhooks, the token in the path is all the authentication they need
+func hookHandler(w http.ResponseWriter, r *http.Request) {
+	var text string
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		var body incomingMessage
+		if err := readAPIBody(w, r, &body); err != nil {
+			writeAPI(w, http.StatusBadRequest, apiError{err.Error()})
+			return
+		}
+		text = body.Text
+	} else {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, apiMaxBody))
+		if err != nil {
+			writeAPI(w, http.StatusBadRequest, apiError{"invalid request body: " + err.Error()})
+			return
+		}
+		text = string(body)
+	}
+	//lines are kept so build output and lists read as they were sent
+	text = strings.TrimRight(strings.ReplaceAll(text, "\r\n", "\n"), " \t\n")
+	text = strings.TrimLeft(text, "\n")
+	if strings.TrimSpace(text) == "" || !utf8.ValidString(text) {
+		writeAPI(w, http.StatusBadRequest, apiError{"Text must be non-empty UTF-8"})
+		return
+	}
+	if utf8.RuneCountInString(text) > maxIncomingText {
+		writeAPI(w, http.StatusRequestEntityTooLarge, apiError{"Text is longer than " + strconv.Itoa(maxIncomingText) + " characters"})
+		return
+	}
+	status, body, ok := GetServerState().CallHook(r.PathValue("id"), r.PathValue("token"), text)
+	if !ok {
+		writeAPI(w, http.StatusServiceUnavailable, apiError{"Server is shutting down"})
+		return
+	}
+	writeAPI(w, status, body)
+}
@@ -0,0 +1,760 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"multi-room_chat_system/shared"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+//maximum number of messages replayed to an IRC client after it joins a room
+const ircHistoryLines = 25
+
+//state for a single IRC client connection
+type ircConn struct {
+	conn net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+	nick string
+	user *Member
+	//room the IRC client is currently in
+	room string
+}
+
+//function that listens for IRC clients until the server shuts down
+func startIRCGateway(addr string) {
+	s := GetServerState()
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Println("Error starting IRC gateway:", err)
+		return
+	}
+	fmt.Println("IRC gateway listening on", addr)
+
+	//unblock accept on shutdown
+	go func() {
+		<-s.term
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.term:
+				log.Println("IRC gateway is no longer accepting connections!")
+				return
+			default:
+				log.Println("Failed to accept IRC connection:", err)
+				continue
+			}
+		}
+		fmt.Println("Accepted IRC connection", conn)
+		go handleIRCConnection(conn)
+	}
+}
+
+//function to handle an IRC client from registration until it disconnects
+func handleIRCConnection(conn net.Conn) {
+	c := &ircConn{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+	if !c.register() {
+		conn.Close()
+		return
+	}
+	c.serve()
+}
+
+//function to synchronously complete IRC registration (NICK + USER) and log the nick into the server
+func (c *ircConn) register() bool {
+	var gotUser bool
+	for c.nick == "" || !gotUser {
+		//clients that never finish registering are dropped
+		setReadDeadline(c.conn)
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			fmt.Println("IRC client disconnected before registering:", err)
+			return false
+		}
+		cmd, params := parseIRCLine(line)
+		switch cmd {
+		case "":
+		case "NICK":
+			if len(params) < 1 {
+				c.numeric("431", ":No nickname given")
+				continue
+			}
+			c.nick = params[0]
+		case "USER":
+			if len(params) < 4 {
+				c.numeric("461", "USER :Not enough parameters")
+				continue
+			}
+			gotUser = true
+		case "PING":
+			c.pong(params)
+		case "CAP":
+			if len(params) > 0 && strings.ToUpper(params[0]) == "LS" {
+				c.sendf(":%s CAP * LS :", config.ServerName)
+			}
+		case "PASS":
+		case "QUIT":
+			return false
+		default:
+			c.numeric("451", cmd + " :You have not registered")
+		}
+	}
+
+	//send JoinRPC to the server state
+	s := GetServerState()
+	resp := &ServerJoinResponse{}
+	s.JoinServer(c.nick, resp)
+	msg := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(resp.Message), ">"))
+	if !resp.Status {
+		log.Println(msg)
+		if resp.Role != nil && resp.Role.Role == RoleBanned {
+			c.numeric("465", ":" + msg)
+		} else {
+			c.numeric("433", c.nick + " :" + msg)
+		}
+		c.sendf("ERROR :Closing link: %s", msg)
+		return false
+	}
+	c.user = resp.Role
+
+	//welcome the client and show the rooms it can join
+	c.numeric("001", ":Welcome to " + config.ServerName + ", " + c.nick)
+	c.numeric("002", ":Your host is " + config.ServerName)
+	c.numeric("422", ":MOTD File is missing")
+	for _, line := range strings.Split(msg, "\n") {
+		c.notice(line)
+	}
+	return true
+}
+
+//function to asynchronously handle an IRC client once it is logged in, mirrors handleConnection
+func (c *ircConn) serve() {
+	s := GetServerState()
+	user := c.user
+	//start listener goroutine to listen for client input
+	userInput := make(chan string)
+	go getUserInput(c.reader, c.conn, user, userInput)
+	//start heartbeat and idle timers
+	timers := newConnTimers(user)
+	defer timers.stop()
+	//this loop is the connection's writer, it translates everything queued for the user
+	flush := func() {
+		for _, msg := range user.out.drain() {
+			c.deliver(msg)
+		}
+	}
+	closeConn := func() {
+		user.out.close()
+		flush()
+		close(user.ToServer)
+		c.conn.Close()
+	}
+
+	for {
+		select {
+		//listen for updates from the server/room
+		case <-user.out.ready:
+			flush()
+
+		//listen for input from the IRC client
+		case line := <-userInput:
+			log.Println("IRC connectionHandler received:", line)
+			//heartbeats do not count as activity
+			if cmd, _ := parseIRCLine(line); cmd != "PING" && cmd != "PONG" && timers.activity() {
+				s.SetIdle(user, false)
+			}
+			c.handleLine(line)
+
+		//ping the client so dead connections are noticed even when nothing is being sent
+		case <-timers.heartbeatC():
+			c.sendf("PING :%s", config.ServerName)
+
+		//user has not sent any input for a while
+		case <-timers.awayC():
+			timers.wentAway()
+			s.SetIdle(user, true)
+
+		//user has not sent any input for too long
+		case <-timers.idleC():
+			log.Println(user.Username, "was idle for", time.Duration(config.IdleTimeout), "disconnecting")
+			c.sendf("ERROR :Closing link: idle for %s", time.Duration(config.IdleTimeout))
+			safeClose(user.Term)
+
+		//if user/server is terminated
+		case <-user.Term:
+			log.Println("IRC user terminated, sending quit")
+			//IRC clients cannot resume, so a dropped connection quits right away
+			s.Disconnect(user, false)
+			closeConn()
+			return
+		case <-s.term:
+			log.Println("server terminated, exiting IRC connection loop for", user.Username)
+			closeConn()
+			return
+		}
+	}
+}
+
+//function that translates a single IRC command into the matching chat command
+func (c *ircConn) handleLine(line string) {
+	cmd, params := parseIRCLine(line)
+	switch cmd {
+	case "", "PONG", "CAP":
+	case "PING":
+		c.pong(params)
+	case "JOIN":
+		if len(params) < 1 {
+			c.numeric("461", "JOIN :Not enough parameters")
+			return
+		}
+		//rooms are exclusive, so only the first channel of a list is joined
+		channel := strings.Split(params[0], ",")[0]
+		if channel == "0" {
+			c.leave("")
+			return
+		}
+		c.join(channel)
+	case "PART":
+		if len(params) < 1 {
+			c.numeric("461", "PART :Not enough parameters")
+			return
+		}
+		c.leave(strings.Split(params[0], ",")[0])
+	case "PRIVMSG", "NOTICE":
+		c.privmsg(params)
+	case "NAMES":
+		c.names()
+	case "LIST":
+		c.list()
+	case "KICK":
+		c.kick(params)
+	case "TOPIC":
+		c.topic(params)
+	case "AWAY":
+		c.away(params)
+	case "QUIT":
+		c.deliver(c.exec("/quit"))
+	case "MODE":
+		if len(params) > 0 && strings.HasPrefix(params[0], "#") {
+			c.numeric("324", params[0] + " +")
+		} else {
+			c.numeric("221", "+")
+		}
+	case "WHO":
+		target := "*"
+		if len(params) > 0 {
+			target = params[0]
+		}
+		c.numeric("315", target + " :End of WHO list")
+	case "NICK":
+		c.notice("Nickname changes are not supported, reconnect with a new nick")
+	case "USER":
+		c.numeric("462", ":You may not reregister")
+	default:
+		c.numeric("421", cmd + " :Unknown command")
+	}
+}
+
+//function that joins a room, emulating the IRC JOIN reply sequence
+func (c *ircConn) join(channel string) {
+	if channel == c.room {
+		return
+	}
+	j, ok := c.exec("/join " + channel).(*JoinCmd)
+	if !ok {
+		return
+	}
+	if !j.Reply.Status {
+		c.numeric("403", channel + " :" + j.Reply.ErrMsg)
+		return
+	}
+	//joining a room implicitly leaves the previous one
+	if c.room != "" {
+		c.sendf(":%s PART %s", hostmask(c.nick), c.room)
+	}
+	c.room = j.Room
+	c.sendf(":%s JOIN %s", hostmask(c.nick), c.room)
+	c.numeric("331", c.room + " :No topic is set")
+	c.names()
+
+	//replay the most recent room history as notices
+	history := j.Reply.Log
+	if len(history) > ircHistoryLines {
+		history = history[len(history) - ircHistoryLines:]
+	}
+	for _, msg := range history {
+		c.sendf(":%s NOTICE %s :%s", config.ServerName, c.room, formatIRCHistory(msg))
+	}
+}
+
+//function that leaves the current room, channel is empty when leaving whatever room the client is in
+func (c *ircConn) leave(channel string) {
+	if c.room == "" || (channel != "" && channel != c.room) {
+		c.numeric("442", channel + " :You're not on that channel")
+		return
+	}
+	l, ok := c.exec("/leave").(*LeaveCmd)
+	if !ok {
+		return
+	}
+	if !l.Reply.Status {
+		c.notice(l.Reply.ErrMsg)
+		return
+	}
+	c.sendf(":%s PART %s", hostmask(c.nick), l.Room)
+	c.room = ""
+}
+
+//function that sends a message to the current room, or a command when addressed to the server
+func (c *ircConn) privmsg(params []string) {
+	if len(params) < 1 {
+		c.numeric("411", ":No recipient given (PRIVMSG)")
+		return
+	}
+	if len(params) < 2 || params[1] == "" {
+		c.numeric("412", ":No text to send")
+		return
+	}
+	target, text := params[0], params[1]
+	//translate CTCP ACTION (/me) into plain text, drop other CTCP requests
+	if strings.HasPrefix(text, "\x01") {
+		if !strings.HasPrefix(text, "\x01ACTION ") {
+			return
+		}
+		text = "* " + strings.TrimSuffix(strings.TrimPrefix(text, "\x01ACTION "), "\x01")
+	}
+
+	//messages addressed to the server are run as chat commands
+	if strings.EqualFold(target, config.ServerName) {
+		c.command(text)
+		return
+	}
+	if target != c.room {
+		if strings.HasPrefix(target, "#") {
+			c.numeric("404", target + " :Cannot send to channel, you are not in it")
+		} else {
+			c.numeric("401", target + " :No such nick/channel (private messages are not supported)")
+		}
+		return
+	}
+	//text sent to a room is never run as a command, commands are sent to the server
+	if strings.HasPrefix(text, "/") {
+		c.notice("Messages starting with / are not sent to the room, to run a command send it to the server, e.g. /msg " + config.ServerName + " " + text)
+		return
+	}
+	//posted through the room's goroutine like any client's chat message, which checks the user is still in the room
+	c.deliver(c.exec(text))
+}
+
+//function that runs a chat command sent to the server, routing the ones with IRC equivalents through them
+func (c *ircConn) command(text string) {
+	if !strings.HasPrefix(text, "/") {
+		text = "/" + text
+	}
+	parts := strings.Fields(text)
+	switch {
+	case parts[0] == "/join" && len(parts) == 2:
+		c.join(parts[1])
+	case parts[0] == "/leave" && len(parts) == 1:
+		c.leave("")
+	default:
+		c.deliver(c.exec(text))
+	}
+}
+
+//function that lists the users in the current room
+func (c *ircConn) names() {
+	if c.room == "" {
+		c.numeric("366", "* :End of /NAMES list")
+		return
+	}
+	lu, ok := c.exec("/listusers").(*ListUsersCmd)
+	if !ok {
+		return
+	}
+	if lu.Reply.Status {
+		names := make([]string, 0, len(lu.Reply.Users))
+		for _, user := range lu.Reply.Users {
+			names = append(names, ircNick(user))
+		}
+		c.numeric("353", "= " + lu.Reply.Room + " :" + strings.Join(names, " "))
+	}
+	c.numeric("366", c.room + " :End of /NAMES list")
+}
+
+//function that lists the rooms the user can join
+func (c *ircConn) list() {
+	lr, ok := c.exec("/listrooms").(*ListRoomsCmd)
+	if !ok {
+		return
+	}
+	c.numeric("321", "Channel :Users  Name")
+	if lr.Status {
+		//first line of the reply is the "Available rooms:" header
+		lines := strings.Split(lr.ErrMsg, "\n")
+		for _, line := range lines[1:] {
+			if room := strings.TrimSpace(line); room != "" {
+				c.numeric("322", room + " 0 :")
+			}
+		}
+	}
+	c.numeric("323", ":End of /LIST")
+}
+
+//function that kicks a user, IRC KICK takes the channel first, which must be the room both users are in
+func (c *ircConn) kick(params []string) {
+	if len(params) < 2 {
+		c.numeric("461", "KICK :Not enough parameters")
+		return
+	}
+	channel, target := params[0], params[1]
+	if c.room == "" || channel != c.room {
+		c.numeric("442", channel + " :You're not on that channel")
+		return
+	}
+	lu, ok := c.exec("/listusers").(*ListUsersCmd)
+	if !ok {
+		return
+	}
+	if !lu.Reply.Status || !slices.Contains(lu.Reply.Users, target) {
+		c.numeric("441", target + " " + channel + " :They aren't on that channel")
+		return
+	}
+	line := "/kick " + target
+	if len(params) > 2 {
+		line += " " + params[2]
+	}
+	kb, ok := c.exec(line).(*KickBanCmd)
+	if !ok {
+		return
+	}
+	if !kb.Status {
+		c.numeric("482", channel + " :" + kb.ErrMsg)
+		return
+	}
+	if kb.InRoom {
+		c.sendf(":%s KICK %s %s :Kicked by %s", hostmask(c.nick), c.room, ircNick(kb.User), c.nick)
+	}
+	c.notice("[SERVER] " + kb.User + " was kicked successfully")
+}
+
+//function that marks the user away with a message, or back online without one
+func (c *ircConn) away(params []string) {
+	line := "/status " + shared.PresenceOnline
+	if len(params) > 0 && strings.TrimSpace(params[0]) != "" {
+		line = "/status " + shared.PresenceAway + " " + params[0]
+	}
+	reply, ok := c.exec(line).(*StatusCmd)
+	if !ok {
+		return
+	}
+	switch {
+	case !reply.Status:
+		c.notice(reply.ErrMsg)
+	case len(params) > 0 && strings.TrimSpace(params[0]) != "":
+		c.numeric("306", ":You have been marked as being away")
+	default:
+		c.numeric("305", ":You are no longer marked as being away")
+	}
+}
+
+//function that answers TOPIC, rooms do not have topics so they cannot be changed
+func (c *ircConn) topic(params []string) {
+	if len(params) < 1 {
+		c.numeric("461", "TOPIC :Not enough parameters")
+		return
+	}
+	if len(params) == 1 {
+		c.numeric("331", params[0] + " :No topic is set")
+		return
+	}
+	c.numeric("482", params[0] + " :Room topics are not supported on this server")
+}
+
+//function that sends a chat command to the server on behalf of the IRC client and waits for the reply
+func (c *ircConn) exec(content string) shared.ExecutableMessage {
+	return submitInput(c.user, content)
+}
+
+//function that translates a server message into IRC lines for the client
+func (c *ircConn) deliver(msg shared.ExecutableMessage) {
+	switch m := msg.(type) {
+	case *Message:
+		c.deliverMessage(m.Message)
+	case *BroadcastCmd:
+		if !m.Status {
+			c.notice(m.ErrMsg)
+			return
+		}
+		if m.UserName != c.nick {
+			c.sendf(":%s NOTICE %s :[BROADCAST] %s", hostmask(m.UserName), c.nick, m.Content)
+		}
+	case *JoinCmd:
+		c.notice(m.Reply.ErrMsg)
+	case *LeaveCmd:
+		//forced leave after a room is deleted or the user is demoted
+		if m.Reply.Status && m.Room == c.room {
+			c.sendf(":%s PART %s :Removed from room", hostmask(c.nick), c.room)
+			c.room = ""
+			return
+		}
+		c.notice(m.Reply.ErrMsg)
+	case *ListUsersCmd:
+		if !m.Reply.Status {
+			c.notice(m.Reply.ErrMsg)
+			return
+		}
+		users := make([]string, 0, len(m.Reply.Presence))
+		for _, p := range m.Reply.Presence {
+			users = append(users, p.String())
+		}
+		c.notice("Users in " + m.Reply.Room + ": " + strings.Join(users, ", "))
+	case *HelpCmd:
+		if m.Invalid {
+			c.notice(m.Reply.ErrMsg)
+		}
+		for _, usage := range m.Reply.Usage {
+			c.notice(usage)
+		}
+	case *QuitCmd:
+		c.sendf("ERROR :Closing link: %s", c.nick)
+	case *KickBanCmd:
+		if !m.Status {
+			c.notice(m.ErrMsg)
+			return
+		}
+		if m.Sender {
+			if m.Ban {
+				c.notice("[SERVER] " + m.User + " was banned successfully")
+			} else {
+				c.notice("[SERVER] " + m.User + " was kicked successfully")
+			}
+			return
+		}
+		//this client was the one kicked/banned
+		if c.room != "" {
+			c.sendf(":%s KICK %s %s :%s", config.ServerName, c.room, ircNick(c.nick), m.ErrMsg)
+		}
+		c.sendf("ERROR :Closing link: %s", m.ErrMsg)
+	case *UnBanCmd:
+		c.notice(m.ErrMsg)
+	case *CreateCmd:
+		c.notice(m.ErrMsg)
+	case *DeleteCmd:
+		if m.Status && m.InRoom {
+			c.sendf(":%s PART %s :Room deleted", hostmask(c.nick), c.room)
+			c.room = ""
+		}
+		c.notice(m.ErrMsg)
+	case *PromoteDemoteCmd:
+		c.notice(m.ErrMsg)
+	case *ShutdownCmd:
+		if m.Sender || !m.Status {
+			c.notice(m.ErrMsg)
+		}
+		if m.Status {
+			c.sendf("ERROR :Closing link: server is shutting down")
+		}
+	case *ListRoomsCmd:
+		c.notice(m.ErrMsg)
+	case *RetentionCmd:
+		c.notice(m.ErrMsg)
+	case *ExportCmd:
+		c.notice(m.ErrMsg)
+	case *BackupCmd:
+		c.notice(m.ErrMsg)
+	case *AuditCmd:
+		c.notice(m.ErrMsg)
+	case *APITokenCmd:
+		c.notice(m.ErrMsg)
+	case *BotCmd:
+		c.notice(m.ErrMsg)
+	case *WebhookCmd:
+		c.notice(m.ErrMsg)
+	case *CommandReply:
+		c.notice(m.ErrMsg)
+	case *RoleCmd:
+		c.notice(m.ErrMsg)
+	case *TransferOwnerCmd:
+		c.notice(m.ErrMsg)
+	case *StatusCmd:
+		c.notice(m.ErrMsg)
+	case *UploadTokenCmd:
+		if !m.Status {
+			c.notice(m.ErrMsg)
+			return
+		}
+		c.notice("Upload token " + m.Token + " (expires " + m.Expires.Format("15:04:05") + ")")
+	case *RoomUpdate:
+		if m.Create {
+			c.notice("Room " + m.Room + " is now available")
+		} else {
+			c.notice("Room " + m.Room + " was deleted")
+		}
+	case *UserUpdate:
+		if m.Promote {
+			c.notice("You have been promoted")
+		} else {
+			c.notice("You have been demoted")
+		}
+	case *GetLog:
+		lines := m.Log
+		if len(lines) > ircHistoryLines {
+			lines = lines[len(lines) - ircHistoryLines:]
+		}
+		for _, line := range lines {
+			c.notice(line)
+		}
+	case *UpdateLobby:
+		c.notice(m.Update)
+	case *PresenceUpdate:
+		if m.Changed != nil && m.Room == c.room {
+			c.notice(m.Changed.User + " is now " + describePresence(*m.Changed))
+		}
+	case *MessageUpdate:
+		if m.Preview != nil && m.Room == c.room {
+			c.sendf(":%s NOTICE %s :[preview] %s", config.ServerName, m.Room, formatIRCPreview(m.Preview))
+		}
+	}
+}
+
+//function that formats a link preview card as a single IRC line
+func formatIRCPreview(p *shared.LinkPreview) string {
+	text := p.Title
+	if p.Description != "" {
+		if text != "" {
+			text += " - "
+		}
+		text += p.Description
+	}
+	return text
+}
+
+//function that translates a chat message into IRC lines
+func (c *ircConn) deliverMessage(m *shared.Message) {
+	if !m.Response.Status {
+		c.notice(m.Response.ErrMsg)
+		return
+	}
+	room := m.Response.CurrentRoom
+	if m.Flag {
+		//join/leave notifications map onto IRC JOIN/PART
+		switch m.Content {
+		case " joined " + room:
+			c.sendf(":%s JOIN %s", hostmask(m.UserName), room)
+		case " left " + room:
+			c.sendf(":%s PART %s", hostmask(m.UserName), room)
+		default:
+			//staff notifications
+			c.notice(m.UserName + m.Content)
+		}
+		return
+	}
+	//the sender's own message is acknowledged, IRC does not echo it back
+	if m.UserName == c.nick {
+		return
+	}
+	for _, line := range strings.Split(m.Content, "\n") {
+		c.sendf(":%s PRIVMSG %s :%s", hostmask(m.UserName), room, line)
+	}
+}
+
+//function to send a server notice to the client, one line at a time
+func (c *ircConn) notice(text string) {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			c.sendf(":%s NOTICE %s :%s", config.ServerName, c.target(), line)
+		}
+	}
+}
+
+//function to send a numeric reply to the client
+func (c *ircConn) numeric(code string, text string) {
+	c.sendf(":%s %s %s %s", config.ServerName, code, c.target(), text)
+}
+
+//function to answer a PING from the client
+func (c *ircConn) pong(params []string) {
+	token := config.ServerName
+	if len(params) > 0 {
+		token = params[0]
+	}
+	c.sendf(":%s PONG %s :%s", config.ServerName, config.ServerName, token)
+}
+
+//function to format and write a single line to the client
+func (c *ircConn) sendf(format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	//never let message content inject extra IRC lines
+	line = strings.NewReplacer("\r", " ", "\n", " ").Replace(line)
+	//do not block forever on a client that stopped reading, a failed write ends the session
+	setWriteDeadline(c.conn)
+	c.writer.WriteString(line + "\r\n")
+	if err := c.writer.Flush(); err != nil && c.user != nil {
+		safeClose(c.user.Term)
+	}
+}
+
+//helper function that returns the nick numerics are addressed to
+func (c *ircConn) target() string {
+	if c.nick == "" {
+		return "*"
+	}
+	return ircNick(c.nick)
+}
+
+//helper function to split a raw IRC line into its command and parameters
+func parseIRCLine(line string) (string, []string) {
+	line = strings.TrimRight(line, "\r\n")
+	//drop the optional source prefix
+	if strings.HasPrefix(line, ":") {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			return "", nil
+		}
+		line = line[i + 1:]
+	}
+	var params []string
+	trailing, hasTrailing := "", false
+	if i := strings.Index(line, " :"); i >= 0 {
+		trailing, hasTrailing = line[i + 2:], true
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	params = append(params, fields[1:]...)
+	if hasTrailing {
+		params = append(params, trailing)
+	}
+	return strings.ToUpper(fields[0]), params
+}
+
+//helper function that makes a username safe to use as an IRC nick
+func ircNick(username string) string {
+	return strings.ReplaceAll(username, " ", "_")
+}
+
+//helper function that builds the nick!user@host source of a user
+func hostmask(username string) string {
+	nick := ircNick(username)
+	return nick + "!" + nick + "@" + config.ServerName
+}
+
+//helper function to format a room history message for replay
+func formatIRCHistory(m shared.Message) string {
+	time := m.Timestamp.Format("2006-01-02 15:04:05")
+	if m.Flag {
+		return "[" + time + "] " + m.UserName + m.Content
+	}
+	return "[" + time + "] " + m.UserName + ": " + m.Content
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+//function that returns an IRC connection for the user whose lines are read into the returned channel
+func newTestIRCConn(t *testing.T, user *Member, room string) (*ircConn, chan string) {
+	server, client := net.Pipe()
+	t.Cleanup(func() { server.Close(); client.Close() })
+	lines := make(chan string, 100)
+	go func() {
+		scanner := bufio.NewScanner(client)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return &ircConn{conn: server, reader: bufio.NewReader(server), writer: bufio.NewWriter(server), nick: user.Username, user: user, room: room}, lines
+}
+
+//function that returns the next line sent to the IRC client
+func nextIRCLine(t *testing.T, lines chan string) string {
+	t.Helper()
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatal("no line sent to the IRC client")
+		return ""
+	}
+}
+
+//function that returns the contents of the room's log
+func roomContents(rm *Room) []string {
+	var contents []string
+	for _, msg := range rm.history() {
+		if !msg.Flag {
+			contents = append(contents, msg.Content)
+		}
+	}
+	return contents
+}
+
+func TestIRCChannelTextIsNotRunAsCommand(t *testing.T) {
+	s := newTestServer(t)
+	rm := addTestRoom(t, s, "#general", RoleMember)
+	user := addTestUser(s, "admin", RoleAdmin)
+	addTestUser(s, "bob", RoleMember)
+	loginTestUser(s, user, "#general")
+	c, lines := newTestIRCConn(t, user, "#general")
+
+	c.privmsg([]string{"#general", "/ban bob"})
+	if line := nextIRCLine(t, lines); !strings.Contains(line, "NOTICE") {
+		t.Fatalf("got %q, want a notice", line)
+	}
+	if s.users["bob"].Role != RoleMember || len(roomContents(rm)) != 0 {
+		t.Fatalf("channel text was run or posted: role %v, log %v", s.users["bob"].Role, roomContents(rm))
+	}
+}
+
+func TestIRCKickChecksChannel(t *testing.T) {
+	s := newTestServer(t)
+	addTestRoom(t, s, "#general", RoleMember)
+	user := addTestUser(s, "admin", RoleAdmin)
+	loginTestUser(s, user, "#general")
+	c, lines := newTestIRCConn(t, user, "#general")
+
+	c.kick([]string{"#other", "bob"})
+	if line := nextIRCLine(t, lines); !strings.Contains(line, " 442 ") {
+		t.Fatalf("got %q, want 442", line)
+	}
+}
+
+func TestIRCRoomMessagesArePostedThroughRoom(t *testing.T) {
+	s := newTestServer(t)
+	rm := addTestRoom(t, s, "#general", RoleMember)
+	user := addTestUser(s, "bob", RoleMember)
+	loginTestUser(s, user, "#general")
+	c, lines := newTestIRCConn(t, user, "#general")
+
+	c.privmsg([]string{"#general", "hello"})
+	if got := roomContents(rm); !slices.Equal(got, []string{"hello"}) {
+		t.Fatalf("room log %v", got)
+	}
+	//the room turns away a user it no longer has, even if the IRC connection still thinks it is in the room
+	remove("bob", "#general")
+	c.privmsg([]string{"#general", "again"})
+	if line := nextIRCLine(t, lines); !strings.Contains(line, "not currently in a room") {
+		t.Fatalf("got %q", line)
+	}
+	if got := roomContents(rm); !slices.Equal(got, []string{"hello"}) {
+		t.Fatalf("room log %v", got)
+	}
+}
+
+func TestParseIRCLine(t *testing.T) {
+	tests := []struct {
+		line string
+		command string
+		params []string
+	}{
+		{"NICK bob\r\n", "NICK", []string{"bob"}},
+		{":bob!bob@host PRIVMSG #general :hello there", "PRIVMSG", []string{"#general", "hello there"}},
+		{"kick #general alice :too loud", "KICK", []string{"#general", "alice", "too loud"}},
+		{"PRIVMSG #general :", "PRIVMSG", []string{"#general", ""}},
+		{":prefixonly", "", nil},
+		{"", "", nil},
+	}
+	for _, tt := range tests {
+		command, params := parseIRCLine(tt.line)
+		if command != tt.command || !slices.Equal(params, tt.params) {
+			t.Errorf("parseIRCLine(%q) = %q %q, want %q %q", tt.line, command, params, tt.command, tt.params)
+		}
+	}
+}
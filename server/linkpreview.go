@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"multi-room_chat_system/shared"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+	"golang.org/x/net/html"
+)
+
+//most of a page that is read looking for OpenGraph tags, they live in the <head>
+const maxPreviewBytes = 512 << 10
+
+//how many links can be inspected at once
+const maxLinkInspections = 8
+
+//most redirects followed while inspecting a link
+const maxLinkRedirects = 5
+
+//shared address space carriers use for NAT, not reachable from the internet but not covered by netip's IsPrivate
+var carrierNAT = netip.MustParsePrefix("100.64.0.0/10")
+
+//regex to find the first link in a chat message
+var linkRegex = regexp.MustCompile(`https?://[^\s]+`)
+
+//limits how many links are inspected at once
+var linkInspections = make(chan struct{}, maxLinkInspections)
+
+//client used to inspect links, requests are bounded by config.LinkTimeout
+//it only connects to public addresses so users cannot make the server fetch from itself or its network
+var linkClient = &http.Client{
+	Transport: &http.Transport{
+		//a proxy would make the address check below see the proxy instead of the link's host
+		Proxy: nil,
+		DialContext: (&net.Dialer{Timeout: 5 * time.Second, Control: dialPublicOnly}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConns: maxLinkInspections,
+		IdleConnTimeout: 30 * time.Second,
+	},
+	CheckRedirect: checkLinkRedirect,
+}
+
+//function that reports whether an address is on the public internet, loopback, private, link-local (cloud metadata) and similar addresses are not
+func publicIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !carrierNAT.Contains(ip)
+}
+
+//function that refuses connections to addresses that are not public, it runs after DNS resolution so a name pointing at a private address is refused too
+func dialPublicOnly(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || !publicIP(ip) {
+		return fmt.Errorf("refusing to inspect link on non-public address %s", host)
+	}
+	return nil
+}
+
+//function that checks a link can be inspected at all: http or https, and not an address that is not public
+func checkLinkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("refusing to inspect %s link", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("link has no host")
+	}
+	if ip, err := netip.ParseAddr(u.Hostname()); err == nil && !publicIP(ip) {
+		return fmt.Errorf("refusing to inspect link on non-public address %s", u.Hostname())
+	}
+	return nil
+}
+
+//function that reports whether a message is a single http(s) link, the only kind inspectLink looks up
+func isWebLink(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+//function that applies the link checks to every redirect and limits how many are followed
+func checkLinkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxLinkRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxLinkRedirects)
+	}
+	return checkLinkURL(req.URL)
+}
+
+//function that returns the link a posted message should be inspected for, if any
+func inspectableLink(m *shared.Message) string {
+	if !config.LinkPreviews || m.Flag || m.Image || m.Attachment != nil {
+		return ""
+	}
+	//an img: message is the link itself
+	if m.URL {
+		return m.Content
+	}
+	return linkRegex.FindString(m.Content)
+}
+
+//function that inspects a message's link off the hot path and sends the result to the room as a follow-up
+func inspectLater(rm *Room, m *shared.Message) {
+	link := inspectableLink(m)
+	if link == "" {
+		return
+	}
+	id, whole := m.ID, m.URL
+	go func() {
+		linkInspections <- struct{}{}
+		defer func() { <-linkInspections }()
+		image, preview, err := inspectLink(link)
+		if err != nil {
+			log.Println("could not inspect link", link, err)
+			return
+		}
+		update := &shared.MessageUpdate{Room: rm.name, ID: id, URL: link}
+		switch {
+		//only a message that is just the link can be shown as the image
+		case image && whole:
+			update.Image = true
+		case image:
+			update.Preview = &shared.LinkPreview{URL: link, Title: path.Base(link), Image: link}
+		case preview != nil:
+			update.Preview = preview
+		default:
+			return
+		}
+		rm.updateMessage(update)
+	}()
+}
+
+//function that fetches a link and reports whether it is an image, or the preview card for an html page
+func inspectLink(link string) (bool, *shared.LinkPreview, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.LinkTimeout))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return false, nil, err
+	}
+	if err := checkLinkURL(req.URL); err != nil {
+		return false, nil, err
+	}
+	req.Header.Set("User-Agent", config.ServerName + " link preview")
+	resp, err := linkClient.Do(req)
+	if err != nil {
+		return false, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, nil, nil
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "image/") {
+		return true, nil, nil
+	}
+	if !strings.HasPrefix(contentType, "text/html") {
+		return false, nil, nil
+	}
+	return false, parseOpenGraph(io.LimitReader(resp.Body, maxPreviewBytes), link), nil
+}
+
+//function that builds a preview card from a page's OpenGraph tags, falling back to its title and description
+func parseOpenGraph(r io.Reader, link string) *shared.LinkPreview {
+	preview := &shared.LinkPreview{URL: link}
+	var title, description string
+	tokens := html.NewTokenizer(r)
+	for {
+		switch tokens.Next() {
+		case html.ErrorToken:
+			return finishPreview(preview, title, description)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := tokens.Token()
+			switch tok.Data {
+			case "title":
+				if tokens.Next() == html.TextToken {
+					title = strings.TrimSpace(tokens.Token().Data)
+				}
+			case "meta":
+				var key, content string
+				for _, attr := range tok.Attr {
+					switch attr.Key {
+					case "property", "name":
+						key = strings.ToLower(attr.Val)
+					case "content":
+						content = strings.TrimSpace(attr.Val)
+					}
+				}
+				switch key {
+				case "og:title":
+					preview.Title = content
+				case "og:description":
+					preview.Description = content
+				case "og:image":
+					preview.Image = resolveLink(link, content)
+				case "description":
+					description = content
+				}
+			//everything a preview needs is in the <head>
+			case "body":
+				return finishPreview(preview, title, description)
+			}
+		}
+	}
+}
+
+//function that fills in missing OpenGraph fields, returns nil if there is nothing worth showing
+func finishPreview(preview *shared.LinkPreview, title string, description string) *shared.LinkPreview {
+	if preview.Title == "" {
+		preview.Title = title
+	}
+	if preview.Description == "" {
+		preview.Description = description
+	}
+	if preview.Title == "" && preview.Description == "" {
+		return nil
+	}
+	return preview
+}
+
+//function that turns a link found on a page into an absolute link
+func resolveLink(base string, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	return b.ResolveReference(r).String()
+}
@@ -1,20 +1,123 @@
 package server
 
 import (
+	"fmt"
 	"multi-room_chat_system/shared"
+	"slices"
 	"time"
 )
 
+//actions recorded in the server log, what /audit filters on
+const (
+	ActionLogin = "login"
+	ActionLogout = "logout"
+	ActionJoin = "join"
+	ActionLeave = "leave"
+	ActionKick = "kick"
+	ActionBan = "ban"
+	ActionUnban = "unban"
+	ActionPromote = "promote"
+	ActionDemote = "demote"
+	ActionCreate = "create"
+	ActionDelete = "delete"
+	ActionRetention = "retention"
+	ActionPrune = "prune"
+	ActionBackup = "backup"
+	ActionToken = "apitoken"
+	ActionBot = "bot"
+	ActionWebhook = "webhook"
+	ActionRole = "role"
+	ActionTransfer = "transferowner"
+	ActionShutdown = "shutdown"
+)
+
+//every action /audit can filter on
+var auditActions = []string{ActionLogin, ActionLogout, ActionJoin, ActionLeave, ActionKick, ActionBan, ActionUnban, ActionPromote, ActionDemote, ActionCreate, ActionDelete, ActionRetention, ActionPrune, ActionBackup, ActionToken, ActionBot, ActionWebhook, ActionRole, ActionTransfer, ActionShutdown}
+
+//entry of the server log, Event is the readable line staff are shown and the rest is what it can be searched by
 type Log struct{
 	Event string
 	Timestamp time.Time
+	//user who did it, empty for the server itself
+	Actor string `json:",omitempty"`
+	Action string `json:",omitempty"`
+	//user (or file) it was done to
+	Target string `json:",omitempty"`
+	Room string `json:",omitempty"`
+	Reason string `json:",omitempty"`
 }
 
-//function to format a log event for the server
-func logEvent(event string, time time.Time, sender string) Log {
-	log := Log{Event: event, Timestamp: time}
-	broadcastStaffLobby(sender, log)
-	return log
+//helper function that adds an optional reason to a line about a kick or ban
+func withReason(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return " (" + reason + ")"
+}
+
+//function that records an event in the server log and shows it to staff in the lobby (server goroutine only)
+func (s *ServerState) audit(entry Log) {
+	broadcastStaffLobby(entry.Actor, entry)
+	s.logger = append(s.logger, entry)
+	//moderation in a room is also sent to the room's webhooks
+	if rm, exists := s.rooms[entry.Room]; exists && slices.Contains(moderationActions, entry.Action) {
+		rm.notifyLater(webhookPayload{Event: EventModeration, Timestamp: entry.Timestamp, Moderation: &entry})
+	}
+}
+
+//most entries /audit shows at once, the newest are kept
+const auditLimit = 50
+
+//function that returns the log entries done by or to user, of the given action, at or after since, empty filters match everything (server goroutine only)
+func (s *ServerState) queryAudit(user string, action string, since time.Time) []Log {
+	var matches []Log
+	for _, l := range s.logger {
+		if user != "" && l.Actor != user && l.Target != user {
+			continue
+		}
+		if action != "" && l.Action != action {
+			continue
+		}
+		if !since.IsZero() && l.Timestamp.Before(since) {
+			continue
+		}
+		matches = append(matches, l)
+	}
+	return matches
+}
+
+//function that reads when an /audit query starts from, either a time ago ("24h", "7d"), a date or a time,
+//"-" is not an open start as it is for /export, leaving since out already means from the start
+func parseAuditSince(value string, now time.Time) (time.Time, error) {
+	if age, err := parseRetentionAge(value); err == nil && age > 0 {
+		return now.Add(-age), nil
+	}
+	if value == "-" {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	return parseExportTime(value, false)
+}
+
+//function that reads the filters of an /audit query in any order: actions and times are recognised, anything else is a user,
+//each filter can be given once
+func parseAuditFilters(args []string, now time.Time) (user string, action string, since time.Time, err error) {
+	for _, arg := range args {
+		repeated := false
+		if slices.Contains(auditActions, arg) {
+			repeated = action != ""
+			action = arg
+		} else if t, sinceErr := parseAuditSince(arg, now); sinceErr == nil {
+			repeated = !since.IsZero()
+			since = t
+		} else {
+			repeated = user != ""
+			user = arg
+		}
+		if repeated {
+			return "", "", time.Time{}, fmt.Errorf("%s repeats a filter, give at most one user, action and since", arg)
+		}
+	}
+	return user, action, since, nil
 }
 
 //helper function that formats the log before it is saved
@@ -35,15 +138,15 @@ func (s *ServerState) formatLog() []string {
 func broadcastStaffLobby(sender string, log Log) {
 	s := GetServerState()
 	for name, user := range s.users {
-		//if user is not an admin or we are looking at the sender, do not send update
-		if !user.Active || user.Role < RoleAdmin || name == sender {
+		//if user is not staff or we are looking at the sender, do not send update
+		if !user.Active || !user.isStaff() || name == sender {
 			continue
 		} else { //at least an admin and not self
 			if user.CurrentRoom == "" {
 				var temp string
 				time := log.Timestamp.Format("2006-01-02 15:04:05")
 				temp = time + "\t\t" + log.Event
-				user.RecvServer <- &UpdateLobby{&shared.UpdateLobby{Update: temp}}
+				user.send(&UpdateLobby{&shared.UpdateLobby{Update: temp}})
 			}
 		}
 
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAuditFilters(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		args []string
+		user, action string
+		since time.Time
+		ok bool
+	}{
+		{nil, "", "", time.Time{}, true},
+		{[]string{"bob"}, "bob", "", time.Time{}, true},
+		{[]string{"kick", "bob", "24h"}, "bob", ActionKick, now.Add(-24 * time.Hour), true},
+		{[]string{"7d", "ban"}, "", ActionBan, now.Add(-7 * 24 * time.Hour), true},
+		{[]string{"2024-05-01", "bob"}, "bob", "", day, true},
+		//"-" is not an open start, it is read as a user
+		{[]string{"-"}, "-", "", time.Time{}, true},
+		{[]string{"kick", "ban"}, "", "", time.Time{}, false},
+		{[]string{"24h", "7d"}, "", "", time.Time{}, false},
+		{[]string{"bob", "alice"}, "", "", time.Time{}, false},
+		{[]string{"bob", "-"}, "", "", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		user, action, since, err := parseAuditFilters(tt.args, now)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseAuditFilters(%q) error %v, want ok %v", tt.args, err, tt.ok)
+			continue
+		}
+		if tt.ok && (user != tt.user || action != tt.action || !since.Equal(tt.since)) {
+			t.Errorf("parseAuditFilters(%q) = %q %q %v, want %q %q %v", tt.args, user, action, since, tt.user, tt.action, tt.since)
+		}
+	}
+}
+
+func TestParseAuditSince(t *testing.T) {
+	now := time.Now()
+	for _, value := range []string{"-", "off", "0", "yesterday", "kick"} {
+		if _, err := parseAuditSince(value, now); err == nil {
+			t.Errorf("parseAuditSince(%q) accepted", value)
+		}
+	}
+	for _, value := range []string{"90m", "2d", "2024-05-01", "2024-05-01T09:00:00Z"} {
+		if _, err := parseAuditSince(value, now); err != nil {
+			t.Errorf("parseAuditSince(%q): %v", value, err)
+		}
+	}
+}
+
+func TestAfterWords(t *testing.T) {
+	tests := []struct {
+		content string
+		n int
+		want string
+	}{
+		{"/kick bob too loud", 2, "too loud"},
+		{"/kick   bob \t  too   loud  ", 2, "too   loud"},
+		{"  /kick bob", 2, ""},
+		{"/kick bob ", 2, ""},
+		{"/kick", 2, ""},
+		{"/kick bob reason", 0, "/kick bob reason"},
+	}
+	for _, tt := range tests {
+		if got := afterWords(tt.content, tt.n); got != tt.want {
+			t.Errorf("afterWords(%q, %d) = %q, want %q", tt.content, tt.n, got, tt.want)
+		}
+	}
+}
@@ -17,6 +17,13 @@ func StartServer() {
 	defer listener.Close()
 	fmt.Println("Server listening on :5461")
 
+	//start the optional IRC gateway
+	if config.IRCAddr != "" {
+		go startIRCGateway(config.IRCAddr)
+	}
+	//periodically log how far behind clients are
+	go reportOutboundStats(s.term)
+
 	// goroutine to handle shutdown signal
 	go func() {
 		<-s.term
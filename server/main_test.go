@@ -0,0 +1,71 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"multi-room_chat_system/shared"
+)
+
+//function that replaces the server singleton with an empty state, so tests never load or save anything on disk
+func newTestServer(t *testing.T) *ServerState {
+	t.Helper()
+	once.Do(func() { shared.Init() })
+	instance = &ServerState{
+		users: map[string]*Member{},
+		rooms: map[string]*Room{},
+		sessions: map[string]*session{},
+		incoming: map[string]incomingRef{},
+		apiTokens: map[string]string{},
+		roles: map[string]*customRole{},
+		userRoles: map[string]string{},
+		presence: map[string]presence{},
+		confirmations: map[string]pendingConfirm{},
+		recvExport: make(chan ExportResult),
+		exporting: map[string]bool{},
+		term: make(chan struct{}),
+		logger: make([]Log, 0),
+	}
+	saved := config
+	config = defaultConfig()
+	t.Cleanup(func() { config = saved })
+	return instance
+}
+
+//function that adds a user with the given role to the test server
+func addTestUser(s *ServerState, name string, role Role) *Member {
+	user := UserFactory(name, role)
+	s.users[name] = user
+	return user
+}
+
+//function that runs content as a command from user the way the server goroutine does, and returns the executed command
+func runTestCmd(s *ServerState, user string, content string) shared.ExecutableMessage {
+	return runTestCmdAt(s, user, content, time.Now())
+}
+
+//function that runs content as a command from user sent at the given time
+func runTestCmdAt(s *ServerState, user string, content string, at time.Time) shared.ExecutableMessage {
+	cmd := CommandFactory(shared.MsgMetadata{UserName: user, Content: content, Timestamp: at}, s)
+	cmd.ExecuteServer()
+	return cmd
+}
+
+//function that adds a room to the test server, stopped when the test ends
+func addTestRoom(t *testing.T, s *ServerState, name string, permission Role) *Room {
+	rm := newRoom(name, permission)
+	s.rooms[name] = rm
+	t.Cleanup(rm.stop)
+	return rm
+}
+
+//function that logs the user in to the test server and puts them in room, "" leaves them in the lobby
+func loginTestUser(s *ServerState, user *Member, room string) {
+	user.Active = true
+	user.AvailableRooms = getRooms(user.Username, user.Role)
+	if room != "" {
+		s.rooms[room].enter(user, time.Now())
+		user.CurrentRoom = room
+	}
+	user.out.drain()
+}
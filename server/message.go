@@ -1,10 +1,13 @@
 package server
 
 import (
+	"fmt"
 	"log"
 	"multi-room_chat_system/shared"
-	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
 
 	//"runtime/trace"
 	"strings"
@@ -22,6 +25,8 @@ func MessageFactory(input shared.MsgMetadata, s *ServerState) shared.ExecutableM
 		return CommandFactory(input, s)
 	} else if (strings.HasPrefix(input.Content, "img:")){ //look for image prefix 
 		return ImageFactory(input, s)
+	} else if (strings.HasPrefix(input.Content, attachmentPrefix)){ //look for attachment prefix
+		return AttachmentFactory(input, s)
 	} else {
 		//otherwise return message
 	return &Message{Message: &shared.Message{MsgMetadata: input, Image: false, URL: false}}
@@ -41,23 +46,21 @@ func ImageFactory(input shared.MsgMetadata, s *ServerState) shared.ExecutableMes
 	//default url and image to false before examining link -> allows us to handle non-image links
 	msg.URL = false
 	msg.Image = false
-    //if the client already uploaded or provided a URL, just mark it
+	//if the client already uploaded or provided a URL, just mark it
 	if isURL(content) {
 		log.Println("is a url")
 		msg.URL = true
-		if isImageURL(content) {
-			log.Println("is an image url")
+		//images uploaded to this server are known to be images, any other link is inspected after it is posted
+		if uuid, ext, ok := uploadedImage(content); ok {
+			log.Println("is an uploaded image")
 			msg.Image = true
-			//extract UUID + extension
-			uuid, ext, err := ExtractUUIDFromLink(msg.Content)
-			if err != nil {
-				panic(err)
-			}
-
 			//rebuild usable link for Content
 			baseURL := "http://localhost:8080"
-			usableLink := MakeImageLink(baseURL, uuid, ext)
-			msg.Content = usableLink
+			msg.Content = MakeImageLink(baseURL, uuid, ext)
+			msg.Thumbnails = imageThumbnails(uuid, ext)
+			if !files.owns(fileKey(msg.Content), input.UserName) {
+				msg.Response = shared.ResponseMD{Status: false, ErrMsg: "PERMISSION DENIED: You can only post files you uploaded"}
+			}
 		}
 	}
     return msg
@@ -68,42 +71,17 @@ func CommandFactory (input shared.MsgMetadata, s *ServerState) shared.Executable
 	parts := strings.Fields(input.Content)
 	//set the args part of the metadata
 	input.Args = len(parts)
-	switch parts[0] {
-	case "/join":
-		return &JoinCmd{JoinCmd: &shared.JoinCmd{MsgMetadata: input}}
-	case "/leave":
-		return &LeaveCmd{LeaveCmd: &shared.LeaveCmd{MsgMetadata: input}}
-	case "/listusers":
-		return &ListUsersCmd{ListUsersCmd: &shared.ListUsersCmd{MsgMetadata: input, Reply: shared.LUResp{}}}
-	case "/help":
-		return &HelpCmd{HelpCmd: &shared.HelpCmd{MsgMetadata: input, Invalid: false}}
-	case "/quit":
-		return &QuitCmd{QuitCmd: &shared.QuitCmd{MsgMetadata: input}}
-	case "/kick":
-		return &KickBanCmd{KickBanCmd: &shared.KickBanCmd{MsgMetadata: input, Ban: false, }}
-	case "/ban":
-		return &KickBanCmd{KickBanCmd: &shared.KickBanCmd{MsgMetadata: input, Ban: true, }}
-	case "/unban":
-		return &UnBanCmd{UnBanCmd: &shared.UnBanCmd{MsgMetadata: input}}
-	case "/create":
-		return &CreateCmd{CreateCmd: &shared.CreateCmd{MsgMetadata: input}}
-	case "/delete":
-		return &DeleteCmd{DeleteCmd: &shared.DeleteCmd{MsgMetadata: input}}
-	case "/promote":
-		return &PromoteDemoteCmd{PromoteDemoteCmd: &shared.PromoteDemoteCmd{MsgMetadata: input, Promote: true}}
-	case "/demote":
-		return &PromoteDemoteCmd{PromoteDemoteCmd: &shared.PromoteDemoteCmd{MsgMetadata: input, Promote: false}}
-	case "/broadcast":
-		parts = strings.SplitN(input.Content, " ", 2)
-		input.Args = len(parts)
-		return &BroadcastCmd{BroadcastCmd: &shared.BroadcastCmd{MsgMetadata: input}}
-	case "/shutdown":
-		return &ShutdownCmd{ShutdownCmd: &shared.ShutdownCmd{MsgMetadata: input}}
-	case "/listrooms":
-		return &ListRoomsCmd{ListRoomsCmd: &shared.ListRoomsCmd{MsgMetadata: input}}
-	default:
+	cmd := commands.lookup(parts[0])
+	if cmd == nil {
+		metrics.commandRun("unknown")
 		return &HelpCmd{HelpCmd: &shared.HelpCmd{MsgMetadata: input, Invalid: true}}
 	}
+	metrics.commandRun(cmd.Name)
+	//every command's role is checked here, so they only check what is particular to them
+	if user, exists := s.users[input.UserName]; !exists || !user.can(cmd) {
+		return denied(input)
+	}
+	return cmd.Handler(input)
 }
 
 /////////////////////////////// MESSAGE and its execute functions ///////////////////////////////
@@ -113,20 +91,34 @@ type Message struct {
 
 func (m *Message) ExecuteServer() {
 	s := GetServerState()
-	var resp shared.ResponseMD
+	user := s.users[m.UserName]
+	m.postTo(s.rooms[user.CurrentRoom], user)
+}
+
+//function that returns the file server path of the file a message posts, if any
+func (m *Message) file() string {
+	if m.Attachment != nil {
+		return fileKey(m.Attachment.URL)
+	}
+	if m.Image {
+		return fileKey(m.Content)
+	}
+	return ""
+}
+
+//function that hands a chat message to the room's goroutine to be logged and broadcast
+func (m *Message) postTo(rm *Room, user *Member) {
+	//the message was already rejected when it was built (e.g. an unknown attachment)
+	if m.Response.ErrMsg != "" {
+		return
+	}
 	//check that user is in a room
-	if s.users[m.UserName].CurrentRoom == "" {
-		resp = shared.ResponseMD{Status: false, ErrMsg: "PERMISSION DENIED: User is not currently in a room", CurrentRoom: ""}
-		m.Response = resp
+	if rm == nil || !rm.post(m, user) {
+		m.Response = shared.ResponseMD{Status: false, ErrMsg: "PERMISSION DENIED: User is not currently in a room", CurrentRoom: ""}
 		return
 	}
-	//user in room, log message
-	s.rooms[s.users[m.UserName].CurrentRoom].log = append(s.rooms[s.users[m.UserName].CurrentRoom].log, *m.Message)
-	//broadcast to all other users
-	resp = shared.ResponseMD{Status: true, CurrentRoom: s.users[m.UserName].CurrentRoom}
-	m.Response = resp
-	log.Println("Message room:", resp.CurrentRoom)
-	s.rooms[s.users[m.UserName].CurrentRoom].broadcast(m, "")
+	//links are inspected afterwards so a slow site never holds up the room
+	inspectLater(rm, m.Message)
 }
 
 func (m *Message) ExecuteClient(ui shared.ClientUI) {}
@@ -169,7 +161,7 @@ func (j *JoinCmd) ExecuteServer() {
 	}
 	//check if the user has permission to join this room
 	//banned < member < admin < owner, so if user's role is less than the required permission
-	if s.users[j.UserName].Role < s.rooms[j.Room].permission {
+	if !s.users[j.UserName].allowedIn(s.rooms[j.Room].permission) {
 		j.Reply.CurrentRoom = s.users[j.UserName].CurrentRoom
 		j.Reply.Status = false
 		j.Reply.ErrMsg = "PERMISSION DENIED: User role does not have access to room"
@@ -180,24 +172,21 @@ func (j *JoinCmd) ExecuteServer() {
 	if s.users[j.UserName].CurrentRoom != "" && j.Room != s.users[j.UserName].CurrentRoom {
 		//broadcast user leaving to other members in that room
 		broadcast(j.UserName, "left", j.Timestamp, s.users[j.UserName].CurrentRoom, "")
-		s.logger = append(s.logger, logEvent(j.UserName + " left " + s.users[j.UserName].CurrentRoom, j.Timestamp, j.UserName))
+		s.audit(Log{Event: j.UserName + " left " + s.users[j.UserName].CurrentRoom, Timestamp: j.Timestamp, Actor: j.UserName, Action: ActionLeave, Room: s.users[j.UserName].CurrentRoom})
 		s.rooms[s.users[j.UserName].CurrentRoom].removeUser(s.users[j.UserName])
+		s.sendPresence(s.users[j.UserName].CurrentRoom, "")
 	}
-	//add user to room
-	s.rooms[j.Room].addUser(s.users[j.UserName])
+	//add user to room, broadcast the join to all others currently in the room
+	//and store the room's current state of messages in the response
+	j.Reply.Log = s.rooms[j.Room].enter(s.users[j.UserName], j.Timestamp)
 	j.Reply.Status = true
 	//update user's room
 	s.users[j.UserName].CurrentRoom = j.Room
 	j.Reply.CurrentRoom = j.Room
-
-	//broadcast and log user joining to all others currently in the room
-	broadcast(j.UserName, "joined", j.Timestamp, j.Room, "")
-
-	//store the room's current state of messages in the response
-	j.Reply.Log = s.rooms[j.Room].log
+	s.sendPresence(j.Room, "")
 
 	//log that the user joined the room
-	s.logger = append(s.logger, logEvent(j.UserName + " joined " + j.Room, j.Timestamp, j.UserName))
+	s.audit(Log{Event: j.UserName + " joined " + j.Room, Timestamp: j.Timestamp, Actor: j.UserName, Action: ActionJoin, Room: j.Room})
 
 }
 func (j *JoinCmd) ExecuteClient(ui shared.ClientUI) {}
@@ -224,8 +213,8 @@ func (l *LeaveCmd) ExecuteServer() {
 		l.Reply.ErrMsg = "PERMISSION DENIED: User not in room"
 		return
 	}
-	//if user is an admin or owner send them the log to be displayed
-	if s.users[l.UserName].Role > RoleMember {
+	//if user is staff send them the log to be displayed
+	if s.users[l.UserName].isStaff() {
 		l.Staff = true
 		l.Log = s.formatLog()
 	}
@@ -238,7 +227,7 @@ func (l *LeaveCmd) ExecuteServer() {
 	//update user state
 	l.Reply.Status = true
 	//log that the user left the room
-	s.logger = append(s.logger, logEvent(l.UserName + " left " + l.Room, l.Timestamp, l.UserName))
+	s.audit(Log{Event: l.UserName + " left " + l.Room, Timestamp: l.Timestamp, Actor: l.UserName, Action: ActionLeave, Room: l.Room})
 }
 
 func (l *LeaveCmd) ExecuteClient(ui shared.ClientUI)() {}
@@ -265,9 +254,10 @@ func (lu *ListUsersCmd) ExecuteServer() {
 		lu.Reply.ErrMsg = "PERMISSION DENIED: User not in room"
 		return
 	}
-	//get the list of users from a room
-	log.Println("users in room:", s.rooms[s.users[lu.UserName].CurrentRoom])
-	lu.Reply.Users = mapToSlice(s.rooms[s.users[lu.UserName].CurrentRoom].users)
+	//get the list of users from a room, with whether each of them is around
+	lu.Reply.Users = s.rooms[s.users[lu.UserName].CurrentRoom].members()
+	slices.Sort(lu.Reply.Users)
+	lu.Reply.Presence = s.presenceList(lu.Reply.Users)
 	log.Println("log of users:", lu.Reply.Users)
 	lu.Reply.Status = true
 	lu.Reply.Room = s.users[lu.UserName].CurrentRoom
@@ -290,7 +280,7 @@ func (h *HelpCmd) ExecuteServer() {
 	}
 	//get the usage for this user's role
 	h.Reply.Status = true
-	h.Reply.Usage = getUsage(s.users[h.UserName].Role)
+	h.Reply.Usage = commands.usage(s.users[h.UserName])
 }
 func (h *HelpCmd) ExecuteClient(ui shared.ClientUI)() {}
 /////////////////////////////////////////////////////////////////////////////////////////////////
@@ -313,14 +303,15 @@ func (q *QuitCmd) ExecuteServer() {
 		room := s.users[q.UserName].CurrentRoom
 		remove(q.UserName, room)
 		broadcast(q.UserName, "left", q.Timestamp, room, "")
-		s.logger = append(s.logger, logEvent(q.UserName + " left " + room, q.Timestamp, q.UserName))
+		s.audit(Log{Event: q.UserName + " left " + room, Timestamp: q.Timestamp, Actor: q.UserName, Action: ActionLeave, Room: room})
 	}
-	//set user status to false
+	//set user status to false, quitting ends the session for good
 	s.users[q.UserName].Active = false
+	s.endSession(s.users[q.UserName])
 	//close this connectionHandler once response is sent
 	safeClose(s.users[q.UserName].Term)
 	//log the user has left the server
-	s.logger = append(s.logger, logEvent(q.UserName + " left the server", q.Timestamp, q.UserName))
+	s.audit(Log{Event: q.UserName + " left the server", Timestamp: q.Timestamp, Actor: q.UserName, Action: ActionLogout})
 }
 func (q *QuitCmd) ExecuteClient(ui shared.ClientUI)() {}
 /////////////////////////////////////////////////////////////////////////////////////////////////
@@ -333,7 +324,7 @@ func (kb *KickBanCmd) ExecuteServer() {
 	s := GetServerState()
 	kb.CurrentRoom = s.users[kb.UserName].CurrentRoom
 	//check that the cmd was entered properly
-	if kb.Args != 2 {
+	if kb.Args < 2 {
 		kb.Status = false
 		kb.ErrMsg = "PERMISSION DENIED: Incorrect usage, enter /help for more information"
 		return
@@ -341,69 +332,73 @@ func (kb *KickBanCmd) ExecuteServer() {
 	//set user after verifying it exists
 	parts := strings.Fields(kb.Content)
 	kb.User = parts[1]
+	//anything after the user is the reason
+	kb.Reason = afterWords(kb.Content, 2)
 	if kb.User == kb.UserName {
 		kb.Status = false
 		kb.ErrMsg = "PERMISSION DENIED: Cannot kick/ban self"
 		return
 	}
-	//first check user's role to see if they can execute
-	if (s.users[kb.UserName].Role < RoleAdmin) {
+	//check to see if the specified user exists
+	if _, exists := s.users[kb.User]; !exists {
 		kb.Status = false
-		kb.ErrMsg = "PERMISSION DENIED: You do not have permission to execute this command"
+		kb.ErrMsg = "PERMISSION DENIED: User: " + kb.User + " does not exist on this server"
 		return
-	} else { //is an admin or owner
-		//check to see if the specified user exists
-		if _, exists := s.users[kb.User]; !exists {
+	} else { //user exists
+		//only an owner can remove an owner
+		if s.users[kb.User].Role == RoleOwner && s.users[kb.UserName].Role != RoleOwner {
 			kb.Status = false
-			kb.ErrMsg = "PERMISSION DENIED: User: " + kb.User + " does not exist on this server"
+			kb.ErrMsg = "PERMISSION DENIED: Only owners can kick/ban an owner"
 			return
-		} else { //user exists
-			//check if kick and user online
-			if !s.users[kb.User].Active && !kb.Ban {
-				kb.Status = false
-				kb.ErrMsg = "PERMISSION DENIED: User:" + kb.User + " is not logged in"
-				return
-			}
-			//otherwise, check if that user is in a room
-			kb.Status = true
-			//check if user is in a room
-			var self *Message
-			if s.users[kb.User].CurrentRoom != "" {
-				room := s.users[kb.User].CurrentRoom
-				remove(kb.User, room)
-				self = broadcast(kb.User, "left", kb.Timestamp, room, kb.UserName)
-			}
-			update := &KickBanCmd{KickBanCmd: &shared.KickBanCmd{Sender: false}}
-			update.Status = true
-			var msg *Message
-			//if ban
-			if kb.Ban {
-				s.users[kb.User].Role = RoleBanned
-				//broadcast ban to staff
-				msg = formatStaffMsg(kb.UserName, "banned user: " + kb.User, kb.Timestamp)
-				update.ErrMsg = "You have been banned!"
-				//log ban
-				s.logger = append(s.logger, logEvent(kb.User + " banned by " + kb.UserName, kb.Timestamp, kb.UserName))
-			} else {
-				msg = formatStaffMsg(kb.UserName, "kicked user: " + kb.User, kb.Timestamp)
-				update.ErrMsg = "You have been kicked!"
-				//log kick
-				s.logger = append(s.logger, logEvent(kb.User + " kicked by " + kb.UserName, kb.Timestamp, kb.UserName))
-			}
-			//if sender is in the same room as the specified user
-			if s.users[kb.UserName].CurrentRoom != "" && s.users[kb.UserName].CurrentRoom ==  s.users[kb.User].CurrentRoom {
-				kb.Msg = *self.Message
-				kb.InRoom = true
-			}
-			broadcastToStaff(msg)
-			if s.users[kb.User].Active {
-				//update user, update its active state, then close its term channel
-				s.users[kb.User].RecvServer <- update
-				s.users[kb.User].Active = false
-				safeClose(s.users[kb.User].Term)
-			}
-			kb.Sender = true
 		}
+		//check if kick and user online
+		if !s.users[kb.User].Active && !kb.Ban {
+			kb.Status = false
+			kb.ErrMsg = "PERMISSION DENIED: User:" + kb.User + " is not logged in"
+			return
+		}
+		//otherwise, check if that user is in a room
+		kb.Status = true
+		//check if user is in a room
+		var self *Message
+		room := s.users[kb.User].CurrentRoom
+		if room != "" {
+			remove(kb.User, room)
+			self = broadcast(kb.User, "left", kb.Timestamp, room, kb.UserName)
+		}
+		update := &KickBanCmd{KickBanCmd: &shared.KickBanCmd{Sender: false}}
+		update.Status = true
+		var msg *Message
+		//if ban
+		if kb.Ban {
+			s.users[kb.User].Role = RoleBanned
+			delete(s.userRoles, kb.User)
+			//broadcast ban to staff
+			msg = formatStaffMsg(kb.UserName, "banned user: " + kb.User + withReason(kb.Reason), kb.Timestamp)
+			update.ErrMsg = "You have been banned!" + withReason(kb.Reason)
+			//log ban
+			s.audit(Log{Event: kb.User + " banned by " + kb.UserName + withReason(kb.Reason), Timestamp: kb.Timestamp, Actor: kb.UserName, Action: ActionBan, Target: kb.User, Room: room, Reason: kb.Reason})
+		} else {
+			msg = formatStaffMsg(kb.UserName, "kicked user: " + kb.User + withReason(kb.Reason), kb.Timestamp)
+			update.ErrMsg = "You have been kicked!" + withReason(kb.Reason)
+			//log kick
+			s.audit(Log{Event: kb.User + " kicked by " + kb.UserName + withReason(kb.Reason), Timestamp: kb.Timestamp, Actor: kb.UserName, Action: ActionKick, Target: kb.User, Room: room, Reason: kb.Reason})
+		}
+		//a kicked/banned user cannot resume their session
+		s.endSession(s.users[kb.User])
+		//if sender is in the room the specified user was just removed from
+		if room != "" && s.users[kb.UserName].CurrentRoom == room {
+			kb.Msg = *self.Message
+			kb.InRoom = true
+		}
+		broadcastToStaff(msg)
+		if s.users[kb.User].Active {
+			//update user, update its active state, then close its term channel
+			s.users[kb.User].send(update)
+			s.users[kb.User].Active = false
+			safeClose(s.users[kb.User].Term)
+		}
+		kb.Sender = true
 	}
 }
 func (kb *KickBanCmd) ExecuteClient(ui shared.ClientUI)() {}
@@ -431,27 +426,27 @@ func (u *UnBanCmd) ExecuteServer() {
 		u.ErrMsg = "PERMISSION DENIED: Incorrect usage, cannot unban self"
 		return
 	}
-	//first check user's role to see if they can execute
-	if (s.users[u.UserName].Role < RoleAdmin) {
+	//check to see if the specified user exists
+	if _, exists := s.users[u.User]; !exists {
 		u.Status = false
-		u.ErrMsg = "PERMISSION DENIED: You do not have permission to execute this command"
+		u.ErrMsg = "PERMISSION DENIED: User: " + u.User + " does not exist on this server"
 		return
-	} else { //is an admin or owner
-		//check to see if the specified user exists
-		if _, exists := s.users[u.User]; !exists {
-			u.Status = false
-			u.ErrMsg = "PERMISSION DENIED: User: " + u.User + " does not exist on this server"
-			return
-		} else { //user exists in the banned state
-			//update the user's role
-			s.users[u.User].Role = RoleMember
-			//broadcast to all staff
-			msg := formatStaffMsg(u.UserName, "unbanned user: " + u.User, u.Timestamp)
-			broadcastToStaff(msg)
-			u.ErrMsg = "[SERVER] " + u.User + " successfully unbanned"
-			//log unban
-			s.logger = append(s.logger, logEvent(u.User + " unbanned by " + u.UserName, u.Timestamp, u.UserName))
-		}
+	}
+	//only a banned user can be unbanned, anything else would bypass promote/demote
+	if s.users[u.User].Role != RoleBanned {
+		u.Status = false
+		u.ErrMsg = "PERMISSION DENIED: User: " + u.User + " is not banned"
+		return
+	} else { //user exists in the banned state
+		//update the user's role
+		s.users[u.User].Role = RoleMember
+		//broadcast to all staff
+		msg := formatStaffMsg(u.UserName, "unbanned user: " + u.User, u.Timestamp)
+		broadcastToStaff(msg)
+		u.Status = true
+		u.ErrMsg = "[SERVER] " + u.User + " successfully unbanned"
+		//log unban
+		s.audit(Log{Event: u.User + " unbanned by " + u.UserName, Timestamp: u.Timestamp, Actor: u.UserName, Action: ActionUnban, Target: u.User})
 	}
 }
 func (u *UnBanCmd) ExecuteClient(ui shared.ClientUI)() {}
@@ -475,12 +470,6 @@ func (c *CreateCmd) ExecuteServer() {
 	c.Room = parts[1]
 	c.Role = int(convToRole(parts[2]))
 
-	//first check user's role to see if they can execute
-	if (s.users[c.UserName].Role < RoleAdmin) {
-		c.Status = false
-		c.ErrMsg = "PERMISSION DENIED: You do not have permission to execute this command"
-		return
-	}
 	//check to see if the room already exists
 	if _, exists := s.rooms[c.Room]; exists {
 		c.Status = false
@@ -501,26 +490,22 @@ func (c *CreateCmd) ExecuteServer() {
 	}
 
 	//initialize the room's state
-	newRoom := Room{
-		users: make(map[string]*Member),
-		log: make([]shared.Message, 0),
-		permission: Role(c.Role),
-	}
+	room := newRoom(c.Room, Role(c.Role))
 	//add new room to the server's state
-	s.rooms[c.Room] = &newRoom
+	s.rooms[c.Room] = room
 	//create live update object
 	rmUpdate := &RoomUpdate{RoomUpdate: &shared.RoomUpdate{Create: true, Room: c.Room}}
 	//update user states
 	for name, user := range s.users {
 		//only update if they are at least the correct role
-		if user.Role >= newRoom.permission {
+		if user.allowedIn(room.permission) {
 			user.AvailableRooms = append(user.AvailableRooms, c.Room)
 			//if user is not active or self, do not broadcast live update
 			if !user.Active || name == c.UserName {
 				continue
 			}
 			//send update to user
-			user.RecvServer <- rmUpdate
+			user.send(rmUpdate)
 			
 		}
 	}
@@ -528,7 +513,7 @@ func (c *CreateCmd) ExecuteServer() {
 	c.Status = true
 	c.ErrMsg = "SERVER: room " + c.Room + " was successfully created"
 	//log room creation
-	s.logger = append(s.logger, logEvent(c.Room + " created by " + c.UserName, c.Timestamp, c.UserName))
+	s.audit(Log{Event: c.Room + " created by " + c.UserName, Timestamp: c.Timestamp, Actor: c.UserName, Action: ActionCreate, Room: c.Room})
 }
 func (c *CreateCmd) ExecuteClient(ui shared.ClientUI)() {}
 /////////////////////////////////////////////////////////////////////////////////////////////////
@@ -550,12 +535,6 @@ func (d *DeleteCmd) ExecuteServer() {
 	//set room and role after verifying they exist
 	parts := strings.Fields(d.Content)
 	d.Room = parts[1]
-	//first check user's role to see if they can execute
-	if (s.users[d.UserName].Role < RoleAdmin) {
-		d.Status = false
-		d.ErrMsg = "PERMISSION DENIED: You do not have permission to execute this command"
-		return
-	}
 	//check to see if the specified room exists
 	if _, exists := s.rooms[d.Room]; !exists {
 		d.Status = false
@@ -584,26 +563,30 @@ func (d *DeleteCmd) ExecuteServer() {
 			if name == d.UserName { //skip if self
 				continue
 			}
-			//if the user is an admin or owner send them the log
-			if user.Role > RoleMember {
+			//if the user is staff send them the log
+			if user.isStaff() {
 				force.Staff = true
 				force.Log = s.formatLog()
 			}
 			//notify they are no longer in that room
-			user.RecvServer <- force
+			user.send(force)
 			//update user GUI
-			user.RecvServer <- rmUpdate
+			user.send(rmUpdate)
 			continue
 		} 
 		if !user.Active || name == d.UserName { //skip if self
 			continue
 		}
 		//update user GUI
-		user.RecvServer <- rmUpdate
+		user.send(rmUpdate)
 	}
 
-	//remove room from server state
+	//remove room from server state and stop its goroutine
+	s.forgetIncoming(s.rooms[d.Room])
+	s.rooms[d.Room].stop()
 	delete(s.rooms, d.Room)
+	files.forgetRoom(d.Room)
+	metrics.roomDeleted(d.Room)
 
 	//notify all staff
 	broadcastToStaff(formatStaffMsg(d.UserName, "deleted room " + d.Room, d.Timestamp))
@@ -612,7 +595,7 @@ func (d *DeleteCmd) ExecuteServer() {
 	d.Status = true
 	log.Println("server side room after delete:", s.users[d.UserName].CurrentRoom)
 	//log room deletion
-	s.logger = append(s.logger, logEvent(d.Room + " deleted by " + d.UserName, d.Timestamp, d.UserName))
+	s.audit(Log{Event: d.Room + " deleted by " + d.UserName, Timestamp: d.Timestamp, Actor: d.UserName, Action: ActionDelete, Room: d.Room})
 }
 func (d *DeleteCmd) ExecuteClient(ui shared.ClientUI)() {}
 /////////////////////////////////////////////////////////////////////////////////////////////////
@@ -626,75 +609,118 @@ func (p *PromoteDemoteCmd) ExecuteServer() {
 	s := GetServerState()
 	p.CurrentRoom = s.users[p.UserName].CurrentRoom
 	//verify correct usage
-	if p.Args != 2 {
+	parts := strings.Fields(p.Content)
+	confirmed := p.Args == 3 && parts[2] == confirmWord
+	if p.Args != 2 && !confirmed {
 		p.Status = false
 		p.ErrMsg = "PERMISSION DENIED: Incorrect usage, enter /help for more information"
 		return
 	}
-	parts := strings.Fields(p.Content)
 	p.User = parts[1]
-	//verify user can execute this command
-	if s.users[p.UserName].Role < RoleOwner {
-		p.Status = false
-		p.ErrMsg = "PERMISSION DENIED: You do not have permission to execute this command"
-		return
-	}
 	//verify specified user exists
-	if _, exists := s.users[p.User]; !exists {
+	target, exists := s.users[p.User]
+	if !exists {
 		p.Status = false
 		p.ErrMsg = "PERMISSION DENIED: User " + p.User + " does not exist" 
 		return	
 	}
-	//check specified user's current role
-	if s.users[p.User].Role == RoleAdmin && p.Promote {
+	if target.Role == RoleBanned {
 		p.Status = false
-		p.ErrMsg = "PERMISSION DENIED: User " + p.User + " is already an admin" 
-		return	
-	} else {
-		if s.users[p.User].Role == RoleMember && !p.Promote {
+		p.ErrMsg = "PERMISSION DENIED: User " + p.User + " is banned, unban them first"
+		return
+	}
+	if p.User == p.UserName && p.Promote {
+		p.Status = false
+		p.ErrMsg = "PERMISSION DENIED: Cannot promote self"
+		return
+	}
+	_, custom := s.userRoles[p.User]
+	//one step up or down the ladder, a member with a custom role is demoted to a plain member
+	role := target.Role
+	if p.Promote {
+		role++
+	} else if !custom {
+		role--
+	}
+	switch {
+	case role > RoleOwner:
+		p.Status = false
+		p.ErrMsg = "PERMISSION DENIED: User " + p.User + " is already an owner"
+		return
+	case role < RoleMember:
+		p.Status = false
+		p.ErrMsg = "PERMISSION DENIED: User " + p.User + " is already a member"
+		return
+	}
+	//ownership changes hands only between owners, and is confirmed first
+	if role == RoleOwner || target.Role == RoleOwner {
+		if msg := s.checkOwnership(p.UserName, p.User, p.Promote); msg != "" {
 			p.Status = false
-			p.ErrMsg = "PERMISSION DENIED: User " + p.User + " is already a member" 
-			return	
-		} 
+			p.ErrMsg = msg
+			return
+		}
+		//confirm only counts for the request the sender was just shown
+		command := parts[0] + " " + p.User
+		if !confirmed || !s.confirm(p.UserName, command, p.Timestamp) {
+			s.askConfirm(p.UserName, command, p.Timestamp)
+			p.Status = false
+			p.ErrMsg = "SERVER: " + p.User + " will be " + roleNames[role] + ", enter " + command + " " + confirmWord + " within a minute to go ahead"
+			return
+		}
 	}
-	var action, newrole string
-	var role Role
-	update := &UserUpdate{UserUpdate: &shared.UserUpdate{Rooms: make([]string, 0), Current: s.users[p.User].CurrentRoom}}
+	var action string
 	if p.Promote {
 		action = "promoted"
-		newrole = "to admin"
-		role = RoleAdmin
-		update.Promote = true
 		//log user promotion
-		s.logger = append(s.logger, logEvent(p.User + " promoted by " + p.UserName, p.Timestamp, p.UserName))
-		if s.users[p.User].CurrentRoom == "" {
-			update.Log = s.formatLog()
-		}
+		s.audit(Log{Event: p.User + " promoted to " + roleNames[role] + " by " + p.UserName, Timestamp: p.Timestamp, Actor: p.UserName, Action: ActionPromote, Target: p.User})
 	} else {
 		action = "demoted"
-		newrole = "to member"
-		role = RoleMember
-		update.Promote = false
-		//if member is currently in a staff only room, kick them out
-		if s.users[p.User].CurrentRoom != "" && s.rooms[s.users[p.User].CurrentRoom].permission > RoleMember {
-			force := &LeaveCmd{LeaveCmd: &shared.LeaveCmd{ MsgMetadata: shared.MsgMetadata{ Timestamp: p.Timestamp, UserName: p.User, Flag: true }, Room: s.users[p.User].CurrentRoom, Reply: shared.ResponseMD{Status: true}}}
-			s.users[p.User].RecvServer <- force
-			remove(p.User, s.users[p.User].CurrentRoom)
-		}
 		//log user demotion
-		s.logger = append(s.logger, logEvent(p.User + " demoted by " + p.UserName, p.Timestamp, p.UserName))
+		s.audit(Log{Event: p.User + " demoted to " + roleNames[role] + " by " + p.UserName, Timestamp: p.Timestamp, Actor: p.UserName, Action: ActionDemote, Target: p.User})
 	}
-	//promote member to admin
-	s.users[p.User].updateUserState(role, update)
-
-	//update user's GUI state
-	s.users[p.User].RecvServer <- update
+	s.setRole(p.User, role)
+	p.Role = roleNames[role]
 
 	//notify staff
-	msg := formatStaffMsg(p.UserName, action + " " + p.User + " " + newrole, p.Timestamp)
+	msg := formatStaffMsg(p.UserName, action + " " + p.User + " to " + p.Role, p.Timestamp)
 	broadcastToStaff(msg)
 	p.Status = true
-	p.ErrMsg = "SERVER: User " + p.User + " was successfully " + action + " " + newrole 
+	p.ErrMsg = "SERVER: User " + p.User + " was successfully " + action + " to " + p.Role
+}
+
+//function that returns why the sender cannot make or unmake an owner, "" if they can (server goroutine only)
+func (s *ServerState) checkOwnership(sender string, user string, promote bool) string {
+	if s.users[sender].Role != RoleOwner {
+		return "PERMISSION DENIED: Only owners can make or remove owners"
+	}
+	if promote && s.users[user].Bot {
+		return "PERMISSION DENIED: Bots cannot be owners"
+	}
+	if !promote && s.countOwners() == 1 {
+		return "PERMISSION DENIED: " + user + " is the last owner, make someone else an owner first"
+	}
+	return ""
+}
+
+//function that counts the owners (server goroutine only)
+func (s *ServerState) countOwners() int {
+	n := 0
+	for _, user := range s.users {
+		if user.Role == RoleOwner {
+			n++
+		}
+	}
+	return n
+}
+
+//function that gives a user a built-in role and brings their rooms and GUI up to date (server goroutine only)
+func (s *ServerState) setRole(name string, role Role) {
+	user := s.users[name]
+	wasStaff := user.isStaff()
+	//a built-in role replaces any custom role
+	delete(s.userRoles, name)
+	user.Role = role
+	s.refreshAccess(user, wasStaff)
 }
 func (p *PromoteDemoteCmd) ExecuteClient(ui shared.ClientUI)() {}
 /////////////////////////////////////////////////////////////////////////////////////////////////
@@ -716,12 +742,6 @@ func (b* BroadcastCmd) ExecuteServer() {
 	parts := strings.SplitN(b.Content, " ", 2)
 	//take "/broadcast out of content string"
 	b.Content = parts[1]
-	//verify user can execute this command
-	if s.users[b.UserName].Role < RoleAdmin {
-		b.Status = false
-		b.ErrMsg = "PERMISSION DENIED: You do not have permission to execute this command"
-		return
-	}
 	b.Status = true
 	//user is at least admin and can broadcast -> send to all ACTIVE users
 	for username, user := range s.users {
@@ -733,7 +753,7 @@ func (b* BroadcastCmd) ExecuteServer() {
 		cmd := &BroadcastCmd{BroadcastCmd: &bc}
 		cmd.CurrentRoom = user.CurrentRoom
 		//otherwise, send to the user
-		user.RecvServer <- cmd
+		user.send(cmd)
 	}
 	b.CurrentRoom = s.users[b.UserName].CurrentRoom
 }
@@ -754,12 +774,6 @@ func (sh *ShutdownCmd) ExecuteServer() {
 		sh.ErrMsg = "PERMISSION DENIED: Incorrect usage, enter /help for more information"
 		return
 	}
-	//verify user can execute this command
-	if s.users[sh.UserName].Role < RoleOwner {
-		sh.Status = false
-		sh.ErrMsg = "PERMISSION DENIED: You do not have permission to execute this command"
-		return
-	}
 	sh.Status = true
 	sh.Sender = true
 	sh.ErrMsg = "SERVER: Shutdown was successful"
@@ -771,10 +785,10 @@ func (sh *ShutdownCmd) ExecuteServer() {
 		//send final shutdown to client so their GUI shuts down
 		shutdown := &ShutdownCmd{ShutdownCmd: &shared.ShutdownCmd{Sender: false}}
 		shutdown.Status = true
-		user.RecvServer <- shutdown
+		user.send(shutdown)
 	}
 	//log shutdown
-	s.logger = append(s.logger, logEvent("Server shutdown by owner", sh.Timestamp, sh.UserName))
+	s.audit(Log{Event: "Server shutdown by owner", Timestamp: sh.Timestamp, Actor: sh.UserName, Action: ActionShutdown})
 }
 func (sh *ShutdownCmd) ExecuteClient(ui shared.ClientUI)() {}
 /////////////////////////////////////////////////////////////////////////////////////////////////
@@ -793,7 +807,7 @@ func (lr *ListRoomsCmd) ExecuteServer() {
 		lr.ErrMsg = "PERMISSION DENIED: Incorrect usage, enter /help for more information"
 		return
 	}
-	rooms := getRooms(s.users[lr.UserName].Role)
+	rooms := getRooms(lr.UserName, s.users[lr.UserName].Role)
 	resp := "Available rooms:\n"
 	for i, r := range rooms {
 		if i == len(rooms) - 1 {
@@ -808,6 +822,690 @@ func (lr *ListRoomsCmd) ExecuteServer() {
 func (lr *ListRoomsCmd) ExecuteClient(ui shared.ClientUI)() {}
 /////////////////////////////////////////////////////////////////////////////////////////////////
 
+/////////////////////////// UPLOADTOKEN CMD and its execute functions ///////////////////////////
+type UploadTokenCmd struct {
+	*shared.UploadTokenCmd
+}
+func (ut *UploadTokenCmd) ExecuteServer() {
+	s := GetServerState()
+	ut.CurrentRoom = s.users[ut.UserName].CurrentRoom
+	//check that the cmd was entered properly
+	if ut.Args != 1 {
+		ut.Status = false
+		ut.ErrMsg = "PERMISSION DENIED: Incorrect usage, enter /help for more information"
+		return
+	}
+	ut.Token, ut.Expires = files.issueToken(ut.UserName, ut.Timestamp)
+	ut.Status = true
+}
+func (ut *UploadTokenCmd) ExecuteClient(ui shared.ClientUI)() {}
+/////////////////////////////////////////////////////////////////////////////////////////////////
+
+//////////////////////////// RETENTION CMD and its execute functions ////////////////////////////
+type RetentionCmd struct {
+	*shared.RetentionCmd
+}
+func (r *RetentionCmd) ExecuteServer() {
+	s := GetServerState()
+	r.CurrentRoom = s.users[r.UserName].CurrentRoom
+	//check that the cmd was entered properly, either just the room or the room and both limits
+	if r.Args != 2 && r.Args != 4 {
+		r.Status = false
+		r.ErrMsg = "PERMISSION DENIED: Incorrect usage, enter /help for more information"
+		return
+	}
+	parts := strings.Fields(r.Content)
+	r.Room = parts[1]
+	rm, exists := s.rooms[r.Room]
+	if !exists {
+		r.Status = false
+		r.ErrMsg = "PERMISSION DENIED: Room does not exist"
+		return
+	}
+	//show the current policy
+	if r.Args == 2 {
+		r.MaxAge, r.MaxCount = rm.retention()
+		r.Status = true
+		r.ErrMsg = formatRetention(r.Room, r.MaxAge, r.MaxCount)
+		return
+	}
+	maxAge, err := parseRetentionAge(parts[2])
+	if err == nil {
+		r.MaxCount, err = parseRetentionCount(parts[3])
+	}
+	if err != nil {
+		r.Status = false
+		r.ErrMsg = "PERMISSION DENIED: " + err.Error() + ", use e.g. 30d, 12h or off and a number of messages or off"
+		return
+	}
+	r.MaxAge = maxAge
+	rm.setRetention(r.MaxAge, r.MaxCount)
+	r.Status = true
+	r.ErrMsg = formatRetention(r.Room, r.MaxAge, r.MaxCount)
+	//log the change, then apply it straight away instead of waiting for the next sweep
+	s.audit(Log{Event: "retention of " + r.Room + " changed by " + r.UserName + " (" + parts[2] + ", " + parts[3] + ")", Timestamp: r.Timestamp, Actor: r.UserName, Action: ActionRetention, Room: r.Room})
+	if s.pruneRoom(r.Room, rm, r.Timestamp) {
+		go files.collect(s.fileRefs(), r.Timestamp)
+	}
+}
+func (r *RetentionCmd) ExecuteClient(ui shared.ClientUI)() {}
+/////////////////////////////////////////////////////////////////////////////////////////////////
+
+///////////////////////////// EXPORT CMD and its execute functions //////////////////////////////
+type ExportCmd struct {
+	*shared.ExportCmd
+}
+func (e *ExportCmd) ExecuteServer() {
+	s := GetServerState()
+	e.CurrentRoom = s.users[e.UserName].CurrentRoom
+	//check that the cmd was entered properly, the room followed by an optional range and format
+	if e.Args < 2 || e.Args > 5 {
+		e.Status = false
+		e.ErrMsg = "PERMISSION DENIED: Incorrect usage, enter /help for more information"
+		return
+	}
+	parts := strings.Fields(e.Content)
+	e.Room = parts[1]
+	args := parts[2:]
+	e.Format = "txt"
+	if len(args) > 0 {
+		if _, ok := exportFormats[args[len(args) - 1]]; ok {
+			e.Format = args[len(args) - 1]
+			args = args[:len(args) - 1]
+		}
+	}
+	if len(args) > 2 {
+		e.Status = false
+		e.ErrMsg = "PERMISSION DENIED: Unknown format, must be txt, json, html or md"
+		return
+	}
+	//only users allowed in the room can read its log
+	rm, exists := s.rooms[e.Room]
+	if !exists || !s.users[e.UserName].allowedIn(rm.permission) {
+		e.Status = false
+		e.ErrMsg = "PERMISSION DENIED: Room does not exist or you do not have access to it"
+		return
+	}
+	var from, to time.Time
+	var err error
+	if len(args) > 0 {
+		from, err = parseExportTime(args[0], false)
+	}
+	if err == nil && len(args) > 1 {
+		to, err = parseExportTime(args[1], true)
+	}
+	if err != nil {
+		e.Status = false
+		e.ErrMsg = "PERMISSION DENIED: " + err.Error() + ", use e.g. 2024-05-01, 2024-05-01T09:00:00Z or -"
+		return
+	}
+	if s.exporting[e.UserName] {
+		e.Status = false
+		e.ErrMsg = "SERVER: A transcript is already being written for you"
+		return
+	}
+	//the transcript is rendered and written off the server goroutine from a copy of the log, the user is sent it when it is done
+	s.exporting[e.UserName] = true
+	s.writeExportLater(e.UserName, e.Room, rm.history(), from, to, e.Format, e.Timestamp)
+	e.Status = true
+	e.ErrMsg = "SERVER: writing transcript of " + e.Room + ", you will be sent it when it is ready"
+}
+func (e *ExportCmd) ExecuteClient(ui shared.ClientUI)() {}
+/////////////////////////////////////////////////////////////////////////////////////////////////
+
+///////////////////////////// BACKUP CMD and its execute functions //////////////////////////////
+type BackupCmd struct {
+	*shared.BackupCmd
+}
+func (b *BackupCmd) ExecuteServer() {
+	s := GetServerState()
+	b.CurrentRoom = s.users[b.UserName].CurrentRoom
+	//verify correct usage
+	if b.Args != 1 {
+		b.Status = false
+		b.ErrMsg = "PERMISSION DENIED: Incorrect usage, enter /help for more information"
+		return
+	}
+	if s.backingUp {
+		b.Status = false
+		b.ErrMsg = "SERVER: A backup is already being written"
+		return
+	}
+	job, err := s.prepareBackup(b.UserName, b.Timestamp)
+	if err != nil {
+		log.Println("Error preparing backup:", err)
+		b.Status = false
+		b.ErrMsg = "SERVER: Could not write the backup"
+		return
+	}
+	//the archive is written off the server goroutine, the admin is told when it is done
+	s.backingUp = true
+	s.writeBackupLater(job)
+	b.Path = job.Name
+	b.Status = true
+	b.ErrMsg = "SERVER: writing backup to " + job.Name + ", you will be told when it is done"
+}
+func (b *BackupCmd) ExecuteClient(ui shared.ClientUI)() {}
+/////////////////////////////////////////////////////////////////////////////////////////////////
+
+////////////////////////////// AUDIT CMD and its execute functions //////////////////////////////
+type AuditCmd struct {
+	*shared.AuditCmd
+}
+func (a *AuditCmd) ExecuteServer() {
+	s := GetServerState()
+	a.CurrentRoom = s.users[a.UserName].CurrentRoom
+	//check that the cmd was entered properly, every filter is optional
+	if a.Args > 4 {
+		a.Status = false
+		a.ErrMsg = "PERMISSION DENIED: Incorrect usage, enter /help for more information"
+		return
+	}
+	var err error
+	a.User, a.Action, a.Since, err = parseAuditFilters(strings.Fields(a.Content)[1:], a.Timestamp)
+	if err != nil {
+		a.Status = false
+		a.ErrMsg = "PERMISSION DENIED: " + err.Error() + ", actions are: " + strings.Join(auditActions, ", ")
+		return
+	}
+	matches := s.queryAudit(a.User, a.Action, a.Since)
+	a.Matches = len(matches)
+	a.Status = true
+	if a.Matches == 0 {
+		a.ErrMsg = "SERVER: No log entries match"
+		return
+	}
+	resp := fmt.Sprintf("Log entries (%d):\n", a.Matches)
+	if a.Matches > auditLimit {
+		resp = fmt.Sprintf("Log entries (last %d of %d):\n", auditLimit, a.Matches)
+		matches = matches[a.Matches - auditLimit:]
+	}
+	for i, l := range matches {
+		resp += "\t" + l.Timestamp.Format("2006-01-02 15:04:05") + "  " + l.Event
+		if i < len(matches) - 1 {
+			resp += "\n"
+		}
+	}
+	a.ErrMsg = resp
+}
+func (a *AuditCmd) ExecuteClient(ui shared.ClientUI)() {}
+/////////////////////////////////////////////////////////////////////////////////////////////////
+
+//////////////////////////// APITOKEN CMD and its execute functions /////////////////////////////
+type APITokenCmd struct {
+	*shared.APITokenCmd
+}
+func (at *APITokenCmd) ExecuteServer() {
+	s := GetServerState()
+	at.CurrentRoom = s.users[at.UserName].CurrentRoom
+	//verify correct usage
+	parts := strings.Fields(at.Content)
+	if at.Args > 2 || (at.Args == 2 && parts[1] != "revoke") {
+		at.Status = false
+		at.ErrMsg = "PERMISSION DENIED: Incorrect usage, enter /help for more information"
+		return
+	}
+	at.Revoke = at.Args == 2
+	if at.Revoke {
+		if _, exists := s.apiTokens[at.UserName]; !exists {
+			at.Status = false
+			at.ErrMsg = "PERMISSION DENIED: You do not have an API token"
+			return
+		}
+		delete(s.apiTokens, at.UserName)
+		at.Status = true
+		at.ErrMsg = "SERVER: your API token was revoked"
+		s.audit(Log{Event: at.UserName + " revoked their API token", Timestamp: at.Timestamp, Actor: at.UserName, Action: ActionToken})
+		return
+	}
+	//only the hash is kept, a new token replaces the old one
+	at.Token = s.issueToken(at.UserName)
+	at.Status = true
+	at.ErrMsg = "SERVER: your API token is " + at.Token + " (it replaces any earlier token and is not shown again)"
+	s.audit(Log{Event: at.UserName + " issued an API token", Timestamp: at.Timestamp, Actor: at.UserName, Action: ActionToken})
+}
+func (at *APITokenCmd) ExecuteClient(ui shared.ClientUI)() {}
+/////////////////////////////////////////////////////////////////////////////////////////////////
+
+/////////////////////////////// BOT CMD and its execute functions ///////////////////////////////
+type BotCmd struct {
+	*shared.BotCmd
+}
+func (b *BotCmd) ExecuteServer() {
+	s := GetServerState()
+	b.CurrentRoom = s.users[b.UserName].CurrentRoom
+	//verify correct usage
+	parts := strings.Fields(b.Content)
+	if b.Args >= 2 {
+		b.Action = parts[1]
+	}
+	if !(b.Args == 2 && b.Action == "list") && !(b.Args == 3 && contains([]string{"create", "token", "delete"}, b.Action)) {
+		b.Status = false
+		b.ErrMsg = "PERMISSION DENIED: Incorrect usage, enter /help for more information"
+		return
+	}
+	if b.Action == "list" {
+		bots := s.listBots()
+		b.Status = true
+		b.ErrMsg = "SERVER: no bot accounts"
+		if len(bots) > 0 {
+			b.ErrMsg = "SERVER: bot accounts: " + strings.Join(bots, ", ")
+		}
+		return
+	}
+	b.Bot = parts[2]
+	user, exists := s.users[b.Bot]
+	if b.Action == "create" {
+		if exists {
+			b.Status = false
+			b.ErrMsg = "PERMISSION DENIED: User " + b.Bot + " already exists"
+			return
+		}
+		if strings.HasPrefix(b.Bot, "#") || strings.HasPrefix(b.Bot, "/") {
+			b.Status = false
+			b.ErrMsg = "PERMISSION DENIED: Bot names cannot begin with '#' or '/'"
+			return
+		}
+		if s.integrationName(b.Bot) {
+			b.Status = false
+			b.ErrMsg = "PERMISSION DENIED: " + b.Bot + " is the name of an incoming webhook, pick another name for the bot"
+			return
+		}
+		b.Token = s.createBot(b.Bot)
+		b.Status = true
+		b.ErrMsg = "SERVER: bot " + b.Bot + " was created, its token is " + b.Token + " (it is not shown again)"
+		s.audit(Log{Event: "bot " + b.Bot + " created by " + b.UserName, Timestamp: b.Timestamp, Actor: b.UserName, Action: ActionBot, Target: b.Bot})
+		return
+	}
+	if !exists || !user.Bot {
+		b.Status = false
+		b.ErrMsg = "PERMISSION DENIED: " + b.Bot + " is not a bot account"
+		return
+	}
+	if b.Action == "token" {
+		b.Token = s.issueToken(b.Bot)
+		b.Status = true
+		b.ErrMsg = "SERVER: bot " + b.Bot + " has a new token " + b.Token + " (the old one no longer works and this one is not shown again)"
+		s.audit(Log{Event: "token of bot " + b.Bot + " replaced by " + b.UserName, Timestamp: b.Timestamp, Actor: b.UserName, Action: ActionBot, Target: b.Bot})
+		return
+	}
+	s.deleteBot(b.Bot, b.Timestamp)
+	b.Status = true
+	b.ErrMsg = "SERVER: bot " + b.Bot + " was deleted"
+	s.audit(Log{Event: "bot " + b.Bot + " deleted by " + b.UserName, Timestamp: b.Timestamp, Actor: b.UserName, Action: ActionBot, Target: b.Bot})
+}
+func (b *BotCmd) ExecuteClient(ui shared.ClientUI)() {}
+/////////////////////////////////////////////////////////////////////////////////////////////////
+
+///////////////////////////// WEBHOOK CMD and its execute functions /////////////////////////////
+type WebhookCmd struct {
+	*shared.WebhookCmd
+}
+func (wh *WebhookCmd) ExecuteServer() {
+	s := GetServerState()
+	wh.CurrentRoom = s.users[wh.UserName].CurrentRoom
+	//verify correct usage
+	parts := strings.Fields(wh.Content)
+	if wh.Args >= 2 {
+		wh.Action = parts[1]
+	}
+	usage := map[string][]int{"add": {4, 5}, "incoming": {4}, "remove": {3}, "log": {3}, "test": {3}, "list": {2, 3}}
+	if !slices.Contains(usage[wh.Action], wh.Args) {
+		wh.Status = false
+		wh.ErrMsg = "PERMISSION DENIED: Incorrect usage, enter /help for more information"
+		return
+	}
+	switch wh.Action {
+	case "add":
+		wh.Room, wh.URL = parts[2], parts[3]
+		rm, exists := s.rooms[wh.Room]
+		if !exists {
+			wh.Status = false
+			wh.ErrMsg = "PERMISSION DENIED: Room " + wh.Room + " does not exist"
+			return
+		}
+		if !isURL(wh.URL) || !(strings.HasPrefix(wh.URL, "http://") || strings.HasPrefix(wh.URL, "https://")) {
+			wh.Status = false
+			wh.ErrMsg = "PERMISSION DENIED: " + wh.URL + " is not an http or https URL"
+			return
+		}
+		var events []string
+		if wh.Args == 5 {
+			var err error
+			if events, err = parseWebhookEvents(parts[4]); err != nil {
+				wh.Status = false
+				wh.ErrMsg = "PERMISSION DENIED: " + err.Error()
+				return
+			}
+		}
+		hook := &webhook{ID: newToken()[:8], URL: wh.URL, Secret: newToken(), Events: events}
+		rm.addWebhook(hook)
+		wh.ID, wh.Secret = hook.ID, hook.Secret
+		wh.Status = true
+		wh.ErrMsg = "SERVER: webhook " + hook.String() + " added to " + wh.Room + ", deliveries are signed with the secret " + hook.Secret + " (it is not shown again)"
+		s.audit(Log{Event: "webhook " + hook.ID + " of " + wh.Room + " added by " + wh.UserName, Timestamp: wh.Timestamp, Actor: wh.UserName, Action: ActionWebhook, Target: hook.URL, Room: wh.Room})
+		return
+	case "incoming":
+		wh.Room = parts[2]
+		name := parts[3]
+		rm, exists := s.rooms[wh.Room]
+		if !exists {
+			wh.Status = false
+			wh.ErrMsg = "PERMISSION DENIED: Room " + wh.Room + " does not exist"
+			return
+		}
+		//an integration cannot pass itself off as a user
+		if _, exists := s.users[name]; exists {
+			wh.Status = false
+			wh.ErrMsg = "PERMISSION DENIED: " + name + " is a user's name, pick another name for the integration"
+			return
+		}
+		token := newToken()
+		hook := &incomingHook{ID: newToken()[:8], Name: name, TokenHash: hashToken(token)}
+		s.addIncoming(rm, hook)
+		wh.ID, wh.URL, wh.Secret = hook.ID, incomingURL(hook.ID, token), token
+		wh.Status = true
+		wh.ErrMsg = "SERVER: incoming webhook " + hook.ID + " added to " + wh.Room + ", POST messages as " + name + " to " + wh.URL + " (it is not shown again)"
+		s.audit(Log{Event: "incoming webhook " + hook.ID + " of " + wh.Room + " added by " + wh.UserName, Timestamp: wh.Timestamp, Actor: wh.UserName, Action: ActionWebhook, Target: name, Room: wh.Room})
+		return
+	case "list":
+		var lines []string
+		for _, name := range sortedKeys(s.rooms) {
+			if wh.Args == 3 && name != parts[2] {
+				continue
+			}
+			for _, hook := range s.rooms[name].hooks() {
+				lines = append(lines, name + " " + hook.String())
+			}
+			for _, hook := range s.rooms[name].incomingHooks() {
+				lines = append(lines, name + " " + hook.String())
+			}
+		}
+		wh.Status = true
+		wh.ErrMsg = "SERVER: no webhooks"
+		if len(lines) > 0 {
+			wh.ErrMsg = "SERVER: webhooks:\n" + strings.Join(lines, "\n")
+		}
+		return
+	}
+	wh.ID = parts[2]
+	if rm, in := s.findIncoming(wh.ID); in != nil {
+		if wh.Action != "remove" {
+			wh.Status = false
+			wh.ErrMsg = "PERMISSION DENIED: Webhook " + wh.ID + " is an incoming webhook, only outgoing webhooks are logged and tested"
+			return
+		}
+		s.removeIncoming(wh.ID)
+		wh.Room = rm.name
+		wh.Status = true
+		wh.ErrMsg = "SERVER: incoming webhook " + wh.ID + " removed from " + wh.Room
+		s.audit(Log{Event: "incoming webhook " + wh.ID + " of " + wh.Room + " removed by " + wh.UserName, Timestamp: wh.Timestamp, Actor: wh.UserName, Action: ActionWebhook, Target: in.Name, Room: wh.Room})
+		return
+	}
+	rm, hook := s.findWebhook(wh.ID)
+	if hook == nil {
+		wh.Status = false
+		wh.ErrMsg = "PERMISSION DENIED: Webhook " + wh.ID + " does not exist"
+		return
+	}
+	wh.Room, wh.URL = rm.name, hook.URL
+	wh.Status = true
+	switch wh.Action {
+	case "remove":
+		rm.removeWebhook(wh.ID)
+		webhookHistory.remove(wh.ID)
+		wh.ErrMsg = "SERVER: webhook " + wh.ID + " removed from " + wh.Room
+		s.audit(Log{Event: "webhook " + wh.ID + " of " + wh.Room + " removed by " + wh.UserName, Timestamp: wh.Timestamp, Actor: wh.UserName, Action: ActionWebhook, Target: hook.URL, Room: wh.Room})
+	case "log":
+		wh.ErrMsg = formatWebhookLog(wh.ID)
+	case "test":
+		rm.do(func() { hook.send(webhookPayload{Event: EventPing, Room: wh.Room, Timestamp: wh.Timestamp}) })
+		wh.ErrMsg = "SERVER: test event sent to webhook " + wh.ID + ", see /webhook log " + wh.ID
+	}
+}
+func (wh *WebhookCmd) ExecuteClient(ui shared.ClientUI)() {}
+/////////////////////////////////////////////////////////////////////////////////////////////////
+
+////////////////////////////// STATUS CMD and its execute functions //////////////////////////////
+type StatusCmd struct {
+	*shared.StatusCmd
+}
+func (st *StatusCmd) ExecuteServer() {
+	s := GetServerState()
+	user := s.users[st.UserName]
+	st.CurrentRoom = user.CurrentRoom
+	args := strings.Fields(st.Content)[1:]
+	var text string
+	var err error
+	if st.Away {
+		text, err = s.away(user, args)
+	} else {
+		text, err = s.status(user, args)
+	}
+	if err != nil {
+		st.Status = false
+		st.ErrMsg = "PERMISSION DENIED: " + err.Error()
+		return
+	}
+	st.Status = true
+	st.ErrMsg = text
+}
+func (st *StatusCmd) ExecuteClient(ui shared.ClientUI)() {}
+/////////////////////////////////////////////////////////////////////////////////////////////////
+
+////////////////////////// TRANSFER OWNER CMD and its execute functions //////////////////////////
+type TransferOwnerCmd struct {
+	*shared.TransferOwnerCmd
+}
+func (t *TransferOwnerCmd) ExecuteServer() {
+	s := GetServerState()
+	t.CurrentRoom = s.users[t.UserName].CurrentRoom
+	//verify correct usage
+	parts := strings.Fields(t.Content)
+	confirmed := t.Args == 3 && parts[2] == confirmWord
+	if t.Args != 2 && !confirmed {
+		t.Status = false
+		t.ErrMsg = "PERMISSION DENIED: Incorrect usage, enter /help for more information"
+		return
+	}
+	t.User = parts[1]
+	target, exists := s.users[t.User]
+	if !exists {
+		t.Status = false
+		t.ErrMsg = "PERMISSION DENIED: User " + t.User + " does not exist"
+		return
+	}
+	switch {
+	case t.User == t.UserName:
+		t.ErrMsg = "PERMISSION DENIED: Cannot transfer ownership to self"
+	case target.Role == RoleBanned:
+		t.ErrMsg = "PERMISSION DENIED: User " + t.User + " is banned, unban them first"
+	case target.Role == RoleOwner:
+		t.ErrMsg = "PERMISSION DENIED: User " + t.User + " is already an owner, use /demote " + t.UserName + " to step down"
+	default:
+		t.ErrMsg = s.checkOwnership(t.UserName, t.User, true)
+	}
+	if t.ErrMsg != "" {
+		t.Status = false
+		return
+	}
+	//confirm only counts for the request the sender was just shown
+	command := "/transferowner " + t.User
+	if !confirmed || !s.confirm(t.UserName, command, t.Timestamp) {
+		s.askConfirm(t.UserName, command, t.Timestamp)
+		t.Status = false
+		t.ErrMsg = "SERVER: " + t.User + " will be an owner and you will be an admin, enter " + command + " " + confirmWord + " within a minute to go ahead"
+		return
+	}
+	//the new owner first, so there is never no owner
+	s.setRole(t.User, RoleOwner)
+	s.setRole(t.UserName, RoleAdmin)
+	event := "transferred ownership to " + t.User
+	broadcastToStaff(formatStaffMsg(t.UserName, event, t.Timestamp))
+	s.audit(Log{Event: t.UserName + " " + event, Timestamp: t.Timestamp, Actor: t.UserName, Action: ActionTransfer, Target: t.User})
+	t.Status = true
+	t.ErrMsg = "SERVER: " + t.User + " is now an owner and you are an admin"
+}
+func (t *TransferOwnerCmd) ExecuteClient(ui shared.ClientUI)() {}
+/////////////////////////////////////////////////////////////////////////////////////////////////
+
+/////////////////////////////// ROLE CMD and its execute functions ///////////////////////////////
+type RoleCmd struct {
+	*shared.RoleCmd
+}
+func (r *RoleCmd) ExecuteServer() {
+	s := GetServerState()
+	r.CurrentRoom = s.users[r.UserName].CurrentRoom
+	//verify correct usage
+	parts := strings.Fields(r.Content)
+	if r.Args >= 2 {
+		r.Action = parts[1]
+	}
+	usage := map[string]int{"create": 4, "edit": 4, "delete": 3, "assign": 4, "list": 2}
+	if count, known := usage[r.Action]; !known || r.Args != count {
+		r.Status = false
+		r.ErrMsg = "PERMISSION DENIED: Incorrect usage, enter /help for more information"
+		return
+	}
+	r.Status = true
+	switch r.Action {
+	case "list":
+		r.ErrMsg = "SERVER: roles:\n" + strings.Join(s.listRoles(), "\n") + "\npermissions: " + strings.Join(commands.permissions(), ",")
+		return
+	case "assign":
+		r.assign(s)
+		return
+	}
+	r.Role = parts[2]
+	if r.Action == "create" {
+		if err := checkRoleName(r.Role); err != nil {
+			r.Status = false
+			r.ErrMsg = "PERMISSION DENIED: " + err.Error()
+			return
+		}
+		if _, exists := s.roles[r.Role]; exists {
+			r.Status = false
+			r.ErrMsg = "PERMISSION DENIED: Role " + r.Role + " already exists"
+			return
+		}
+	} else if _, exists := s.roles[r.Role]; !exists {
+		r.Status = false
+		r.ErrMsg = "PERMISSION DENIED: Role " + r.Role + " does not exist"
+		return
+	}
+	var event string
+	switch r.Action {
+	case "create", "edit":
+		perms, err := parsePermissions(parts[3])
+		if err != nil {
+			r.Status = false
+			r.ErrMsg = "PERMISSION DENIED: " + err.Error()
+			return
+		}
+		r.Permissions = perms
+		if r.Action == "create" {
+			s.roles[r.Role] = &customRole{Name: r.Role, Permissions: perms}
+			event = "created role " + r.Role
+		} else {
+			s.editRole(r.Role, perms)
+			event = "changed role " + r.Role
+		}
+		event += " (" + strings.Join(perms, ",") + ")"
+	case "delete":
+		event = "deleted role " + r.Role
+		if users := s.deleteRole(r.Role); len(users) > 0 {
+			event += ", its users are now members: " + strings.Join(users, ", ")
+		}
+	}
+	r.ErrMsg = "SERVER: " + event
+	broadcastToStaff(formatStaffMsg(r.UserName, event, r.Timestamp))
+	s.audit(Log{Event: r.UserName + " " + event, Timestamp: r.Timestamp, Actor: r.UserName, Action: ActionRole, Target: r.Role})
+}
+
+//function that gives a user a custom role, or takes it away with "member"
+func (r *RoleCmd) assign(s *ServerState) {
+	parts := strings.Fields(r.Content)
+	r.User, r.Role = parts[2], parts[3]
+	user, exists := s.users[r.User]
+	if !exists {
+		r.Status = false
+		r.ErrMsg = "PERMISSION DENIED: User " + r.User + " does not exist"
+		return
+	}
+	if r.User == r.UserName {
+		r.Status = false
+		r.ErrMsg = "PERMISSION DENIED: Cannot change your own role"
+		return
+	}
+	if user.Role == RoleOwner || user.Role == RoleBanned {
+		r.Status = false
+		r.ErrMsg = "PERMISSION DENIED: User " + r.User + " is " + roleNames[user.Role] + " and cannot be given a custom role"
+		return
+	}
+	var event string
+	if r.Role == "member" {
+		if _, has := s.userRoles[r.User]; !has {
+			r.Status = false
+			r.ErrMsg = "PERMISSION DENIED: User " + r.User + " has no custom role, use /demote for admins"
+			return
+		}
+		s.assignRole(r.User, "")
+		event = "made " + r.User + " a member"
+	} else {
+		if _, exists := s.roles[r.Role]; !exists {
+			r.Status = false
+			r.ErrMsg = "PERMISSION DENIED: Role " + r.Role + " does not exist"
+			return
+		}
+		s.assignRole(r.User, r.Role)
+		event = "gave " + r.User + " the role " + r.Role
+	}
+	r.Permissions = s.permissionsOf(r.User, user.Role)
+	//tell the user what changed
+	if user.Active {
+		notice := &RoleCmd{RoleCmd: &shared.RoleCmd{Action: r.Action, Role: r.Role, User: r.User, Permissions: r.Permissions}}
+		notice.Status = true
+		notice.CurrentRoom = user.CurrentRoom
+		notice.ErrMsg = "SERVER: You are now " + s.roleName(r.User, user.Role) + ", enter /help to see the commands you can run"
+		user.send(notice)
+	}
+	r.ErrMsg = "SERVER: " + event
+	broadcastToStaff(formatStaffMsg(r.UserName, event, r.Timestamp))
+	s.audit(Log{Event: r.UserName + " " + event, Timestamp: r.Timestamp, Actor: r.UserName, Action: ActionRole, Target: r.User})
+}
+func (r *RoleCmd) ExecuteClient(ui shared.ClientUI)() {}
+/////////////////////////////////////////////////////////////////////////////////////////////////
+
+////////////////////////// COMMAND REPLY and its execute functions //////////////////////////////
+//reply of a TextCommand, or of any command the user cannot run
+type CommandReply struct {
+	*shared.CommandReply
+	//nil when the user was denied the command
+	run TextHandler
+}
+func (cr *CommandReply) ExecuteServer() {
+	s := GetServerState()
+	user := s.users[cr.UserName]
+	if user != nil {
+		cr.CurrentRoom = user.CurrentRoom
+	}
+	if cr.run == nil || user == nil {
+		cr.Status = false
+		cr.ErrMsg = "PERMISSION DENIED: You do not have permission to execute this command"
+		return
+	}
+	text, err := cr.run(user, strings.Fields(cr.Content)[1:])
+	if err != nil {
+		cr.Status = false
+		cr.ErrMsg = "PERMISSION DENIED: " + err.Error()
+		return
+	}
+	cr.Status = true
+	cr.ErrMsg = text
+}
+func (cr *CommandReply) ExecuteClient(ui shared.ClientUI)() {}
+/////////////////////////////////////////////////////////////////////////////////////////////////
+
 //stubs for updating a room upon creation/deletion -> mainly used by GUI and create/delete cmds
 type RoomUpdate struct {
 	*shared.RoomUpdate
@@ -836,6 +1534,34 @@ type UpdateLobby struct {
 func (u *UpdateLobby) ExecuteServer() {}
 func (u *UpdateLobby) ExecuteClient(ui shared.ClientUI) {}
 
+//stubs for the session token sent to clients when they log in or resume
+type Session struct {
+	*shared.Session
+}
+func (se *Session) ExecuteServer() {}
+func (se *Session) ExecuteClient(ui shared.ClientUI) {}
+
+//stubs for the follow-up sent once a posted message's link has been inspected
+type MessageUpdate struct {
+	*shared.MessageUpdate
+}
+func (mu *MessageUpdate) ExecuteServer() {}
+func (mu *MessageUpdate) ExecuteClient(ui shared.ClientUI) {}
+
+//stubs for the presence of a room's users, sent when it changes
+type PresenceUpdate struct {
+	*shared.PresenceUpdate
+}
+func (pu *PresenceUpdate) ExecuteServer() {}
+func (pu *PresenceUpdate) ExecuteClient(ui shared.ClientUI) {}
+
+//stubs for the heartbeat sent to clients to detect dead connections
+type Ping struct {
+	*shared.Ping
+}
+func (p *Ping) ExecuteServer() {}
+func (p *Ping) ExecuteClient(ui shared.ClientUI) {}
+
 //HELPER FUNCTIONS
 func contains(container []string, value string) bool {
 	for _, v := range container {
@@ -857,23 +1583,9 @@ func mapToSlice[V any](m map[string]V) []string {
 
 func broadcast(username string, action string, timestamp time.Time, room string, sender string) *Message{
 	s := GetServerState()
-	//add user action to the room's log
-	m := shared.Message{
-		MsgMetadata: shared.MsgMetadata{
-			Timestamp: timestamp,
-			UserName:  username,
-			Flag:      true,
-			Content:   " " + action + " " + room,
-		},
-		Response: shared.ResponseMD{Status: true, CurrentRoom: room},
-	}
-	M := &Message{Message: &m}
-	s.rooms[room].log = append(s.rooms[room].log, m)
-
-	//broadcast user action to all other users in the room
+	//add user action to the room's log and broadcast it to all other users in the room
 	log.Println("broadcasting message to room", room)
-	s.rooms[room].broadcast(M, sender)
-	return M
+	return s.rooms[room].announce(username, action, timestamp, sender)
 }
 
 func remove(username string, room string) {
@@ -881,6 +1593,7 @@ func remove(username string, room string) {
 	//remove user from their requested room
 	s.rooms[room].removeUser(s.users[username])
 	s.users[username].CurrentRoom = ""
+	s.sendPresence(room, "")
 }
 
 func formatStaffMsg(username string, action string, timestamp time.Time) *Message{
@@ -902,12 +1615,11 @@ func broadcastToStaff(msg *Message) {
 		if name == msg.UserName {
 			continue
 		}
-		log.Println(user.Role)
-		if user.Role >= RoleAdmin && user.Active{
+		if user.Active && user.isStaff() {
 			m := *msg.Message
 			message := &Message{Message: &m}
 			message.Response.CurrentRoom = user.CurrentRoom
-			user.RecvServer <- message
+			user.send(message)
 		}
 	}
 }
@@ -927,14 +1639,21 @@ func isURL(s string) bool {
     return err == nil && u.Scheme != "" && u.Host != ""
 }
 
-func isImageURL(link string) bool {
-    resp, err := http.Head(link)
-    if err != nil {
-        return false
-    }
-    defer resp.Body.Close()
-    contentType := resp.Header.Get("Content-Type")
-    return strings.HasPrefix(contentType, "image/")
+//function that checks if a link points at an image uploaded to this server, returns its uuid and extension
+func uploadedImage(link string) (string, string, bool) {
+	u, err := url.Parse(link)
+	if err != nil || !strings.HasPrefix(u.Path, "/uploads/") {
+		return "", "", false
+	}
+	uuid, ext, err := ExtractUUIDFromLink(link)
+	if err != nil {
+		return "", "", false
+	}
+	//make sure the file really is one of ours
+	if _, err := os.Stat(filepath.Join("uploads", uuid + "." + ext)); err != nil {
+		return "", "", false
+	}
+	return uuid, ext, true
 }
 
 
@@ -0,0 +1,46 @@
+package server
+
+import "testing"
+
+func TestUnbanOnlyBannedUsers(t *testing.T) {
+	s := newTestServer(t)
+	addTestUser(s, "admin", RoleAdmin)
+	addTestUser(s, "owner", RoleOwner)
+	addTestUser(s, "banned", RoleBanned)
+
+	cmd := runTestCmd(s, "admin", "/unban owner").(*UnBanCmd)
+	if cmd.Status {
+		t.Fatalf("/unban owner succeeded: %q", cmd.ErrMsg)
+	}
+	if s.users["owner"].Role != RoleOwner {
+		t.Fatalf("owner role changed to %v", s.users["owner"].Role)
+	}
+
+	cmd = runTestCmd(s, "admin", "/unban banned").(*UnBanCmd)
+	if !cmd.Status || s.users["banned"].Role != RoleMember {
+		t.Fatalf("/unban banned: status %v, role %v, %q", cmd.Status, s.users["banned"].Role, cmd.ErrMsg)
+	}
+}
+
+func TestKickReportsSenderInRoom(t *testing.T) {
+	s := newTestServer(t)
+	addTestRoom(t, s, "#general", RoleMember)
+	addTestRoom(t, s, "#random", RoleMember)
+	admin := addTestUser(s, "admin", RoleAdmin)
+	loginTestUser(s, admin, "#general")
+
+	for _, tt := range []struct {
+		room string
+		inRoom bool
+	}{{"#general", true}, {"#random", false}} {
+		user := addTestUser(s, "bob", RoleMember)
+		loginTestUser(s, user, tt.room)
+		kb := runTestCmd(s, "admin", "/kick bob").(*KickBanCmd)
+		if !kb.Status || kb.InRoom != tt.inRoom {
+			t.Fatalf("kick from %s: status %v, in room %v, %q", tt.room, kb.Status, kb.InRoom, kb.ErrMsg)
+		}
+		if tt.inRoom && kb.Msg.UserName != "bob" {
+			t.Fatalf("kick from %s did not carry the leave message: %+v", tt.room, kb.Msg)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//upper bounds, in seconds, of the command latency histogram buckets
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+//counters the /metrics endpoint reports, updated from any goroutine
+type serverMetrics struct {
+	mu sync.Mutex
+	//messages posted to each room since the server started
+	messages map[string]int64
+	//commands run, by their type
+	commands map[string]int64
+	//bytes stored by uploads, by "image" or "attachment"
+	uploadBytes map[string]int64
+	//how long commands sent through RecvMessage took, bucket counts match latencyBuckets
+	latencyCounts []int64
+	latencySum float64
+	latencyCount int64
+	started time.Time
+}
+
+//counters the /metrics endpoint reports
+var metrics = &serverMetrics{
+	messages: make(map[string]int64),
+	commands: make(map[string]int64),
+	uploadBytes: make(map[string]int64),
+	latencyCounts: make([]int64, len(latencyBuckets)),
+	started: time.Now(),
+}
+
+//gauges read from the server state when /metrics is scraped
+type stateGauges struct {
+	connectedUsers int
+	//users in each room
+	roomUsers map[string]int
+	//messages kept in each room's log
+	roomLog map[string]int
+}
+
+//function that counts a message posted to a room
+func (sm *serverMetrics) messagePosted(room string) {
+	sm.mu.Lock()
+	sm.messages[room]++
+	sm.mu.Unlock()
+}
+
+//function that counts a command by its name, e.g. "/join", or "unknown" for commands that do not exist
+func (sm *serverMetrics) commandRun(name string) {
+	sm.mu.Lock()
+	sm.commands[name]++
+	sm.mu.Unlock()
+}
+
+//function that forgets a deleted room's counter, a room created later under its name starts from zero
+func (sm *serverMetrics) roomDeleted(room string) {
+	sm.mu.Lock()
+	delete(sm.messages, room)
+	sm.mu.Unlock()
+}
+
+//function that counts the bytes an upload stored
+func (sm *serverMetrics) uploaded(kind string, size int64) {
+	sm.mu.Lock()
+	sm.uploadBytes[kind] += size
+	sm.mu.Unlock()
+}
+
+//function that records how long a command took from being sent to the server goroutine to its reply
+func (sm *serverMetrics) observeLatency(d time.Duration) {
+	seconds := d.Seconds()
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			sm.latencyCounts[i]++
+		}
+	}
+	sm.latencySum += seconds
+	sm.latencyCount++
+}
+
+//function that reads the gauges that live in the server state (server goroutine only)
+func (s *ServerState) gauges() stateGauges {
+	g := stateGauges{roomUsers: make(map[string]int), roomLog: make(map[string]int)}
+	for _, user := range s.users {
+		if user.Active {
+			g.connectedUsers++
+		}
+	}
+	//read from the rooms' counts, so a scrape does not wait on every room's goroutine
+	for name, rm := range s.rooms {
+		g.roomUsers[name] = int(rm.userCount.Load())
+		g.roomLog[name] = int(rm.logCount.Load())
+	}
+	return g
+}
+
+//serve the metrics in the Prometheus text format to staff, authenticated with their REST API token
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	token := bearerToken(r)
+	if token == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Missing API token", http.StatusUnauthorized)
+		return
+	}
+	s := GetServerState()
+	status, body, ok := s.CallAPI(token, func(user *Member, now time.Time) (int, any) {
+		//room names, staff rooms included, are only for staff
+		if !user.isStaff() {
+			return http.StatusForbidden, apiError{"Only staff can read the metrics"}
+		}
+		return http.StatusOK, s.gauges()
+	})
+	if !ok {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	g, isGauges := body.(stateGauges)
+	if !isGauges {
+		if failed, isError := body.(apiError); isError {
+			http.Error(w, failed.Error, status)
+		} else {
+			http.Error(w, http.StatusText(status), status)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.write(w, g)
+}
+
+//function that writes every metric in the Prometheus text format
+func (sm *serverMetrics) write(w io.Writer, g stateGauges) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	metricHeader(w, "chat_uptime_seconds", "gauge", "Seconds since the server started.")
+	fmt.Fprintf(w, "chat_uptime_seconds %g\n", time.Since(sm.started).Seconds())
+	metricHeader(w, "chat_connected_users", "gauge", "Users logged in to the server.")
+	fmt.Fprintf(w, "chat_connected_users %d\n", g.connectedUsers)
+	metricHeader(w, "chat_room_users", "gauge", "Users in each room.")
+	for _, room := range sortedKeys(g.roomUsers) {
+		fmt.Fprintf(w, "chat_room_users{room=%s} %d\n", labelValue(room), g.roomUsers[room])
+	}
+	metricHeader(w, "chat_room_log_messages", "gauge", "Messages kept in each room's log.")
+	for _, room := range sortedKeys(g.roomLog) {
+		fmt.Fprintf(w, "chat_room_log_messages{room=%s} %d\n", labelValue(room), g.roomLog[room])
+	}
+	metricHeader(w, "chat_room_messages_total", "counter", "Messages posted to each room since the server started.")
+	for _, room := range sortedKeys(sm.messages) {
+		fmt.Fprintf(w, "chat_room_messages_total{room=%s} %d\n", labelValue(room), sm.messages[room])
+	}
+	metricHeader(w, "chat_commands_total", "counter", "Commands run, by name.")
+	for _, cmd := range sortedKeys(sm.commands) {
+		fmt.Fprintf(w, "chat_commands_total{command=%s} %d\n", labelValue(cmd), sm.commands[cmd])
+	}
+	metricHeader(w, "chat_upload_bytes_total", "counter", "Bytes stored by uploads, by kind.")
+	for _, kind := range sortedKeys(sm.uploadBytes) {
+		fmt.Fprintf(w, "chat_upload_bytes_total{kind=%s} %d\n", labelValue(kind), sm.uploadBytes[kind])
+	}
+
+	metricHeader(w, "chat_command_latency_seconds", "histogram", "Time from a command reaching RecvMessage to its reply.")
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(w, "chat_command_latency_seconds_bucket{le=\"%g\"} %d\n", bound, sm.latencyCounts[i])
+	}
+	fmt.Fprintf(w, "chat_command_latency_seconds_bucket{le=\"+Inf\"} %d\n", sm.latencyCount)
+	fmt.Fprintf(w, "chat_command_latency_seconds_sum %g\n", sm.latencySum)
+	fmt.Fprintf(w, "chat_command_latency_seconds_count %d\n", sm.latencyCount)
+
+	metricHeader(w, "chat_outbound_queued", "gauge", "Messages waiting to be written, across all connections.")
+	fmt.Fprintf(w, "chat_outbound_queued %d\n", outboundStats.queued.Load())
+	metricHeader(w, "chat_outbound_max_depth", "gauge", "Largest depth any single outbound queue has reached.")
+	fmt.Fprintf(w, "chat_outbound_max_depth %d\n", outboundStats.highWater.Load())
+	metricHeader(w, "chat_outbound_dropped_total", "counter", "Messages dropped because a client's queue was full.")
+	fmt.Fprintf(w, "chat_outbound_dropped_total %d\n", outboundStats.dropped.Load())
+	metricHeader(w, "chat_outbound_disconnects_total", "counter", "Clients disconnected because their queue was full.")
+	fmt.Fprintf(w, "chat_outbound_disconnects_total %d\n", outboundStats.disconnects.Load())
+}
+
+//function that writes the HELP and TYPE lines of a metric
+func metricHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+//function that quotes a label value, escaping what the text format requires
+func labelValue(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + replacer.Replace(value) + `"`
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+//version of the saved state this server writes, bump it and add a migration whenever a Persist type changes
+const stateVersion = 4
+
+//step that upgrades a saved state from the version before to the given version
+type migration struct {
+	to int
+	//what the step changes, logged when it runs
+	about string
+	//works on the decoded JSON, so fields can be renamed or dropped as well as added
+	apply func(state map[string]any) error
+}
+
+//every migration, in order, files without a version are version 0
+var migrations = []migration{
+	{to: 1, about: "number messages saved before they had ids", apply: numberMessages},
+	{to: 2, about: "mark messages that are links", apply: markLinks},
+	{to: 3, about: "fill in who did what in server log entries", apply: structureLog},
+	{to: 4, about: "add custom roles, API tokens, bots, presence and webhooks", apply: addOptionalFields},
+}
+
+//lines the server log used to be written as, with the field each submatch fills in
+var legacyEvents = []struct {
+	pattern *regexp.Regexp
+	action string
+	fields []string
+}{
+	{regexp.MustCompile(`^(\S+) joined the server$`), ActionLogin, []string{"Actor"}},
+	{regexp.MustCompile(`^(\S+) left the server$`), ActionLogout, []string{"Actor"}},
+	{regexp.MustCompile(`^(\S+) joined (#\S+)$`), ActionJoin, []string{"Actor", "Room"}},
+	{regexp.MustCompile(`^(\S+) left (#\S+)$`), ActionLeave, []string{"Actor", "Room"}},
+	{regexp.MustCompile(`^(\S+) kicked by (\S+)$`), ActionKick, []string{"Target", "Actor"}},
+	{regexp.MustCompile(`^(\S+) banned by (\S+)$`), ActionBan, []string{"Target", "Actor"}},
+	{regexp.MustCompile(`^(\S+) unbanned by (\S+)$`), ActionUnban, []string{"Target", "Actor"}},
+	{regexp.MustCompile(`^(\S+) promoted by (\S+)$`), ActionPromote, []string{"Target", "Actor"}},
+	{regexp.MustCompile(`^(\S+) demoted by (\S+)$`), ActionDemote, []string{"Target", "Actor"}},
+	{regexp.MustCompile(`^(#\S+) created by (\S+)$`), ActionCreate, []string{"Room", "Actor"}},
+	{regexp.MustCompile(`^(#\S+) deleted by (\S+)$`), ActionDelete, []string{"Room", "Actor"}},
+	{regexp.MustCompile(`^retention of (#\S+) changed by (\S+) `), ActionRetention, []string{"Room", "Actor"}},
+	{regexp.MustCompile(`^\d+ messages pruned from (#\S+) `), ActionPrune, []string{"Room"}},
+	{regexp.MustCompile(`^Server backed up to (\S+) by (\S+)$`), ActionBackup, []string{"Target", "Actor"}},
+	{regexp.MustCompile(`^Server shutdown by owner$`), ActionShutdown, nil},
+}
+
+//function that decodes a saved state, upgrading it to stateVersion first, returns the version the file was written with
+func decodeState(data []byte) (PersistState, int, error) {
+	var p PersistState
+	var state map[string]any
+	if err := json.Unmarshal(data, &state); err != nil {
+		return p, 0, err
+	}
+	from := 0
+	if v, ok := state["Version"].(float64); ok {
+		from = int(v)
+	}
+	if from > stateVersion {
+		return p, from, fmt.Errorf("saved state is version %d but this server only understands up to version %d", from, stateVersion)
+	}
+	for _, m := range migrations {
+		if m.to <= from {
+			continue
+		}
+		log.Printf("migrating saved state to version %d: %s", m.to, m.about)
+		if err := m.apply(state); err != nil {
+			return p, from, fmt.Errorf("migrating saved state to version %d: %w", m.to, err)
+		}
+		state["Version"] = m.to
+	}
+	if from < stateVersion {
+		var err error
+		if data, err = json.Marshal(state); err != nil {
+			return p, from, err
+		}
+	}
+	err := json.Unmarshal(data, &p)
+	return p, from, err
+}
+
+//function that copies a saved state aside before it is replaced by a newer version, existing backups are kept
+func backupState(path string, version int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	backup := fmt.Sprintf("%s.v%d.bak", path, version)
+	if _, err := os.Stat(backup); err == nil {
+		return nil
+	}
+	log.Println("backing up saved state to", backup)
+	return os.WriteFile(backup, data, 0644)
+}
+
+//function that calls fn for every message in every room of a decoded state
+func eachMessage(state map[string]any, fn func(msg map[string]any)) {
+	rooms, _ := state["Rooms"].(map[string]any)
+	for _, room := range rooms {
+		room, _ := room.(map[string]any)
+		messages, _ := room["Log"].([]any)
+		for _, msg := range messages {
+			if msg, ok := msg.(map[string]any); ok {
+				fn(msg)
+			}
+		}
+	}
+}
+
+//migration to version 1: give messages without an id the next id in their room, as restoring a room does
+func numberMessages(state map[string]any) error {
+	rooms, _ := state["Rooms"].(map[string]any)
+	for _, room := range rooms {
+		room, _ := room.(map[string]any)
+		messages, _ := room["Log"].([]any)
+		var lastID float64
+		for _, msg := range messages {
+			msg, ok := msg.(map[string]any)
+			if !ok {
+				continue
+			}
+			if id, ok := msg["ID"].(float64); ok && id > 0 {
+				lastID = max(lastID, id)
+				continue
+			}
+			lastID++
+			msg["ID"] = lastID
+		}
+	}
+	return nil
+}
+
+//migration to version 2: messages remember whether they were a link, which was not saved before,
+//only http(s) links are marked since nothing else is ever looked up or shown as an image
+func markLinks(state map[string]any) error {
+	eachMessage(state, func(msg map[string]any) {
+		content, _ := msg["Content"].(string)
+		flag, _ := msg["Flag"].(bool)
+		msg["URL"] = !flag && isWebLink(strings.TrimSpace(content))
+	})
+	return nil
+}
+
+//migration to version 3: server log entries were only a line of text, read who did what back out of it
+func structureLog(state map[string]any) error {
+	entries, _ := state["Log"].([]any)
+	for _, entry := range entries {
+		entry, ok := entry.(map[string]any)
+		if !ok {
+			continue
+		}
+		event, _ := entry["Event"].(string)
+		for _, legacy := range legacyEvents {
+			match := legacy.pattern.FindStringSubmatch(event)
+			if match == nil {
+				continue
+			}
+			entry["Action"] = legacy.action
+			for i, field := range legacy.fields {
+				entry[field] = match[i + 1]
+			}
+			break
+		}
+	}
+	return nil
+}
+
+//migration to version 4: custom roles, API tokens, bots, presence and webhooks were added, all of them are left out when unset
+//so older files need no changes, the version only stops servers from before them saving the file without them
+func addOptionalFields(state map[string]any) error {
+	return nil
+}
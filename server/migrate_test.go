@@ -0,0 +1,70 @@
+package server
+
+import "testing"
+
+func TestDecodeStateMigrates(t *testing.T) {
+	data := []byte(`{"Users":{"bob":{"Username":"bob","Role":1}},
+		"Rooms":{"#general":{"Name":"#general","Permission":1,"Log":[{"Username":"bob","Content":"https://example.com"},{"Username":"bob","Content":"hi"}]}},
+		"Log":[{"Event":"bob banned by alice"}]}`)
+	p, from, err := decodeState(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 0 || p.Version != stateVersion {
+		t.Fatalf("decoded from %d to %d, want 0 to %d", from, p.Version, stateVersion)
+	}
+	log := p.Rooms["#general"].Log
+	if log[0].ID != 1 || log[1].ID != 2 || !log[0].URL || log[1].URL {
+		t.Fatalf("messages not numbered and marked: %+v", log)
+	}
+	if entry := p.Log[0]; entry.Action != ActionBan || entry.Actor != "alice" || entry.Target != "bob" {
+		t.Fatalf("log entry not structured: %+v", entry)
+	}
+}
+
+func TestDecodeStateKeepsVersion3Fields(t *testing.T) {
+	data := []byte(`{"Version":3,"Users":{"bob":{"Username":"bob","Role":1,"CustomRole":"mod","APIToken":"abc"}},
+		"Roles":[{"Name":"mod","Permissions":["kick"]}]}`)
+	p, from, err := decodeState(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 3 || p.Version != 4 {
+		t.Fatalf("decoded from %d to %d, want 3 to 4", from, p.Version)
+	}
+	if user := p.Users["bob"]; user.CustomRole != "mod" || user.APIToken != "abc" || len(p.Roles) != 1 {
+		t.Fatalf("fields lost: %+v %+v", user, p.Roles)
+	}
+}
+
+func TestDecodeStateRefusesNewer(t *testing.T) {
+	if _, from, err := decodeState([]byte(`{"Version":99}`)); err == nil || from != 99 {
+		t.Fatalf("version 99 accepted: from %d, err %v", from, err)
+	}
+}
+
+func TestMarkLinks(t *testing.T) {
+	tests := []struct {
+		content string
+		flag bool
+		want bool
+	}{
+		{"https://example.com/page", false, true},
+		{"  http://example.com  ", false, true},
+		{"ftp://example.com/file", false, false},
+		{"javascript:alert(1)", false, false},
+		{"mailto:bob@example.com", false, false},
+		{"see https://example.com", false, false},
+		{"https://", false, false},
+		//announcements such as " joined the room" are never links
+		{"https://example.com", true, false},
+	}
+	for _, tt := range tests {
+		msg := map[string]any{"Content": tt.content, "Flag": tt.flag}
+		state := map[string]any{"Rooms": map[string]any{"#general": map[string]any{"Log": []any{msg}}}}
+		markLinks(state)
+		if msg["URL"] != tt.want {
+			t.Errorf("markLinks(%q, flag %v) marked %v, want %v", tt.content, tt.flag, msg["URL"], tt.want)
+		}
+	}
+}
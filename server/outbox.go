@@ -0,0 +1,226 @@
+package server
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+	"multi-room_chat_system/shared"
+)
+
+//what happens when a connection's outbound queue is full
+const (
+	//drop the oldest queued message to make room for the new one
+	OverflowDropOldest = "drop-oldest"
+	//disconnect the slow client
+	OverflowDisconnect = "disconnect"
+)
+
+//counters for the outbound queues of all connections
+var outboundStats struct {
+	//messages currently waiting to be written, across all connections
+	queued atomic.Int64
+	//largest depth any single queue has reached
+	highWater atomic.Int64
+	//messages dropped because a queue was full
+	dropped atomic.Int64
+	//clients disconnected because their queue was full
+	disconnects atomic.Int64
+}
+
+//how often outbound queue stats are logged, and how long a closing connection gets to flush its queue
+const (
+	outboundStatsInterval = time.Minute
+	outboundFlushTimeout = time.Second
+)
+
+//goroutine that logs the outbound queue stats whenever something is queued or has been dropped
+func reportOutboundStats(term chan struct{}) {
+	ticker := time.NewTicker(outboundStatsInterval)
+	defer ticker.Stop()
+	var lastDropped, lastDisconnects int64
+	for {
+		select {
+		case <-term:
+			return
+		case <-ticker.C:
+			queued, dropped, disconnects := outboundStats.queued.Load(), outboundStats.dropped.Load(), outboundStats.disconnects.Load()
+			if queued == 0 && dropped == lastDropped && disconnects == lastDisconnects {
+				continue
+			}
+			log.Printf("outbound queues: %d queued, %d max depth, %d dropped, %d slow clients disconnected",
+				queued, outboundStats.highWater.Load(), dropped, disconnects)
+			lastDropped, lastDisconnects = dropped, disconnects
+		}
+	}
+}
+
+//message waiting in an outbox, replies to the user's own commands are never dropped
+type queuedMsg struct {
+	msg shared.ExecutableMessage
+	reply bool
+}
+
+//bounded queue of messages waiting to be written to a single connection
+type outbox struct {
+	mu sync.Mutex
+	queue []queuedMsg
+	closed bool
+	//while a command runs, messages queued after this point wait behind its reply
+	holding bool
+	holdFrom int
+	//largest depth this queue has reached
+	highWater int
+	//messages this queue has dropped
+	dropped int64
+	//signalled when messages are queued
+	ready chan struct{}
+	//closed when the connection is shutting down
+	done chan struct{}
+}
+
+//function that creates an empty outbound queue
+func newOutbox() *outbox {
+	return &outbox{
+		ready: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+}
+
+//function that queues a message without blocking, returns false if the client should be disconnected for falling behind
+func (o *outbox) push(msg shared.ExecutableMessage) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return true
+	}
+	if limit := config.OutboundQueueSize; limit > 0 && len(o.queue) >= limit {
+		if config.OutboundOverflow == OverflowDisconnect {
+			return false
+		}
+		//drop the oldest message that is not a reply to make room, or the new one if only replies are queued
+		oldest := slices.IndexFunc(o.queue, func(q queuedMsg) bool { return !q.reply })
+		if oldest >= 0 {
+			o.queue = slices.Delete(o.queue, oldest, oldest + 1)
+			if o.holding && oldest < o.holdFrom {
+				o.holdFrom--
+			}
+			outboundStats.queued.Add(-1)
+		}
+		o.dropped++
+		outboundStats.dropped.Add(1)
+		//only log the first drop so a slow client cannot flood the log
+		if o.dropped == 1 {
+			log.Println("outbound queue full, dropping oldest messages")
+		}
+		if oldest < 0 {
+			return true
+		}
+	}
+	o.enqueue(queuedMsg{msg: msg})
+	return true
+}
+
+//function that holds back messages queued from now on until the reply to the user's command is queued,
+//so the client never sees the effects of its command (e.g. messages in a room it joined) before the reply
+func (o *outbox) hold() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.holding = true
+	o.holdFrom = len(o.queue)
+}
+
+//function that queues a reply to the user's own command ahead of anything held back, replies are never dropped
+func (o *outbox) pushReply(msg shared.ExecutableMessage) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	if o.holding {
+		o.holding = false
+		o.queue = slices.Insert(o.queue, o.holdFrom, queuedMsg{msg: msg, reply: true})
+		outboundStats.queued.Add(1)
+		o.signal()
+		return
+	}
+	o.enqueue(queuedMsg{msg: msg, reply: true})
+}
+
+//function that appends to the queue and wakes the writer, caller holds the lock
+func (o *outbox) enqueue(q queuedMsg) {
+	o.queue = append(o.queue, q)
+	outboundStats.queued.Add(1)
+	if len(o.queue) > o.highWater {
+		o.highWater = len(o.queue)
+		for {
+			high := outboundStats.highWater.Load()
+			if int64(o.highWater) <= high || outboundStats.highWater.CompareAndSwap(high, int64(o.highWater)) {
+				break
+			}
+		}
+	}
+	o.signal()
+}
+
+//function that wakes the writer, caller holds the lock
+func (o *outbox) signal() {
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+}
+
+//function that takes every queued message for the writer
+func (o *outbox) drain() []shared.ExecutableMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	taken := o.queue
+	o.queue = nil
+	//messages held back behind a pending reply stay queued
+	if o.holding {
+		taken, o.queue = taken[:o.holdFrom], append([]queuedMsg(nil), taken[o.holdFrom:]...)
+		o.holdFrom = 0
+	}
+	msgs := make([]shared.ExecutableMessage, len(taken))
+	for i, q := range taken {
+		msgs[i] = q.msg
+	}
+	outboundStats.queued.Add(-int64(len(msgs)))
+	return msgs
+}
+
+//function that stops accepting messages and tells the writer to flush what is left
+func (o *outbox) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	o.closed = true
+	close(o.done)
+}
+
+//function that queues a message for the user's connection without blocking the caller
+func (m *Member) send(msg shared.ExecutableMessage) {
+	//banned users have no connection
+	if m.out == nil {
+		return
+	}
+	if !m.out.push(msg) && !isClosed(m.Term) {
+		log.Println(m.Username, "is not keeping up with its messages, disconnecting")
+		outboundStats.disconnects.Add(1)
+		safeClose(m.Term)
+	}
+}
+
+//function that reports whether a termination channel has been closed
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
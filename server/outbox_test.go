@@ -0,0 +1,101 @@
+package server
+
+import (
+	"slices"
+	"testing"
+
+	"multi-room_chat_system/shared"
+)
+
+//function that returns a chat message with the given content
+func testMsg(content string) shared.ExecutableMessage {
+	return &Message{Message: &shared.Message{MsgMetadata: shared.MsgMetadata{Content: content}}}
+}
+
+//function that returns the contents of the messages the writer would take
+func drained(o *outbox) []string {
+	var contents []string
+	for _, msg := range o.drain() {
+		contents = append(contents, msg.(*Message).Content)
+	}
+	return contents
+}
+
+func TestOutboxOverflow(t *testing.T) {
+	newTestServer(t)
+	config.OutboundQueueSize = 3
+	tests := []struct {
+		name string
+		fill func(o *outbox)
+		want []string
+	}{
+		{"drops oldest", func(o *outbox) {
+			for _, c := range []string{"a", "b", "c", "d"} {
+				o.push(testMsg(c))
+			}
+		}, []string{"b", "c", "d"}},
+		{"keeps replies", func(o *outbox) {
+			o.pushReply(testMsg("r"))
+			for _, c := range []string{"a", "b", "c"} {
+				o.push(testMsg(c))
+			}
+		}, []string{"r", "b", "c"}},
+		{"drops new when only replies are queued", func(o *outbox) {
+			for _, c := range []string{"r1", "r2", "r3"} {
+				o.pushReply(testMsg(c))
+			}
+			o.push(testMsg("a"))
+		}, []string{"r1", "r2", "r3"}},
+		{"reply goes ahead of held messages", func(o *outbox) {
+			o.push(testMsg("x"))
+			o.hold()
+			o.push(testMsg("a"))
+			o.pushReply(testMsg("r"))
+		}, []string{"x", "r", "a"}},
+		{"dropping keeps the hold point", func(o *outbox) {
+			o.push(testMsg("x"))
+			o.push(testMsg("y"))
+			o.hold()
+			o.push(testMsg("a"))
+			o.push(testMsg("b"))
+			o.pushReply(testMsg("r"))
+		}, []string{"y", "r", "a", "b"}},
+	}
+	for _, tt := range tests {
+		o := newOutbox()
+		tt.fill(o)
+		if got := drained(o); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: queue %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOutboxHoldsUntilReply(t *testing.T) {
+	newTestServer(t)
+	o := newOutbox()
+	o.hold()
+	o.push(testMsg("a"))
+	if got := drained(o); len(got) != 0 {
+		t.Fatalf("held message written before the reply: %v", got)
+	}
+	o.pushReply(testMsg("r"))
+	if got := drained(o); !slices.Equal(got, []string{"r", "a"}) {
+		t.Fatalf("queue %v", got)
+	}
+}
+
+func TestOutboxDisconnectsSlowClient(t *testing.T) {
+	s := newTestServer(t)
+	config.OutboundQueueSize = 2
+	config.OutboundOverflow = OverflowDisconnect
+	user := addTestUser(s, "bob", RoleMember)
+	user.send(testMsg("a"))
+	user.send(testMsg("b"))
+	if isClosed(user.Term) {
+		t.Fatal("disconnected before the queue was full")
+	}
+	user.send(testMsg("c"))
+	if !isClosed(user.Term) {
+		t.Fatal("slow client was not disconnected")
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"fmt"
+	"multi-room_chat_system/shared"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+//longest status text a user can set
+const maxStatusText = 100
+
+//presences a user can choose, offline comes from not being logged in
+var presenceStates = []string{shared.PresenceOnline, shared.PresenceAway, shared.PresenceDND}
+
+//presence a user chose with /status or /away, kept while they are logged out
+type presence struct {
+	State string
+	Text string
+}
+
+//presence RPC request (connection handler -> server), sent when a user goes idle and when they are back
+type PresenceRequest struct {
+	User *Member
+	Idle bool
+}
+
+//function that returns a user's presence as others see it, a user who chose online but went idle is away (server goroutine only)
+func (s *ServerState) presenceOf(name string) shared.Presence {
+	p := shared.Presence{User: name, State: shared.PresenceOnline}
+	if chosen, exists := s.presence[name]; exists {
+		p.State, p.Status = chosen.State, chosen.Text
+	}
+	user := s.users[name]
+	if user == nil || !user.Active {
+		p.State = shared.PresenceOffline
+	} else if p.State == shared.PresenceOnline && user.idle {
+		p.State = shared.PresenceAway
+	}
+	return p
+}
+
+//function that returns the presence of each of the named users, in the same order (server goroutine only)
+func (s *ServerState) presenceList(names []string) []shared.Presence {
+	list := make([]shared.Presence, 0, len(names))
+	for _, name := range names {
+		list = append(list, s.presenceOf(name))
+	}
+	return list
+}
+
+//function that sends everyone in a room its users' presence, naming the user whose presence changed to all but that user (server goroutine only)
+func (s *ServerState) sendPresence(room string, changed string) {
+	rm, exists := s.rooms[room]
+	if !exists {
+		return
+	}
+	names := rm.members()
+	slices.Sort(names)
+	users := s.presenceList(names)
+	quiet := &PresenceUpdate{PresenceUpdate: &shared.PresenceUpdate{Room: room, Users: users}}
+	update := quiet
+	if changed != "" {
+		p := s.presenceOf(changed)
+		update = &PresenceUpdate{PresenceUpdate: &shared.PresenceUpdate{Room: room, Users: users, Changed: &p}}
+	}
+	rm.do(func() {
+		for name, member := range rm.users {
+			//the user already got a reply saying what they changed
+			if name == changed {
+				member.send(quiet)
+			} else {
+				member.send(update)
+			}
+		}
+	})
+}
+
+//function that runs fn and tells the user's room if it changed how others see them (server goroutine only)
+func (s *ServerState) changePresence(name string, fn func()) {
+	before := s.presenceOf(name)
+	fn()
+	if s.presenceOf(name) == before {
+		return
+	}
+	if user := s.users[name]; user != nil && user.CurrentRoom != "" {
+		s.sendPresence(user.CurrentRoom, name)
+	}
+}
+
+//function that sets the presence a user chose and their status text (server goroutine only)
+func (s *ServerState) setPresence(name string, state string, text string) {
+	s.changePresence(name, func() {
+		if state == shared.PresenceOnline && text == "" {
+			delete(s.presence, name)
+		} else {
+			s.presence[name] = presence{State: state, Text: text}
+		}
+	})
+}
+
+//function that marks a connected user as idle or back, ignored once the connection has been replaced or closed (server goroutine only)
+func (s *ServerState) setIdle(req PresenceRequest) {
+	user := req.User
+	if s.users[user.Username] != user || !user.Active {
+		return
+	}
+	s.changePresence(user.Username, func() { user.idle = req.Idle })
+}
+
+//function that tells the server a connection's user went idle or is back, dropped once the server is shutting down
+func (s *ServerState) SetIdle(user *Member, idle bool) {
+	select {
+	case s.recvPresence <- PresenceRequest{User: user, Idle: idle}:
+	case <-s.term:
+	}
+}
+
+//function that describes a presence in a reply, e.g. "away: at lunch"
+func describePresence(p shared.Presence) string {
+	if p.Status == "" {
+		return p.State
+	}
+	return p.State + ": " + p.Status
+}
+
+//function that checks the words of a status text, returns the text
+func statusText(words []string) (string, error) {
+	text := strings.Join(words, " ")
+	if utf8.RuneCountInString(text) > maxStatusText {
+		return "", fmt.Errorf("Status text is longer than %d characters", maxStatusText)
+	}
+	return text, nil
+}
+
+//function that runs /status: shows the user's presence, sets it, or shows another user's (server goroutine only)
+func (s *ServerState) status(user *Member, args []string) (string, error) {
+	if len(args) == 0 {
+		return "SERVER: You are " + describePresence(s.presenceOf(user.Username)), nil
+	}
+	if !slices.Contains(presenceStates, args[0]) {
+		if _, exists := s.users[args[0]]; exists && len(args) == 1 {
+			return "SERVER: " + args[0] + " is " + describePresence(s.presenceOf(args[0])), nil
+		}
+		return "", fmt.Errorf("Presence must be one of %s, or the name of a user", strings.Join(presenceStates, ", "))
+	}
+	text, err := statusText(args[1:])
+	if err != nil {
+		return "", err
+	}
+	s.setPresence(user.Username, args[0], text)
+	return "SERVER: You are now " + describePresence(s.presenceOf(user.Username)), nil
+}
+
+//function that runs /away: marks the user away with an optional message, or back online if they already were (server goroutine only)
+func (s *ServerState) away(user *Member, args []string) (string, error) {
+	if len(args) == 0 && s.presence[user.Username].State == shared.PresenceAway {
+		s.setPresence(user.Username, shared.PresenceOnline, "")
+		return "SERVER: You are no longer away", nil
+	}
+	text, err := statusText(args)
+	if err != nil {
+		return "", err
+	}
+	s.setPresence(user.Username, shared.PresenceAway, text)
+	return "SERVER: You are now " + describePresence(s.presenceOf(user.Username)), nil
+}
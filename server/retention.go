@@ -0,0 +1,160 @@
+package server
+
+import (
+	"fmt"
+	"multi-room_chat_system/shared"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//function that parses a retention age such as "30d", "12h" or "off", 0 means messages of any age are kept
+func parseRetentionAge(value string) (time.Duration, error) {
+	if value == "off" || value == "0" {
+		return 0, nil
+	}
+	var age time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid max age %q", value)
+		}
+		age = time.Duration(n) * 24 * time.Hour
+	} else {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid max age %q", value)
+		}
+		age = parsed
+	}
+	if age <= 0 {
+		return 0, fmt.Errorf("invalid max age %q", value)
+	}
+	return age, nil
+}
+
+//function that parses a retention message count such as "1000" or "off", 0 means any number of messages are kept
+func parseRetentionCount(value string) (int, error) {
+	if value == "off" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid max messages %q", value)
+	}
+	return n, nil
+}
+
+//function that describes a room's retention policy to an admin
+func formatRetention(room string, maxAge time.Duration, maxCount int) string {
+	if maxAge == 0 && maxCount == 0 {
+		return "SERVER: " + room + " keeps all of its messages"
+	}
+	var rules []string
+	if maxAge > 0 {
+		rules = append(rules, "messages older than " + formatRetentionAge(maxAge) + " are removed")
+	}
+	if maxCount > 0 {
+		rules = append(rules, "only the last " + strconv.Itoa(maxCount) + " messages are kept")
+	}
+	return "SERVER: in " + room + " " + strings.Join(rules, " and ")
+}
+
+//function that returns how many of the oldest n entries a retention policy removes, at gives each entry's time
+func retentionCut(n int, maxAge time.Duration, maxCount int, now time.Time, at func(int) time.Time) int {
+	//logs are in the order entries were added, so everything before cut goes
+	cut := 0
+	if maxCount > 0 && n > maxCount {
+		cut = n - maxCount
+	}
+	if maxAge > 0 {
+		for cut < n && now.Sub(at(cut)) > maxAge {
+			cut++
+		}
+	}
+	return cut
+}
+
+//function that writes a retention age the way it is typed, whole days as e.g. "30d"
+func formatRetentionAge(maxAge time.Duration) string {
+	if maxAge == 0 {
+		return "off"
+	}
+	if maxAge % (24 * time.Hour) == 0 {
+		return strconv.Itoa(int(maxAge / (24 * time.Hour))) + "d"
+	}
+	//drop the zero minutes and seconds Duration.String adds, "12h0m0s" is typed "12h"
+	text := maxAge.String()
+	if strings.HasSuffix(text, "m0s") {
+		text = strings.TrimSuffix(text, "0s")
+	}
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
+
+//set how long the room keeps its messages
+func (rm *Room) setRetention(maxAge time.Duration, maxCount int) {
+	rm.do(func() {
+		rm.maxAge = maxAge
+		rm.maxCount = maxCount
+	})
+}
+
+//get how long the room keeps its messages
+func (rm *Room) retention() (time.Duration, int) {
+	var maxAge time.Duration
+	var maxCount int
+	rm.do(func() { maxAge, maxCount = rm.maxAge, rm.maxCount })
+	return maxAge, maxCount
+}
+
+//remove the messages the room's retention policy no longer keeps, returns how many were removed and whether any posted a file
+func (rm *Room) prune(now time.Time) (int, bool) {
+	pruned, hadFiles := 0, false
+	rm.do(func() {
+		cut := retentionCut(len(rm.log), rm.maxAge, rm.maxCount, now, func(i int) time.Time { return rm.log[i].Timestamp })
+		if cut == 0 {
+			return
+		}
+		for i := range rm.log[:cut] {
+			if (&Message{Message: &rm.log[i]}).file() != "" {
+				hadFiles = true
+			}
+		}
+		//copy what is kept so the removed messages can be freed
+		rm.log = append(make([]shared.Message, 0, len(rm.log) - cut), rm.log[cut:]...)
+		rm.recount()
+		pruned = cut
+	})
+	return pruned, hadFiles
+}
+
+//function that applies every room's retention policy and the server log's max age (server goroutine only)
+func (s *ServerState) enforceRetention(now time.Time) {
+	collect := false
+	for name, rm := range s.rooms {
+		if s.pruneRoom(name, rm, now) {
+			collect = true
+		}
+	}
+	if maxAge := time.Duration(config.ServerLogMaxAge); maxAge > 0 {
+		if cut := retentionCut(len(s.logger), maxAge, 0, now, func(i int) time.Time { return s.logger[i].Timestamp }); cut > 0 {
+			s.logger = append(make([]Log, 0, len(s.logger) - cut), s.logger[cut:]...)
+		}
+	}
+	//files only the removed messages referred to are not needed anymore
+	if collect {
+		go files.collect(s.fileRefs(), now)
+	}
+}
+
+//function that prunes one room and records it in the server log, returns whether removed messages posted files (server goroutine only)
+func (s *ServerState) pruneRoom(name string, rm *Room, now time.Time) bool {
+	pruned, hadFiles := rm.prune(now)
+	if pruned > 0 {
+		s.audit(Log{Event: fmt.Sprintf("%d messages pruned from %s by its retention policy", pruned, name), Timestamp: now, Action: ActionPrune, Room: name})
+	}
+	return hadFiles
+}
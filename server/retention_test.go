@@ -0,0 +1,123 @@
+package server
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"multi-room_chat_system/shared"
+)
+
+func TestParseRetention(t *testing.T) {
+	ages := []struct {
+		value string
+		want time.Duration
+		ok bool
+	}{
+		{"off", 0, true},
+		{"0", 0, true},
+		{"30d", 30 * 24 * time.Hour, true},
+		{"12h", 12 * time.Hour, true},
+		{"-1h", 0, false},
+		{"0d", 0, false},
+		{"d", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range ages {
+		got, err := parseRetentionAge(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseRetentionAge(%q) = %v, %v", tt.value, got, err)
+		}
+		if tt.ok && got > 0 && formatRetentionAge(got) != tt.value {
+			t.Errorf("formatRetentionAge(%v) = %q, want %q", got, formatRetentionAge(got), tt.value)
+		}
+	}
+	counts := []struct {
+		value string
+		want int
+		ok bool
+	}{
+		{"off", 0, true},
+		{"1000", 1000, true},
+		{"-5", 0, false},
+		{"many", 0, false},
+	}
+	for _, tt := range counts {
+		got, err := parseRetentionCount(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseRetentionCount(%q) = %v, %v", tt.value, got, err)
+		}
+	}
+}
+
+func TestRetentionCut(t *testing.T) {
+	now := time.Now()
+	//entries 5, 4, 3, 2 and 1 hours old
+	at := func(i int) time.Time { return now.Add(-time.Duration(5 - i) * time.Hour) }
+	tests := []struct {
+		maxAge time.Duration
+		maxCount int
+		want int
+	}{
+		{0, 0, 0},
+		{0, 3, 2},
+		{0, 10, 0},
+		{150 * time.Minute, 0, 3},
+		{150 * time.Minute, 4, 3},
+		{10 * time.Hour, 1, 4},
+		{time.Minute, 0, 5},
+	}
+	for _, tt := range tests {
+		if got := retentionCut(5, tt.maxAge, tt.maxCount, now, at); got != tt.want {
+			t.Errorf("retentionCut(%v, %d) = %d, want %d", tt.maxAge, tt.maxCount, got, tt.want)
+		}
+	}
+}
+
+func TestEnforceRetention(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now()
+	rm := addTestRoom(t, s, "#general", RoleMember)
+	var messages []shared.Message
+	for i, content := range []string{"old", "older", "new"} {
+		age := []time.Duration{3 * time.Hour, 2 * time.Hour, time.Minute}[i]
+		messages = append(messages, shared.Message{MsgMetadata: shared.MsgMetadata{UserName: "bob", Content: content, Timestamp: now.Add(-age)}})
+	}
+	rm.restore(messages)
+	rm.setRetention(time.Hour, 0)
+	config.ServerLogMaxAge = Duration(time.Hour)
+	s.logger = append(s.logger, Log{Event: "long ago", Timestamp: now.Add(-2 * time.Hour)}, Log{Event: "just now", Timestamp: now})
+
+	s.enforceRetention(now)
+	if got := roomContents(rm); !slices.Equal(got, []string{"new"}) {
+		t.Fatalf("room kept %v", got)
+	}
+	var events []string
+	for _, l := range s.logger {
+		events = append(events, l.Event)
+	}
+	if !slices.Equal(events, []string{"just now", "2 messages pruned from #general by its retention policy"}) {
+		t.Fatalf("server log %v", events)
+	}
+	//messages are numbered on, pruning does not reuse ids
+	if history := rm.history(); history[0].ID != 3 {
+		t.Fatalf("kept message has id %d", history[0].ID)
+	}
+}
+
+func TestFormatRetentionAge(t *testing.T) {
+	tests := map[time.Duration]string{
+		0: "off",
+		48 * time.Hour: "2d",
+		12 * time.Hour: "12h",
+		90 * time.Minute: "1h30m",
+		30 * time.Minute: "30m",
+		45 * time.Second: "45s",
+		time.Hour + time.Second: "1h0m1s",
+	}
+	for age, want := range tests {
+		if got := formatRetentionAge(age); got != want {
+			t.Errorf("formatRetentionAge(%v) = %q, want %q", age, got, want)
+		}
+	}
+}
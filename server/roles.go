@@ -0,0 +1,254 @@
+package server
+
+import (
+	"fmt"
+	"multi-room_chat_system/shared"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+//permission that lets a user into staff rooms and sends them the server log and staff notices, as admins and owners have
+const PermStaff = "staff"
+
+//last word of a command that changes who owns the server, without it the command only says what it would do
+const confirmWord = "confirm"
+
+//how long a user has to confirm a command that changes who owns the server
+const confirmTTL = time.Minute
+
+//command a user was asked to confirm, e.g. "/promote alice"
+type pendingConfirm struct {
+	Command string
+	Expires time.Time
+}
+
+//names custom roles cannot take
+var builtinRoleNames = []string{"banned", "member", "admin", "owner"}
+
+//what a custom role can be called
+var validRoleName = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,23}$`)
+
+//role the owner defined with its own permissions, assigned to members in place of the member, admin or owner role's permissions
+type customRole struct {
+	Name string
+	Permissions []string
+}
+
+//function that returns the permission that lets a user run the command, "" for commands everyone can run
+func (cmd *Command) permission() string {
+	if cmd.Role == RoleBanned {
+		return ""
+	}
+	if cmd.Permission != "" {
+		return cmd.Permission
+	}
+	return strings.TrimPrefix(cmd.Name, "/")
+}
+
+//function that returns every permission a role can be given, in the order /help lists the commands
+func (r *commandRegistry) permissions() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	perms := []string{PermStaff}
+	for _, cmd := range r.order {
+		if perm := cmd.permission(); perm != "" && !slices.Contains(perms, perm) {
+			perms = append(perms, perm)
+		}
+	}
+	return perms
+}
+
+//permissions that are not a command, with the least built-in role that has them, as commands are registered with theirs
+var builtinPermissions = []struct {
+	Permission string
+	Role Role
+}{
+	{PermStaff, RoleAdmin},
+}
+
+//function that returns the permissions of the member, admin or owner role, which are those registered for it
+func (r *commandRegistry) rolePermissions(role Role) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var perms []string
+	for _, builtin := range builtinPermissions {
+		if builtin.Role <= role {
+			perms = append(perms, builtin.Permission)
+		}
+	}
+	for _, cmd := range r.order {
+		if perm := cmd.permission(); perm != "" && cmd.Role <= role && !slices.Contains(perms, perm) {
+			perms = append(perms, perm)
+		}
+	}
+	return perms
+}
+
+//function that returns what a user can do, banned users can do nothing (server goroutine only)
+func (s *ServerState) permissionsOf(name string, role Role) []string {
+	if role == RoleBanned {
+		return nil
+	}
+	if custom, exists := s.roles[s.userRoles[name]]; exists {
+		return custom.Permissions
+	}
+	return commands.rolePermissions(role)
+}
+
+//function that returns the name of the role a user has, custom roles by their own name (server goroutine only)
+func (s *ServerState) roleName(name string, role Role) string {
+	if custom, exists := s.userRoles[name]; exists && role != RoleBanned {
+		return custom
+	}
+	return roleNames[role]
+}
+
+//function that reports whether the user has a permission (server goroutine only)
+func (m *Member) has(perm string) bool {
+	return slices.Contains(GetServerState().permissionsOf(m.Username, m.Role), perm)
+}
+
+//function that reports whether the user is staff (server goroutine only)
+func (m *Member) isStaff() bool {
+	return m.has(PermStaff)
+}
+
+//function that reports whether the user can be in a room with the given permission, staff rooms need the staff permission (server goroutine only)
+func (m *Member) allowedIn(permission Role) bool {
+	if m.Role == RoleBanned {
+		return false
+	}
+	return permission <= RoleMember || m.isStaff()
+}
+
+//function that checks a custom role's name, returns why it cannot be used
+func checkRoleName(name string) error {
+	if slices.Contains(builtinRoleNames, name) {
+		return fmt.Errorf("%s is a built-in role", name)
+	}
+	if !validRoleName.MatchString(name) {
+		return fmt.Errorf("role names are lowercase letters, digits, '-' and '_', starting with a letter, at most 24 long")
+	}
+	return nil
+}
+
+//function that checks a comma separated permission list, returns the permissions or an error naming the unknown one
+func parsePermissions(value string) ([]string, error) {
+	known := commands.permissions()
+	var perms []string
+	for _, perm := range strings.Split(value, ",") {
+		if !slices.Contains(known, perm) {
+			return nil, fmt.Errorf("unknown permission %q, must be one of %s", perm, strings.Join(known, ", "))
+		}
+		if !slices.Contains(perms, perm) {
+			perms = append(perms, perm)
+		}
+	}
+	return perms, nil
+}
+
+//function that lists the roles and their permissions for /role list (server goroutine only)
+func (s *ServerState) listRoles() []string {
+	lines := []string{"member: " + strings.Join(commands.rolePermissions(RoleMember), ","), "admin: " + strings.Join(commands.rolePermissions(RoleAdmin), ","), "owner: " + strings.Join(commands.rolePermissions(RoleOwner), ",")}
+	for _, name := range sortedKeys(s.roles) {
+		var users []string
+		for _, user := range sortedKeys(s.userRoles) {
+			if s.userRoles[user] == name {
+				users = append(users, user)
+			}
+		}
+		line := name + ": " + strings.Join(s.roles[name].Permissions, ",")
+		if len(users) > 0 {
+			line += " (" + strings.Join(users, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+//function that gives a user a custom role, "" takes it away and leaves them a member (server goroutine only)
+func (s *ServerState) assignRole(name string, custom string) {
+	user := s.users[name]
+	if custom == "" {
+		delete(s.userRoles, name)
+	} else {
+		s.userRoles[name] = custom
+	}
+	wasStaff := user.isStaff()
+	//a custom role replaces what an admin could do
+	user.Role = RoleMember
+	s.refreshAccess(user, wasStaff)
+}
+
+//function that brings a user's rooms up to date with their permissions, moving them out of a staff room they lost,
+//and sending them the server log or clearing it when they became or stopped being staff (server goroutine only)
+func (s *ServerState) refreshAccess(user *Member, wasStaff bool) {
+	newSet := getRooms(user.Username, user.Role)
+	if !user.Active {
+		user.AvailableRooms = newSet
+		return
+	}
+	gained := difference(newSet, user.AvailableRooms)
+	lost := difference(user.AvailableRooms, newSet)
+	user.AvailableRooms = newSet
+	staff := user.isStaff()
+	if room := user.CurrentRoom; room != "" && !user.allowedIn(s.rooms[room].permission) {
+		now := time.Now()
+		force := &LeaveCmd{LeaveCmd: &shared.LeaveCmd{MsgMetadata: shared.MsgMetadata{Timestamp: now, UserName: user.Username, Flag: true}, Room: room, Reply: shared.ResponseMD{Status: true}}}
+		user.send(force)
+		remove(user.Username, room)
+		broadcast(user.Username, "left", now, room, "")
+	}
+	if len(gained) > 0 || (staff && !wasStaff) {
+		update := &UserUpdate{UserUpdate: &shared.UserUpdate{Promote: true, Rooms: gained, Current: user.CurrentRoom}}
+		if user.CurrentRoom == "" && staff && !wasStaff {
+			update.Log = s.formatLog()
+		}
+		user.send(update)
+	}
+	if len(lost) > 0 || (wasStaff && !staff) {
+		user.send(&UserUpdate{UserUpdate: &shared.UserUpdate{Promote: false, Rooms: lost, Current: user.CurrentRoom}})
+	}
+}
+
+//function that removes a custom role, its users are left members (server goroutine only)
+func (s *ServerState) deleteRole(custom string) []string {
+	var users []string
+	for _, name := range sortedKeys(s.userRoles) {
+		if s.userRoles[name] == custom {
+			users = append(users, name)
+			s.assignRole(name, "")
+		}
+	}
+	delete(s.roles, custom)
+	return users
+}
+
+//function that changes a custom role's permissions and brings its logged in users up to date (server goroutine only)
+func (s *ServerState) editRole(custom string, perms []string) {
+	var users []*Member
+	for _, name := range sortedKeys(s.userRoles) {
+		if s.userRoles[name] == custom {
+			users = append(users, s.users[name])
+		}
+	}
+	wasStaff := slices.Contains(s.roles[custom].Permissions, PermStaff)
+	s.roles[custom].Permissions = perms
+	for _, user := range users {
+		s.refreshAccess(user, wasStaff)
+	}
+}
+
+//function that remembers a command the user was asked to confirm, replacing any earlier one (server goroutine only)
+func (s *ServerState) askConfirm(username string, command string, now time.Time) {
+	s.confirmations[username] = pendingConfirm{Command: command, Expires: now.Add(confirmTTL)}
+}
+
+//function that reports whether the user was asked to confirm this very command and has not let it expire, a request is only confirmed once (server goroutine only)
+func (s *ServerState) confirm(username string, command string, now time.Time) bool {
+	pending, exists := s.confirmations[username]
+	delete(s.confirmations, username)
+	return exists && pending.Command == command && now.Before(pending.Expires)
+}
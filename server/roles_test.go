@@ -0,0 +1,171 @@
+package server
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+//function that returns the room updates queued for the user, and whether they were told to leave a room
+func roleUpdates(user *Member) (updates []*UserUpdate, left bool) {
+	for _, msg := range user.out.drain() {
+		switch msg := msg.(type) {
+		case *UserUpdate:
+			updates = append(updates, msg)
+		case *LeaveCmd:
+			left = true
+		}
+	}
+	return updates, left
+}
+
+func TestDemoteLeavesStaffRoom(t *testing.T) {
+	s := newTestServer(t)
+	addTestRoom(t, s, "#general", RoleMember)
+	addTestRoom(t, s, "#staff", RoleAdmin)
+	addTestUser(s, "owner", RoleOwner)
+	s.roles["mod"] = &customRole{Name: "mod", Permissions: []string{PermStaff, "kick"}}
+	admin := addTestUser(s, "admin", RoleAdmin)
+	mod := addTestUser(s, "mod", RoleMember)
+	s.userRoles["mod"] = "mod"
+
+	for _, user := range []*Member{admin, mod} {
+		loginTestUser(s, user, "#staff")
+		cmd := runTestCmd(s, "owner", "/demote " + user.Username).(*PromoteDemoteCmd)
+		if !cmd.Status {
+			t.Fatalf("/demote %s: %s", user.Username, cmd.ErrMsg)
+		}
+		updates, left := roleUpdates(user)
+		if !left || user.CurrentRoom != "" || user.isStaff() {
+			t.Fatalf("%s still in %q after demote, left %v", user.Username, user.CurrentRoom, left)
+		}
+		if len(updates) != 1 || updates[0].Promote || !slices.Equal(updates[0].Rooms, []string{"#staff"}) {
+			t.Fatalf("%s was sent %+v", user.Username, updates)
+		}
+		if _, custom := s.userRoles[user.Username]; custom {
+			t.Fatalf("%s kept their custom role", user.Username)
+		}
+	}
+}
+
+func TestPromoteSendsLog(t *testing.T) {
+	s := newTestServer(t)
+	addTestUser(s, "owner", RoleOwner)
+	user := addTestUser(s, "user", RoleMember)
+	loginTestUser(s, user, "")
+	s.audit(Log{Event: "something happened"})
+
+	//there are no staff rooms to gain, becoming staff still sends the log
+	if cmd := runTestCmd(s, "owner", "/promote user").(*PromoteDemoteCmd); !cmd.Status {
+		t.Fatal(cmd.ErrMsg)
+	}
+	updates, _ := roleUpdates(user)
+	if len(updates) != 1 || !updates[0].Promote || len(updates[0].Log) == 0 {
+		t.Fatalf("promoted user was sent %+v", updates)
+	}
+	//losing staff clears the lobby's log
+	if cmd := runTestCmd(s, "owner", "/demote user").(*PromoteDemoteCmd); !cmd.Status {
+		t.Fatal(cmd.ErrMsg)
+	}
+	updates, _ = roleUpdates(user)
+	if len(updates) != 1 || updates[0].Promote {
+		t.Fatalf("demoted user was sent %+v", updates)
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	member := commands.rolePermissions(RoleMember)
+	admin := commands.rolePermissions(RoleAdmin)
+	if slices.Contains(member, PermStaff) || slices.Contains(member, "kick") {
+		t.Fatalf("member permissions %v", member)
+	}
+	if !slices.Contains(admin, PermStaff) || !slices.Contains(admin, "kick") {
+		t.Fatalf("admin permissions %v", admin)
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now()
+	s.askConfirm("owner", "/promote bob", now)
+	if s.confirm("owner", "/promote alice", now) {
+		t.Fatal("confirmed a command that was not asked about")
+	}
+	//a wrong confirm uses up the request
+	if s.confirm("owner", "/promote bob", now) {
+		t.Fatal("confirmed after the request was used up")
+	}
+	s.askConfirm("owner", "/promote bob", now)
+	if s.confirm("owner", "/promote bob", now.Add(confirmTTL + time.Second)) {
+		t.Fatal("confirmed after the request expired")
+	}
+	s.askConfirm("owner", "/promote bob", now)
+	s.askConfirm("owner", "/promote alice", now)
+	if s.confirm("owner", "/promote bob", now) {
+		t.Fatal("confirmed a request that was replaced")
+	}
+	s.askConfirm("owner", "/promote bob", now)
+	if s.confirm("other", "/promote bob", now) {
+		t.Fatal("confirmed another user's request")
+	}
+	if !s.confirm("owner", "/promote bob", now.Add(confirmTTL - time.Second)) || s.confirm("owner", "/promote bob", now) {
+		t.Fatal("request not confirmed exactly once")
+	}
+}
+
+func TestOwnershipChangesAreConfirmed(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now()
+	addTestUser(s, "owner", RoleOwner)
+	addTestUser(s, "admin", RoleAdmin)
+	addTestUser(s, "other", RoleAdmin)
+
+	steps := []struct {
+		content string
+		at time.Duration
+		status bool
+	}{
+		//confirm without being asked only asks
+		{"/promote admin confirm", 0, false},
+		//confirming a different user than the one asked about only asks again
+		{"/promote other", 0, false},
+		{"/promote admin confirm", 0, false},
+		//too late
+		{"/promote admin confirm", confirmTTL + time.Second, false},
+		{"/promote admin", 2 * confirmTTL, false},
+		{"/promote admin confirm", 2 * confirmTTL + time.Second, true},
+	}
+	for _, step := range steps {
+		cmd := runTestCmdAt(s, "owner", step.content, now.Add(step.at)).(*PromoteDemoteCmd)
+		if cmd.Status != step.status {
+			t.Fatalf("%s at +%v: status %v, %q", step.content, step.at, cmd.Status, cmd.ErrMsg)
+		}
+	}
+	if s.users["admin"].Role != RoleOwner || s.users["other"].Role != RoleAdmin {
+		t.Fatalf("roles admin %v, other %v", s.users["admin"].Role, s.users["other"].Role)
+	}
+	//the last owner cannot be demoted, so they are never asked to confirm it
+	runTestCmd(s, "admin", "/demote owner")
+	if cmd := runTestCmd(s, "admin", "/demote owner confirm").(*PromoteDemoteCmd); !cmd.Status || s.users["owner"].Role != RoleAdmin {
+		t.Fatalf("demote owner: %v %q", cmd.Status, cmd.ErrMsg)
+	}
+	if cmd := runTestCmd(s, "admin", "/demote admin").(*PromoteDemoteCmd); cmd.Status || !strings.Contains(cmd.ErrMsg, "last owner") {
+		t.Fatalf("last owner demote: %v %q", cmd.Status, cmd.ErrMsg)
+	}
+}
+
+func TestTransferOwner(t *testing.T) {
+	s := newTestServer(t)
+	addTestUser(s, "owner", RoleOwner)
+	addTestUser(s, "bob", RoleMember)
+	if cmd := runTestCmd(s, "owner", "/transferowner bob confirm").(*TransferOwnerCmd); cmd.Status {
+		t.Fatal("transferred without being asked to confirm")
+	}
+	if cmd := runTestCmd(s, "owner", "/transferowner bob confirm").(*TransferOwnerCmd); !cmd.Status {
+		t.Fatal(cmd.ErrMsg)
+	}
+	if s.users["bob"].Role != RoleOwner || s.users["owner"].Role != RoleAdmin {
+		t.Fatalf("roles bob %v, owner %v", s.users["bob"].Role, s.users["owner"].Role)
+	}
+}
@@ -1,37 +1,276 @@
 package server
 
 import (
+	"log"
 	"multi-room_chat_system/shared"
+	"sync/atomic"
+	"time"
 )
 
-//room state
+//room state, the users and log are owned by the room's goroutine and only touched through its mailbox
 type Room struct {
+	//name of the room
+	name string
 	//active users
 	users map[string]*Member
 	//log of messages
 	log []shared.Message
 	//required room permission
 	permission Role
+	//id of the last message added to the log
+	lastID int64
+	//messages older than this are pruned, 0 keeps messages of any age
+	maxAge time.Duration
+	//only this many of the latest messages are kept, 0 keeps them all
+	maxCount int
+	//where the room's events are posted
+	webhooks []*webhook
+	//where external tools post into the room
+	incoming []*incomingHook
+	//work for the room's goroutine to run
+	mailbox chan func()
+	//closed when the room is deleted
+	done chan struct{}
+	//how many users and logged messages the room has, read by /metrics without going through the room's goroutine
+	userCount atomic.Int64
+	logCount atomic.Int64
 }
 
-//broadcast to all users in a room
-func (rm *Room) broadcast(msg *Message, sender string) {
-	for username, member := range rm.users {
-		//dont send self username, do not send sender username
-        if msg.UserName == username || username == sender {
-			continue
+//function that creates a room and starts the goroutine that owns its state
+func newRoom(name string, permission Role) *Room {
+	rm := &Room{
+		name: name,
+		users: make(map[string]*Member),
+		log: make([]shared.Message, 0),
+		permission: permission,
+		mailbox: make(chan func()),
+		done: make(chan struct{}),
+	}
+	go rm.run()
+	return rm
+}
+
+//goroutine that runs the room's work one at a time, so busy rooms do not hold up each other or the server
+func (rm *Room) run() {
+	for {
+		select {
+		case fn := <-rm.mailbox:
+			fn()
+		case <-rm.done:
+			log.Println("room", rm.name, "stopped")
+			return
 		}
-		//send message to all connected users
-		member.RecvServer <- msg
-    }
+	}
+}
+
+//function that runs fn on the room's goroutine and waits for it to finish, returns false if the room was deleted
+func (rm *Room) do(fn func()) bool {
+	finished := make(chan struct{})
+	select {
+	case rm.mailbox <- func() { fn(); close(finished) }:
+	case <-rm.done:
+		return false
+	}
+	<-finished
+	return true
+}
+
+//function that stops the room's goroutine once the room is deleted
+func (rm *Room) stop() {
+	safeClose(rm.done)
 }
 
 //add a user to the room state
 func (rm *Room) addUser(user *Member) {
-	rm.users[user.Username] = user
+	rm.do(func() {
+		rm.users[user.Username] = user
+		rm.recount()
+	})
+	user.room.Store(rm)
 }
 
 //remove a user from the room state
 func (rm *Room) removeUser(user *Member) {
-	delete(rm.users, user.Username)
-}
\ No newline at end of file
+	user.room.CompareAndSwap(rm, nil)
+	rm.do(func() {
+		//a resumed connection may already have replaced this user
+		if rm.users[user.Username] == user {
+			delete(rm.users, user.Username)
+			rm.recount()
+		}
+	})
+}
+
+//add a user to the room and announce it, returns the log the user is shown, all in one step so no message is missed or repeated
+func (rm *Room) enter(user *Member, timestamp time.Time) []shared.Message {
+	var history []shared.Message
+	rm.do(func() {
+		rm.users[user.Username] = user
+		rm.recount()
+		rm.fanOut(rm.appendLog(announcement(user.Username, "joined", timestamp, rm.name)), "")
+		rm.notify(webhookPayload{Event: EventJoin, Timestamp: timestamp, User: user.Username})
+		history = append(history, rm.log...)
+	})
+	user.room.Store(rm)
+	return history
+}
+
+//add a user action to the room's log and broadcast it to everyone but the sender
+func (rm *Room) announce(username string, action string, timestamp time.Time, sender string) *Message {
+	var msg *Message
+	rm.do(func() {
+		msg = rm.appendLog(announcement(username, action, timestamp, rm.name))
+		rm.fanOut(msg, sender)
+		event := EventLeave
+		if action == "joined" {
+			event = EventJoin
+		}
+		rm.notify(webhookPayload{Event: event, Timestamp: timestamp, User: username})
+	})
+	return msg
+}
+
+//function that logs a chat message, records the file it shares and broadcasts it, returns false if the user is no longer in the room
+func (rm *Room) post(m *Message, user *Member) bool {
+	posted := false
+	rm.do(func() {
+		if rm.users[user.Username] != user {
+			return
+		}
+		//users allowed in the room can download the file from now on, recorded before anyone is sent the message
+		if file := m.file(); file != "" {
+			files.post(file, rm.name, user.Username)
+		}
+		rm.publish(m)
+		posted = true
+	})
+	return posted
+}
+
+//function that logs and broadcasts a message from someone who is not in the room (e.g. through the REST API), returns false if the room was deleted
+func (rm *Room) postExternal(m *Message) bool {
+	return rm.do(func() { rm.publish(m) })
+}
+
+//function that adds a chat message to the log and sends it to everyone in the room (room goroutine only)
+func (rm *Room) publish(m *Message) {
+	m.ID = rm.appendLog(*m.Message).ID
+	m.Response = shared.ResponseMD{Status: true, CurrentRoom: rm.name}
+	log.Println("Message room:", rm.name)
+	rm.fanOut(m, "")
+	metrics.messagePosted(rm.name)
+	rm.notify(messageEvent(*m.Message))
+}
+
+//function that applies an inspected link to a logged message and sends the follow-up to everyone in the room
+func (rm *Room) updateMessage(update *shared.MessageUpdate) {
+	rm.do(func() {
+		for i := len(rm.log) - 1; i >= 0; i-- {
+			if rm.log[i].ID != update.ID {
+				continue
+			}
+			rm.log[i].Image = rm.log[i].Image || update.Image
+			rm.log[i].Preview = update.Preview
+			msg := &MessageUpdate{MessageUpdate: update}
+			for _, member := range rm.users {
+				member.send(msg)
+			}
+			return
+		}
+	})
+}
+
+//get the usernames of the users in the room
+func (rm *Room) members() []string {
+	var names []string
+	rm.do(func() { names = mapToSlice(rm.users) })
+	return names
+}
+
+//get a copy of the room's log
+func (rm *Room) history() []shared.Message {
+	var history []shared.Message
+	rm.do(func() { history = append(history, rm.log...) })
+	return history
+}
+
+//get the stored files the room's log refers to
+func (rm *Room) fileRefs() []string {
+	var refs []string
+	rm.do(func() {
+		for i := range rm.log {
+			if file := (&Message{Message: &rm.log[i]}).file(); file != "" {
+				refs = append(refs, file)
+			}
+		}
+	})
+	return refs
+}
+
+//get the messages added to the room's log after the given message id
+func (rm *Room) messagesSince(id int64) []shared.Message {
+	missed := make([]shared.Message, 0)
+	rm.do(func() {
+		for _, msg := range rm.log {
+			if msg.ID > id {
+				missed = append(missed, msg)
+			}
+		}
+	})
+	return missed
+}
+
+//function that restores a saved log, messages saved before ids existed are numbered as they are loaded
+func (rm *Room) restore(messages []shared.Message) {
+	rm.do(func() {
+		for _, msg := range messages {
+			if msg.ID == 0 {
+				rm.appendLog(msg)
+				continue
+			}
+			rm.log = append(rm.log, msg)
+			rm.lastID = max(rm.lastID, msg.ID)
+		}
+		rm.recount()
+	})
+}
+
+//add a message to the room's log, giving it the next message id (room goroutine only)
+func (rm *Room) appendLog(msg shared.Message) *Message {
+	rm.lastID++
+	msg.ID = rm.lastID
+	rm.log = append(rm.log, msg)
+	rm.recount()
+	return &Message{Message: &msg}
+}
+
+//function that updates the counts /metrics reads after the room's users or log changed (room goroutine only)
+func (rm *Room) recount() {
+	rm.userCount.Store(int64(len(rm.users)))
+	rm.logCount.Store(int64(len(rm.log)))
+}
+
+//broadcast to all users in a room (room goroutine only)
+func (rm *Room) fanOut(msg *Message, sender string) {
+	for username, member := range rm.users {
+		//dont send self username, do not send sender username
+		if msg.UserName == username || username == sender {
+			continue
+		}
+		//queue message for all connected users
+		member.send(msg)
+	}
+}
+
+//function that builds the log entry for a user action in a room
+func announcement(username string, action string, timestamp time.Time, room string) shared.Message {
+	return shared.Message{
+		MsgMetadata: shared.MsgMetadata{
+			Timestamp: timestamp,
+			UserName:  username,
+			Flag:      true,
+			Content:   " " + action + " " + room,
+		},
+		Response: shared.ResponseMD{Status: true, CurrentRoom: room},
+	}
+}
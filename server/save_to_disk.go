@@ -3,14 +3,26 @@ package server
 import (
 	"encoding/json"
 	"os"
+	"slices"
 	"time"
 	"multi-room_chat_system/shared"
 )
 
+//file the server state is saved to
+const statePath = "serverState.json"
+
 //type for persisting user state
 type PersistUser struct {
 	Username string
 	Role Role
+	//SHA-256 of the user's REST API token, left out for users without one
+	APIToken string `json:",omitempty"`
+	Bot bool `json:",omitempty"`
+	//name of the user's custom role, left out for users with a built-in one
+	CustomRole string `json:",omitempty"`
+	//presence and status text the user chose, left out for users who are simply online
+	Presence string `json:",omitempty"`
+	Status string `json:",omitempty"`
 }
 
 //type for persisting room state
@@ -18,6 +30,11 @@ type PersistRoom struct {
 	Name string
 	Permission Role
 	Log []PersistMessage
+	//retention policy, left out for rooms that keep all of their messages
+	MaxAge Duration `json:",omitempty"`
+	MaxCount int `json:",omitempty"`
+	Webhooks []webhook `json:",omitempty"`
+	IncomingHooks []incomingHook `json:",omitempty"`
 }
 
 //type for persisting message state
@@ -26,70 +43,149 @@ type PersistMessage struct {
 	Timestamp time.Time
 	Content string
 	Image bool
+	URL bool
 	Flag bool
+	ID int64
+	Preview *shared.LinkPreview `json:",omitempty"`
+	Attachment *shared.Attachment `json:",omitempty"`
+	Thumbnails []shared.Thumbnail `json:",omitempty"`
+	Integration bool `json:",omitempty"`
 }
 
 //type for persisting our server state
 type PersistState struct {
+	//schema version the file was written with, see migrate.go
+	Version int
 	Users map[string]PersistUser
 	Rooms map[string]PersistRoom
+	//roles the owner defined
+	Roles []customRole `json:",omitempty"`
 	Log []Log
 }
 
 //function that writes our server state to a file
 func (s *ServerState) SaveToDisk() error {
+	return writeState(statePath, s.snapshot())
+}
+
+//function that converts the server state into its persistent form (server goroutine only)
+func (s *ServerState) snapshot() PersistState {
 	//define persistent state
 	p := PersistState{Users: make(map[string]PersistUser), Rooms: make(map[string]PersistRoom), Log: make([]Log, 0)}
 	//convert current users to the persistent user state
 	for name, user := range s.users {
-		p.Users[name] = PersistUser{Username: name, Role: user.Role}
+		p.Users[name] = PersistUser{Username: name, Role: user.Role, APIToken: s.apiTokens[name], Bot: user.Bot, CustomRole: s.userRoles[name], Presence: s.presence[name].State, Status: s.presence[name].Text}
 	}
 	//convert current rooms into the persistent room state
 	for name, room := range s.rooms {
 		roomInfo := PersistRoom{Name: name, Permission: room.permission, Log: make([]PersistMessage, 0)}
+		maxAge, maxCount := room.retention()
+		roomInfo.MaxAge, roomInfo.MaxCount = Duration(maxAge), maxCount
+		for _, wh := range room.hooks() {
+			roomInfo.Webhooks = append(roomInfo.Webhooks, *wh)
+		}
+		for _, ih := range room.incomingHooks() {
+			roomInfo.IncomingHooks = append(roomInfo.IncomingHooks, *ih)
+		}
 		//loop through the room's current log
-		for _, msg := range room.log {
+		for _, msg := range room.history() {
 			//convery to persistent message type
-			roomInfo.Log = append(roomInfo.Log, PersistMessage{Username: msg.UserName, Timestamp: msg.Timestamp, Content: msg.Content, Image: msg.Image, Flag: msg.Flag})
+			roomInfo.Log = append(roomInfo.Log, PersistMessage{Username: msg.UserName, Timestamp: msg.Timestamp, Content: msg.Content, Image: msg.Image, URL: msg.URL, Flag: msg.Flag, ID: msg.ID, Preview: msg.Preview, Attachment: msg.Attachment, Thumbnails: msg.Thumbnails, Integration: msg.Integration})
 		}
 		//save information to persistent state
 		p.Rooms[name] = roomInfo
 	}
+	for _, name := range sortedKeys(s.roles) {
+		p.Roles = append(p.Roles, *s.roles[name])
+	}
 	//add logger to persistent state
 	p.Log = append(p.Log, s.logger...)
-	//encode persistent state as JSON
-	data, err := json.MarshalIndent(p, "", " ")
+	return p
+}
+
+//function that reads a persisted state from a file, older versions are migrated, returns the version it was saved as
+func readState(path string) (PersistState, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PersistState{}, 0, err
+	}
+	return decodeState(data)
+}
+
+//function that encodes a persisted state as JSON in the current version
+func encodeState(p PersistState) ([]byte, error) {
+	p.Version = stateVersion
+	return json.MarshalIndent(p, "", " ")
+}
+
+//function that writes a persisted state to a file, replacing it in one step so it is never left half written
+func writeState(path string, p PersistState) error {
+	data, err := encodeState(p)
 	if err != nil {
 		return err
 	}
-	//write to file
-	return os.WriteFile("serverState.json", data, 0644)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
 }
 
 //function that loads our server state from a file
 func (s *ServerState) LoadFromDisk() error {
 	//read from the serverState file
-	data, err := os.ReadFile("serverState.json")
+	p, version, err := readState(statePath)
 	if err != nil {
-        return err
-    }
-	var p PersistState
-	//unmarshall json file
-    if err := json.Unmarshal(data, &p); err != nil {
-        return err
-    }
+		return err
+	}
+	//keep the file as it was and save it in the new version straight away, so it is only migrated once
+	if version < stateVersion {
+		if err := backupState(statePath, version); err != nil {
+			return err
+		}
+		if err := writeState(statePath, p); err != nil {
+			return err
+		}
+	}
+	//rebuild roles before the users that have them
+	for _, role := range p.Roles {
+		s.roles[role.Name] = &role
+	}
 	//rebuild users
 	for name, user := range p.Users {
-		u := &Member{User: User{Username: name, Role: user.Role, Active: false}}
+		u := &Member{User: User{Username: name, Role: user.Role, Active: false, Bot: user.Bot}}
 		//add user back to the server state
 		s.users[name] = u
+		if user.APIToken != "" {
+			s.apiTokens[name] = user.APIToken
+		}
+		if _, exists := s.roles[user.CustomRole]; exists {
+			s.userRoles[name] = user.CustomRole
+		}
+		if slices.Contains(presenceStates, user.Presence) {
+			s.presence[name] = presence{State: user.Presence, Text: user.Status}
+		}
 	}
 	//rebuild rooms
 	for name, room := range p.Rooms {
-		r := &Room{users: make(map[string]*Member), log: make([]shared.Message, 0), permission: room.Permission}
+		r := newRoom(name, room.Permission)
 		//rebuild room's log
+		messages := make([]shared.Message, 0, len(room.Log))
 		for _, msg := range room.Log {
-			r.log = append(r.log, shared.Message{MsgMetadata: shared.MsgMetadata{UserName: msg.Username, Timestamp: msg.Timestamp, Content: msg.Content, Flag: msg.Flag}, Image: msg.Image})
+			restored := shared.Message{MsgMetadata: shared.MsgMetadata{UserName: msg.Username, Timestamp: msg.Timestamp, Content: msg.Content, Flag: msg.Flag}, Image: msg.Image, URL: msg.URL, ID: msg.ID, Preview: msg.Preview, Attachment: msg.Attachment, Thumbnails: msg.Thumbnails, Integration: msg.Integration}
+			messages = append(messages, restored)
+			//files posted before downloads were checked stay visible to the room
+			if file := (&Message{Message: &restored}).file(); file != "" {
+				files.post(file, name, msg.Username)
+			}
+		}
+		r.restore(messages)
+		r.setRetention(time.Duration(room.MaxAge), room.MaxCount)
+		for _, wh := range room.Webhooks {
+			r.addWebhook(&wh)
+		}
+		for _, ih := range room.IncomingHooks {
+			s.addIncoming(r, &ih)
 		}
 		//add room back to server state
 		s.rooms[name] = r
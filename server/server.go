@@ -21,9 +21,39 @@ type ServerState struct {
 	fileServer *http.Server
 	//logger for server
 	logger []Log
+	//map session tokens to resumable sessions
+	sessions map[string]*session
 	//channels to receive/respond user joins 
 	recvUser chan ServerJoinRequest
+	recvResume chan ResumeRequest
 	joinResp chan *ServerJoinResponse
+	//channels to receive/ack closed connections
+	recvDisconnect chan DisconnectRequest
+	ackDisconnect chan struct{}
+	//channel to check file downloads against users' sessions and rooms
+	recvFileAuth chan FileAuthRequest
+	//channel to run REST API requests
+	recvAPI chan APIRequest
+	//channel for incoming webhooks to post to their rooms, and the room of each incoming webhook by id
+	recvHook chan HookRequest
+	incoming map[string]incomingRef
+	//channel for connections to report users going idle and coming back
+	recvPresence chan PresenceRequest
+	//channel for the backup writer to report a finished backup, and whether one is being written
+	recvBackup chan BackupResult
+	backingUp bool
+	//channel for the transcript writer to report a finished export, and the users who have one being written
+	recvExport chan ExportResult
+	exporting map[string]bool
+	//hash of each user's REST API token
+	apiTokens map[string]string
+	//roles the owner defined, and the custom role of each user that has one
+	roles map[string]*customRole
+	userRoles map[string]string
+	//presence and status text of users who chose something other than online
+	presence map[string]presence
+	//command each user was last asked to confirm
+	confirmations map[string]pendingConfirm
 	
 	recvInput chan *shared.MsgMetadata
 	ackInput chan *shared.ExecutableMessage
@@ -46,16 +76,46 @@ func GetServerState() *ServerState {
 func initServer() {
 	shared.Init()
 	log.Println("Starting server")
+	if err := loadConfig("serverConfig.json"); err != nil {
+		log.Fatal("Could not load serverConfig.json:", err)
+	}
 	if err := os.MkdirAll("uploads", 0755); err != nil {
 		log.Fatal("Could not create uploads dir:", err)
 	}
+	if err := loadAttachments(); err != nil {
+		log.Fatal("Could not load attachments:", err)
+	}
+	if err := loadFileAccess(); err != nil {
+		log.Fatal("Could not load file access:", err)
+	}
+	if err := resetExports(); err != nil {
+		log.Fatal("Could not create exports dir:", err)
+	}
 	instance = &ServerState{
 		shutdownReq: false,
 		users: map[string]*Member{},
 		rooms: map[string]*Room{},
+		sessions: map[string]*session{},
 		//channels for joining users
 		recvUser: make(chan ServerJoinRequest),
+		recvResume: make(chan ResumeRequest),
 		joinResp: make(chan *ServerJoinResponse),
+		//channels for closed connections
+		recvDisconnect: make(chan DisconnectRequest),
+		ackDisconnect: make(chan struct{}),
+		recvFileAuth: make(chan FileAuthRequest),
+		recvAPI: make(chan APIRequest),
+		recvHook: make(chan HookRequest),
+		incoming: map[string]incomingRef{},
+		recvPresence: make(chan PresenceRequest),
+		recvBackup: make(chan BackupResult),
+		recvExport: make(chan ExportResult),
+		exporting: map[string]bool{},
+		apiTokens: map[string]string{},
+		roles: map[string]*customRole{},
+		userRoles: map[string]string{},
+		presence: map[string]presence{},
+		confirmations: map[string]pendingConfirm{},
 		//channels for message input
 		recvInput: make(chan *shared.MsgMetadata),
 		ackInput: make(chan *shared.ExecutableMessage),
@@ -63,7 +123,10 @@ func initServer() {
 		logger: make([]Log, 0),
 	}
 	instance.fileServer = startFileServer()
-	instance.LoadFromDisk()
+	//starting without the saved state would overwrite it on shutdown
+	if err := instance.LoadFromDisk(); err != nil && !os.IsNotExist(err) {
+		log.Fatal("Could not load ", statePath, ": ", err)
+	}
 	//start goroutine to run server
 	go instance.run()	
 	
@@ -73,15 +136,35 @@ func(s *ServerState) run() {
 	//add admin
 	//instance.users["owner"] = UserFactory("owner", RoleOwner)
 	//instance.users["admin"] = UserFactory("admin", RoleAdmin)
+	//periodically expire sessions that were not resumed in time
+	sessionSweep := time.NewTicker(time.Second)
+	defer sessionSweep.Stop()
+	//periodically remove stored files no room refers to anymore
+	var storageSweep <-chan time.Time
+	if interval := time.Duration(config.StorageGCInterval); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		storageSweep = ticker.C
+	}
+	//periodically prune messages rooms no longer keep
+	var retentionSweep <-chan time.Time
+	if interval := time.Duration(config.RetentionSweepInterval); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		retentionSweep = ticker.C
+	}
 	for{
 		select {
 		//server management of users
 		case userState := <-s.recvUser:
 			//response variable
 			var resp ServerJoinResponse
+			//bots send their token instead of a name
+			username, denied := s.loginName(string(userState))
+			if denied != "" {
+				resp = ServerJoinResponse{Status: false, Message: denied}
 			//check if user exists
-			username := string(userState)
-			if _, exists := s.users[username]; !exists {
+			} else if _, exists := s.users[username]; !exists {
 				//if dne create a new user of type member
 				newUser := UserFactory(username, RoleMember)
 				//add new user to the server state
@@ -103,9 +186,14 @@ func(s *ServerState) run() {
 						Role: s.users[username],
 					}
 				} else { //user not logged in
+					//a fresh login replaces a session that is waiting to be resumed
+					if sess, exists := s.sessions[s.users[username].token]; exists && sess.suspended {
+						s.expireSession(sess, time.Now())
+					}
 					if s.users[username].Role != RoleBanned {
 						//create new object
 						user := UserFactory(username, s.users[username].Role)
+						user.Bot = s.users[username].Bot
 						//add user to the server state for updated channels
 						user.Active = true
 						s.users[username] = user
@@ -124,16 +212,50 @@ func(s *ServerState) run() {
 					}
 				}
 			}
-			//if status is true log that the user joined
+			//if status is true log that the user joined and issue a resumable session
 			if resp.Status {
-				s.logger = append(s.logger, logEvent(username + " joined the server", time.Now(), username))
+				s.audit(Log{Event: username + " joined the server", Timestamp: time.Now(), Actor: username, Action: ActionLogin})
+				resp.Session = s.newSession(resp.Role)
 			}
 			//send response
 			s.joinResp <- &resp
-			//if admin send log
-			if resp.Status && resp.Role.Role > RoleMember {
-				resp.Role.RecvServer <- &GetLog{&shared.GetLog{Log: s.formatLog()}}
+			//if staff send log
+			if resp.Status && resp.Role.isStaff() {
+				resp.Role.send(&GetLog{&shared.GetLog{Log: s.formatLog()}})
 			}
+		//server management of reconnecting users
+		case req := <-s.recvResume:
+			resp := s.resumeSession(req, time.Now())
+			s.joinResp <- &resp
+		//connection handler reports its connection closed
+		case req := <-s.recvDisconnect:
+			s.disconnect(req, time.Now())
+			s.ackDisconnect <- struct{}{}
+		//file server checks a download
+		case req := <-s.recvFileAuth:
+			req.Resp <- s.canDownload(req)
+		//file server runs a REST API request
+		case req := <-s.recvAPI:
+			req.Resp <- s.serveAPI(req, time.Now())
+		//file server posts to an incoming webhook
+		case req := <-s.recvHook:
+			req.Resp <- s.serveHook(req, time.Now())
+		//connection reports its user went idle or is back
+		case req := <-s.recvPresence:
+			s.setIdle(req)
+		//backup writer finished an archive
+		case res := <-s.recvBackup:
+			s.finishBackup(res, time.Now())
+		//transcript writer finished an export
+		case res := <-s.recvExport:
+			s.finishExport(res)
+		case now := <-sessionSweep.C:
+			s.expireSessions(now)
+		case now := <-storageSweep:
+			//the rooms are read here, the files are removed off the server goroutine
+			go files.collect(s.fileRefs(), now)
+		case now := <-retentionSweep:
+			s.enforceRetention(now)
 		//server receives raw input from a client
 		case input := <-s.recvInput:
 			//add timestamp to metadata
@@ -174,8 +296,38 @@ func (s *ServerState) JoinServer(username string, reply *ServerJoinResponse) err
     return nil
 }
 
+//resume session RPC stub
+func (s *ServerState) ResumeServer(req ResumeRequest, reply *ServerJoinResponse) error {
+	//send to the server's state goroutine
+	s.recvResume <- req
+	//wait for the server to respond
+	temp := <-s.joinResp
+	*reply = *temp
+	return nil
+}
+
+//closed connection RPC stub
+func (s *ServerState) Disconnect(user *Member, resumable bool) error {
+	s.recvDisconnect <- DisconnectRequest{User: user, Resumable: resumable}
+	<-s.ackDisconnect
+	return nil
+}
+
+//file download RPC stub, returns false once the server is shutting down
+func (s *ServerState) AuthorizeFile(token string, file string) bool {
+	req := FileAuthRequest{Token: token, File: file, Resp: make(chan bool, 1)}
+	select {
+	case s.recvFileAuth <- req:
+		return <-req.Resp
+	case <-s.term:
+		return false
+	}
+}
+
 //receive input RPC stub
 func (s *ServerState) RecvMessage(input *shared.MsgMetadata, reply *shared.ExecutableMessage) error {
+	start := time.Now()
+	defer func() { metrics.observeLatency(time.Since(start)) }()
 	//send metadata to the server
 	s.recvInput <- input
 	//wait for ack
@@ -184,6 +336,19 @@ func (s *ServerState) RecvMessage(input *shared.MsgMetadata, reply *shared.Execu
 	return nil
 }
 
+//room message RPC stub for clients and the IRC gateway, chat messages go straight to the user's room goroutine (Room.post) instead of through the server goroutine
+func (s *ServerState) RecvRoomMessage(user *Member, input *shared.MsgMetadata, reply *shared.ExecutableMessage) error {
+	//add timestamp to metadata
+	input.Timestamp = time.Now()
+	//building the message may inspect links, which only holds up this user
+	msg := MessageFactory(*input, s)
+	if m, ok := msg.(*Message); ok {
+		m.postTo(user.room.Load(), user)
+	}
+	*reply = msg
+	return nil
+}
+
 //helper function to get the user's joinable rooms
 func getJoinableRooms(user *Member) string {
 	temp := "Available rooms:"
@@ -200,9 +365,17 @@ func startFileServer() *http.Server {
 
     //serve static files from ./uploads
     fs := http.FileServer(http.Dir("./uploads"))
-    mux.Handle("/uploads/", http.StripPrefix("/uploads/", fs))
+    mux.Handle("/uploads/", requireFileAccess(http.StripPrefix("/uploads/", fs)))
 
 	mux.HandleFunc("/upload", uploadHandler)
+	mux.HandleFunc("/attach", attachmentHandler)
+	mux.Handle("/files/", requireFileAccess(http.HandlerFunc(downloadHandler)))
+	mux.Handle("/exports/", requireFileAccess(http.HandlerFunc(exportHandler)))
+	registerAPI(mux)
+	mux.HandleFunc("POST /hooks/{id}/{token}", hookHandler)
+	if config.Metrics {
+		mux.HandleFunc("/metrics", metricsHandler)
+	}
 
     srv := &http.Server{
         Addr:    ":8080",
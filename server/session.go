@@ -0,0 +1,139 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"multi-room_chat_system/shared"
+	"time"
+)
+
+//resumable session issued to a client when it logs in
+type session struct {
+	token string
+	username string
+	//set while the client is disconnected and may still resume
+	suspended bool
+	//room the user was in when the connection dropped
+	room string
+	//when a suspended session can no longer be resumed
+	expires time.Time
+}
+
+//function that issues a new resumable session to a user who just logged in
+func (s *ServerState) newSession(user *Member) *shared.Session {
+	token := newToken()
+	s.sessions[token] = &session{token: token, username: user.Username}
+	user.token = token
+	return &shared.Session{Token: token, HeartbeatInterval: time.Duration(config.HeartbeatInterval)}
+}
+
+//function that ends a user's session so it can no longer be resumed
+func (s *ServerState) endSession(user *Member) {
+	delete(s.sessions, user.token)
+}
+
+//function that handles a closed connection, suspending the session for a grace period instead of quitting
+func (s *ServerState) disconnect(req DisconnectRequest, timestamp time.Time) {
+	user := req.User
+	//connection was taken over by a resumed one, or the user already quit/was kicked
+	if s.users[user.Username] != user || !user.Active {
+		return
+	}
+	sess, exists := s.sessions[user.token]
+	if !req.Resumable || !exists || time.Duration(config.ResumeGrace) <= 0 {
+		quit := &QuitCmd{QuitCmd: &shared.QuitCmd{MsgMetadata: shared.MsgMetadata{UserName: user.Username, Timestamp: timestamp}}}
+		quit.ExecuteServer()
+		return
+	}
+	//leave the room quietly, the leave is only announced if the session is never resumed
+	if user.CurrentRoom != "" {
+		s.rooms[user.CurrentRoom].removeUser(user)
+		s.sendPresence(user.CurrentRoom, "")
+	}
+	sess.suspended = true
+	sess.room = user.CurrentRoom
+	sess.expires = timestamp.Add(time.Duration(config.ResumeGrace))
+	user.CurrentRoom = ""
+	user.Active = false
+	log.Println(user.Username, "disconnected, session can be resumed until", sess.expires.Format("15:04:05"))
+}
+
+//function that expires suspended sessions whose grace period is over
+func (s *ServerState) expireSessions(now time.Time) {
+	for _, sess := range s.sessions {
+		if sess.suspended && now.After(sess.expires) {
+			s.expireSession(sess, now)
+		}
+	}
+}
+
+//function that ends a suspended session, announcing the leave that was held back when it disconnected
+func (s *ServerState) expireSession(sess *session, timestamp time.Time) {
+	delete(s.sessions, sess.token)
+	if _, exists := s.rooms[sess.room]; exists {
+		broadcast(sess.username, "left", timestamp, sess.room, "")
+		s.audit(Log{Event: sess.username + " left " + sess.room, Timestamp: timestamp, Actor: sess.username, Action: ActionLeave, Room: sess.room})
+	}
+	s.audit(Log{Event: sess.username + " left the server", Timestamp: timestamp, Actor: sess.username, Action: ActionLogout})
+}
+
+//function that reattaches a reconnecting client to its session and collects the messages it missed
+func (s *ServerState) resumeSession(req ResumeRequest, timestamp time.Time) ServerJoinResponse {
+	sess, exists := s.sessions[req.Token]
+	if !exists {
+		return ServerJoinResponse{Status: false, Message: "RESUME FAILED: session expired\n>"}
+	}
+	old := s.users[sess.username]
+	if old.Role == RoleBanned {
+		delete(s.sessions, req.Token)
+		return ServerJoinResponse{Status: false, Message: "PERMISSION DENIED: You are banned!\n>", Role: old}
+	}
+	room := sess.room
+	if old.Active {
+		//the old connection has not noticed it dropped yet, take over from it
+		room = old.CurrentRoom
+		if room != "" {
+			s.rooms[room].removeUser(old)
+		}
+		safeClose(old.Term)
+	}
+	//create new object with fresh channels for the new connection
+	user := UserFactory(sess.username, old.Role)
+	user.Bot = old.Bot
+	//the session reply goes out before anything queued while rejoining
+	user.out.hold()
+	user.Active = true
+	user.token = req.Token
+	s.users[sess.username] = user
+	sess.suspended = false
+	resumed := &shared.Session{Token: req.Token, HeartbeatInterval: time.Duration(config.HeartbeatInterval), Resumed: true}
+	if rm, exists := s.rooms[room]; exists {
+		if user.allowedIn(rm.permission) {
+			//quietly rejoin the room and collect what was missed
+			rm.addUser(user)
+			user.CurrentRoom = room
+			resumed.Room = room
+			resumed.Missed = rm.messagesSince(req.LastID)
+			s.sendPresence(room, "")
+		} else {
+			//lost access while disconnected, announce the leave that was held back
+			broadcast(user.Username, "left", timestamp, room, "")
+			s.audit(Log{Event: user.Username + " left " + room, Timestamp: timestamp, Actor: user.Username, Action: ActionLeave, Room: room})
+		}
+	}
+	log.Println(user.Username, "resumed their session")
+	return ServerJoinResponse{
+		Status: true,
+		Message: "Welcome back to the server!\n" + getJoinableRooms(user),
+		Role: user,
+		Session: resumed,
+	}
+}
+
+//helper function that generates a random session token
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+//function that returns the file on disk holding the contents of a file served at the given path
+func blobPath(file string) string {
+	if id, ok := strings.CutPrefix(file, "files/"); ok {
+		if a, exists := attachments.get(id); exists {
+			return a.blob()
+		}
+		return filepath.Join(attachmentDir, id)
+	}
+	//images are served straight from where they are stored
+	return filepath.FromSlash(file)
+}
+
+//function that stores contents under the given name, contents that are already stored are kept as they are
+func storeFile(name string, data []byte) error {
+	if _, err := os.Stat(name); err == nil {
+		//mark the contents as in use so they are not collected before they are recorded again
+		now := time.Now()
+		return os.Chtimes(name, now, now)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), "upload-*")
+	if err != nil {
+		return err
+	}
+	err = tmp.Chmod(0644)
+	if err == nil {
+		_, err = tmp.Write(data)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return commitFile(tmp.Name(), name)
+}
+
+//function that copies an upload to a temporary file in dir, returns the file, the sha256 of its contents and its size
+func spoolFile(r io.Reader, dir string) (string, string, int64, error) {
+	tmp, err := os.CreateTemp(dir, "upload-*")
+	if err != nil {
+		return "", "", 0, err
+	}
+	hash := sha256.New()
+	var size int64
+	err = tmp.Chmod(0644)
+	if err == nil {
+		size, err = io.Copy(io.MultiWriter(tmp, hash), r)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", "", 0, err
+	}
+	return tmp.Name(), hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+//function that moves a temporary file to its content-addressed name, dropping it if the contents are already stored
+func commitFile(tmp string, name string) error {
+	if _, err := os.Stat(name); err == nil {
+		os.Remove(tmp)
+		now := time.Now()
+		return os.Chtimes(name, now, now)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+//function that returns the name image contents are stored under
+func contentName(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+//function that sets aside size bytes of a user's quota for storing blob, in the same step as checking it so concurrent uploads cannot
+//both fit, returns false if it would take them over; release gives the bytes back once the upload is recorded or has failed
+func (fa *fileAccess) reserve(username string, blob string, size int64) (func(), bool) {
+	release := func() {}
+	if config.UserQuota <= 0 {
+		return release, true
+	}
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	counted := make(map[string]bool)
+	var used int64
+	for _, rec := range fa.files {
+		if counted[rec.Blob] || !slices.Contains(rec.Owners, username) {
+			continue
+		}
+		counted[rec.Blob] = true
+		used += rec.Size
+	}
+	//contents they already stored do not count twice
+	if counted[blob] {
+		return release, true
+	}
+	if used + fa.reserved[username] + size > config.UserQuota {
+		return release, false
+	}
+	fa.reserved[username] += size
+	return func() {
+		fa.mu.Lock()
+		defer fa.mu.Unlock()
+		if fa.reserved[username] -= size; fa.reserved[username] <= 0 {
+			delete(fa.reserved, username)
+		}
+	}, true
+}
+
+//function that removes stored files no room's log refers to anymore, refs are the files the logs refer to
+func (fa *fileAccess) collect(refs map[string]bool, now time.Time) {
+	grace := time.Duration(config.StorageGCInterval)
+	var dropped []string
+	live := make(map[string]bool)
+	fa.mu.Lock()
+	for file, rec := range fa.files {
+		//files that were just uploaded have not been posted yet
+		if refs[file] || now.Sub(rec.LastUsed) <= grace {
+			live[rec.Blob] = true
+			continue
+		}
+		delete(fa.files, file)
+		dropped = append(dropped, file)
+	}
+	if len(dropped) > 0 {
+		fa.save()
+	}
+	fa.mu.Unlock()
+
+	var ids []string
+	for _, file := range dropped {
+		if id, ok := strings.CutPrefix(file, "files/"); ok {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > 0 {
+		if err := attachments.remove(ids); err != nil {
+			log.Println("Error saving attachment index:", err)
+		}
+	}
+
+	//remove contents (and thumbnails) no remaining file uses, anything written recently may still be recorded
+	removed, freed := 0, int64(0)
+	thumbs := filepath.Join("uploads", thumbnailDir) + string(filepath.Separator)
+	for _, dir := range []string{"uploads", attachmentDir} {
+		filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || name == filepath.Join(attachmentDir, "index.json") {
+				return nil
+			}
+			blob := name
+			if strings.HasPrefix(name, thumbs) {
+				blob = filepath.Join("uploads", filepath.Base(name))
+			}
+			info, err := d.Info()
+			if err != nil || live[blob] || now.Sub(info.ModTime()) <= grace {
+				return nil
+			}
+			if os.Remove(name) == nil {
+				removed++
+				freed += info.Size()
+			}
+			return nil
+		})
+	}
+	if len(dropped) > 0 || removed > 0 {
+		log.Println("storage collected", len(dropped), "unused files,", removed, "stored files removed,", formatSize(freed), "freed")
+	}
+}
+
+//function that collects the stored files every room's log refers to (server goroutine only)
+func (s *ServerState) fileRefs() map[string]bool {
+	refs := make(map[string]bool)
+	for _, rm := range s.rooms {
+		for _, file := range rm.fileRefs() {
+			refs[file] = true
+		}
+	}
+	return refs
+}
+
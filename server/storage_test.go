@@ -0,0 +1,66 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+//function that returns an empty file access store
+func newTestFileAccess() *fileAccess {
+	return &fileAccess{tokens: make(map[string]uploadGrant), files: make(map[string]*fileRecord), reserved: make(map[string]int64)}
+}
+
+func TestReserveQuota(t *testing.T) {
+	newTestServer(t)
+	config.UserQuota = 100
+	fa := newTestFileAccess()
+	fa.files["uploads/a.png"] = &fileRecord{Owners: []string{"bob"}, Blob: "blob-a", Size: 40}
+
+	release, ok := fa.reserve("bob", "blob-b", 50)
+	if !ok {
+		t.Fatal("50 bytes refused with 60 free")
+	}
+	if _, ok := fa.reserve("bob", "blob-c", 20); ok {
+		t.Fatal("reservation ignored the upload still being written")
+	}
+	if _, ok := fa.reserve("bob", "blob-a", 80); !ok {
+		t.Fatal("contents bob already stored counted twice")
+	}
+	if _, ok := fa.reserve("alice", "blob-c", 100); !ok {
+		t.Fatal("alice's quota was taken by bob's uploads")
+	}
+	release()
+	if _, ok := fa.reserve("bob", "blob-c", 20); !ok {
+		t.Fatal("released bytes were not given back")
+	}
+}
+
+func TestReserveQuotaConcurrent(t *testing.T) {
+	newTestServer(t)
+	config.UserQuota = 100
+	fa := newTestFileAccess()
+	var wg sync.WaitGroup
+	var accepted atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := fa.reserve("bob", "blob", 30); ok {
+				accepted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted.Load() != 3 {
+		t.Fatalf("%d uploads of 30 bytes fit in a 100 byte quota", accepted.Load())
+	}
+}
+
+func TestReserveWithoutQuota(t *testing.T) {
+	newTestServer(t)
+	config.UserQuota = 0
+	if _, ok := newTestFileAccess().reserve("bob", "blob", 1 << 40); !ok {
+		t.Fatal("upload refused with no quota set")
+	}
+}
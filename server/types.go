@@ -26,6 +26,17 @@ type ServerJoinResponse struct { //server response to join request (server -> cl
 	Status bool
 	Message string
 	Role *Member
+	Session *shared.Session //sent to the client once its connection starts
+}
+
+type ResumeRequest struct { //request to resume a dropped session (client -> server)
+	Token string
+	LastID int64 //last message the client saw in its room
+}
+
+type DisconnectRequest struct { //connection handler reporting its connection closed (connection -> server)
+	User *Member
+	Resumable bool //false for clients that cannot resume (e.g. IRC)
 }
 
 type JoinRoomReq struct { //client request to join a room (client -> server) or (server -> room)
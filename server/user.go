@@ -2,28 +2,38 @@ package server
 
 import (
 	"multi-room_chat_system/shared"
+	"slices"
+	"sync/atomic"
 )
 
 type User struct {
 	Username string
 	Role Role
 	Active bool
+	//bot accounts log in with their API token instead of their name, what they can do still comes from their
+	//role like anyone else's, so a bot can be limited with a custom role without a second permission system
+	Bot bool
 }
 
 type Member struct {
 	User //inherits user
 	//current room
 	CurrentRoom string
+	//room the user's chat messages go to, read by the connection handler without going through the server
+	room atomic.Pointer[Room]
 	//channels from the server
 	ToServer chan shared.MsgMetadata
-	RecvServer chan shared.ExecutableMessage
+	//queue of messages waiting to be written to the user's connection
+	out *outbox
 	//channel to send end to user state
 	Term chan struct{}
+	//token of the user's resumable session
+	token string
+	//user sent no input for a while and is shown as away, a new connection starts out not idle
+	idle bool
 
 	//rooms a user can join
 	AvailableRooms []string
-	//commands the memeber can execute
-	Permissions []string
 }
 
 
@@ -45,40 +55,19 @@ func defMember(username string, role Role) *Member {
 		return &Member {
 			User: *defUser(username, role),
 			CurrentRoom: "",
-			AvailableRooms: getRooms(role),
+			AvailableRooms: getRooms(username, role),
 			ToServer: make(chan shared.MsgMetadata),
-			RecvServer: make(chan shared.ExecutableMessage),
+			out: newOutbox(),
 			Term: make(chan struct{}),
-			Permissions: []string{"/join", "/leave", "/listusers", "/listrooms", "/help", "/quit"},		
 		}
 	}
 }
 
-//function to define admin
-func defAdmin(username string, role Role) *Member {
-	member := *defMember(username, role)
-	member.Permissions = append(member.Permissions, "/kick", "/ban", "/unban","/create", "/delete", "/broadcast")
-	return &member
-}
-
-//function to define owner
-func defOwner(username string, role Role) *Member {
-	admin := *defAdmin(username, role)
-	admin.Permissions = append(admin.Permissions, "/promote", "/demote", "/shutdown")
-	return &admin
-}
-
 //user factory to return the correct user type to the server
 func UserFactory(name string, role Role) *Member {
-	//return type of member
-	if role == RoleMember {
+	//members, admins and the owner differ only in role, the commands each can run come from the command registry
+	if role == RoleMember || role == RoleAdmin || role == RoleOwner {
 		return defMember(name, role)
-	//return type of admin
-	} else if role == RoleAdmin {
-		return defAdmin(name, role)
-	//return type of owner
-	} else if role == RoleOwner {
-		return defOwner(name, role)
 	//return type of user if banned
 	} else {
 		//override role to indicate banned
@@ -87,64 +76,22 @@ func UserFactory(name string, role Role) *Member {
 	}
 }
 
-//function that returns the usage of each member's commands when they enter /help
-func getUsage(role Role) []string {
-	var usage []string
-	if role >= RoleMember {
-		member := []string{"/join {room}", "/leave", "/listusers", "/listrooms", "/help", }
-		usage = append(usage, member...)
-	}
-	if role >= RoleAdmin {
-		admin := []string{"/kick {user}", "/ban {user}", "/unban {user}","/create {room} {all or staff}", "/delete {room}", "/broadcast {message}"}
-		usage = append(usage, admin...)
-	}
-	if role >= RoleOwner {
-		owner := []string{"/promote {user}", "/demote {user}", "/shutdown"}
-		usage = append(usage, owner...)
-	}
-	usage = append(usage, "/quit")
-	return usage
-}
-
 //dynamically populate user's list of available rooms during runtime
-func getRooms(role Role) []string {
+func getRooms(username string, role Role) []string {
 	var rooms []string
 	//get server state
 	s := GetServerState()
+	//staff rooms need the staff permission
+	staff := slices.Contains(s.permissionsOf(username, role), PermStaff)
 	//loop through the current rooms
 	for name, room := range s.rooms {
-		//if user is at least the role of the room
-		if room.permission <= role {
+		if role != RoleBanned && (room.permission <= RoleMember || staff) {
 			rooms = append(rooms, name)
 		}
 	}
 	return rooms
 }
 
-//function that updates the user's role based on a promote/demote
-func (m *Member) updateUserState(role Role, update *UserUpdate) {
-	//get available rooms based on role
-	newSet := getRooms(role)
-	//get set of new rooms
-	update.Rooms = getRoomChanges(newSet, m.AvailableRooms)
-	//update available rooms
-	m.AvailableRooms = newSet
-	//set cmds
-	var cmds []string
-	if role >= RoleMember {
-		cmds = append(cmds, "/join", "/leave", "/listusers", "/listrooms", "/help", "/quit")
-	}
-	if role >= RoleAdmin {
-		cmds = append(cmds, "/kick", "/ban", "/unban","/create", "/delete", "/broadcast")
-	}
-	if role >= RoleOwner {
-		cmds = append(cmds, "/promote", "/demote", "/shutdown")
-	}
-	//update permissions
-	m.Permissions = cmds
-	m.Role = role
-}
-
 //helper function to get the difference in rooms between two slices
 func difference(a, b []string) []string {
     //build a lookup map for b
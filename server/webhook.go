@@ -0,0 +1,340 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"multi-room_chat_system/shared"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//events a webhook can be sent, "ping" is only sent by /webhook test
+const (
+	EventMessage = "message"
+	EventJoin = "join"
+	EventLeave = "leave"
+	EventModeration = "moderation"
+	EventPing = "ping"
+)
+
+//events a webhook can subscribe to, all of them unless it names some
+var webhookEvents = []string{EventMessage, EventJoin, EventLeave, EventModeration}
+
+//audit actions sent to webhooks as moderation events of the room they happened in
+var moderationActions = []string{ActionKick, ActionBan}
+
+//headers carrying the time a delivery was signed (unix seconds) and the HMAC-SHA256 of "{timestamp}.{body}", keyed with the webhook's secret
+const (
+	webhookTimestampHeader = "X-Chat-Timestamp"
+	webhookSignatureHeader = "X-Chat-Signature"
+)
+
+//how old a signed delivery can be before VerifyWebhook refuses it as a replay
+const WebhookTolerance = 5 * time.Minute
+
+//how many events wait for each webhook, and how many attempts are remembered for each webhook
+const (
+	webhookQueueSize = 100
+	webhookLogSize = 20
+)
+
+//first wait before retrying a failed delivery, doubled after each attempt, a variable so tests do not wait
+var webhookRetryDelay = time.Second
+
+//URL a room's events are posted to (owned by the room's goroutine, the fields are never changed once it is added)
+type webhook struct {
+	ID string
+	URL string
+	//key of the signature, shown once when the webhook is added
+	Secret string
+	//events it is sent, empty for all of them
+	Events []string
+	//events waiting for the webhook's delivery goroutine, closed done stops it
+	queue chan webhookPayload
+	done chan struct{}
+}
+
+//body of a webhook delivery
+type webhookPayload struct {
+	//unique for each event, repeated when a delivery is retried
+	Delivery string
+	Event string
+	Room string
+	Timestamp time.Time
+	//the message posted, for message events
+	Message *transcriptEntry `json:",omitempty"`
+	//who joined or left, for join and leave events
+	User string `json:",omitempty"`
+	//the server log entry, for moderation events
+	Moderation *Log `json:",omitempty"`
+}
+
+//outcome of one attempt to deliver to a webhook
+type webhookAttempt struct {
+	Delivery string
+	Event string
+	Attempt int
+	Time time.Time
+	//HTTP status the endpoint answered, 0 if it could not be reached
+	Status int
+	Error string
+}
+
+//latest delivery attempts of each webhook, shared by the delivery goroutines and the server goroutine
+type webhookLog struct {
+	mu sync.Mutex
+	attempts map[string][]webhookAttempt
+}
+
+//latest delivery attempts of each webhook
+var webhookHistory = &webhookLog{attempts: make(map[string][]webhookAttempt)}
+
+//function that records a delivery attempt, only the latest webhookLogSize are kept
+func (wl *webhookLog) add(id string, a webhookAttempt) {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	attempts := append(wl.attempts[id], a)
+	if len(attempts) > webhookLogSize {
+		attempts = attempts[len(attempts) - webhookLogSize:]
+	}
+	wl.attempts[id] = attempts
+}
+
+//function that returns a webhook's latest delivery attempts, oldest first
+func (wl *webhookLog) get(id string) []webhookAttempt {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	return slices.Clone(wl.attempts[id])
+}
+
+//function that forgets a removed webhook's attempts
+func (wl *webhookLog) remove(id string) {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	delete(wl.attempts, id)
+}
+
+//function that checks a webhook's event list, returns the events it names or an error naming the unknown one
+func parseWebhookEvents(value string) ([]string, error) {
+	if value == "" || value == "all" {
+		return nil, nil
+	}
+	events := strings.Split(value, ",")
+	for _, event := range events {
+		if !slices.Contains(webhookEvents, event) {
+			return nil, fmt.Errorf("unknown event %q, must be %s or all", event, strings.Join(webhookEvents, ", "))
+		}
+	}
+	return events, nil
+}
+
+//function that reports whether a webhook is sent an event
+func (wh *webhook) wants(event string) bool {
+	return event == EventPing || len(wh.Events) == 0 || slices.Contains(wh.Events, event)
+}
+
+//function that describes a webhook for /webhook list
+func (wh *webhook) String() string {
+	events := "all"
+	if len(wh.Events) > 0 {
+		events = strings.Join(wh.Events, ",")
+	}
+	return wh.ID + " " + wh.URL + " (" + events + ")"
+}
+
+//function that adds a webhook to the room and starts delivering to it
+func (rm *Room) addWebhook(wh *webhook) {
+	wh.queue = make(chan webhookPayload, webhookQueueSize)
+	wh.done = make(chan struct{})
+	go wh.deliver(rm.done)
+	rm.do(func() { rm.webhooks = append(rm.webhooks, wh) })
+}
+
+//function that removes a webhook from the room and stops delivering to it, returns false if the room has no webhook with that id
+func (rm *Room) removeWebhook(id string) bool {
+	removed := false
+	rm.do(func() {
+		rm.webhooks = slices.DeleteFunc(rm.webhooks, func(wh *webhook) bool {
+			if wh.ID != id {
+				return false
+			}
+			safeClose(wh.done)
+			removed = true
+			return true
+		})
+	})
+	return removed
+}
+
+//get the room's webhooks
+func (rm *Room) hooks() []*webhook {
+	var hooks []*webhook
+	rm.do(func() { hooks = slices.Clone(rm.webhooks) })
+	return hooks
+}
+
+//function that sends an event to every webhook of the room that wants it (room goroutine only)
+func (rm *Room) notify(p webhookPayload) {
+	p.Room = rm.name
+	for _, wh := range rm.webhooks {
+		if wh.wants(p.Event) {
+			wh.send(p)
+		}
+	}
+}
+
+//function that queues an event for a webhook without blocking, an event that does not fit is dropped and shows in /webhook log
+func (wh *webhook) send(p webhookPayload) {
+	if p.Delivery == "" {
+		p.Delivery = newToken()
+	}
+	select {
+	case wh.queue <- p:
+	default:
+		webhookHistory.add(wh.ID, webhookAttempt{Delivery: p.Delivery, Event: p.Event, Time: time.Now(), Error: fmt.Sprintf("dropped, %d deliveries were already waiting", webhookQueueSize)})
+	}
+}
+
+//goroutine that delivers a webhook's events one at a time and in order, until the webhook or its room is removed
+func (wh *webhook) deliver(roomDone chan struct{}) {
+	client := &http.Client{Timeout: time.Duration(config.WebhookTimeout)}
+	for {
+		select {
+		case p := <-wh.queue:
+			deliverWebhook(client, wh, p, roomDone)
+		case <-wh.done:
+			return
+		case <-roomDone:
+			return
+		}
+	}
+}
+
+//function that sends an event to a room's webhooks from outside the room
+func (rm *Room) notifyLater(p webhookPayload) {
+	rm.do(func() { rm.notify(p) })
+}
+
+//function that returns the payload of a chat message event
+func messageEvent(msg shared.Message) webhookPayload {
+	entry := newTranscript("", []shared.Message{msg}, time.Time{}, time.Time{}, msg.Timestamp).Messages[0]
+	return webhookPayload{Event: EventMessage, Timestamp: msg.Timestamp, Message: &entry}
+}
+
+//function that signs a webhook body together with the time it is sent, the signature is sent as "sha256={hex}"
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//function that checks a delivery's signature and that it was signed within WebhookTolerance of now, for endpoints written in Go,
+//timestamp and signature are the X-Chat-Timestamp and X-Chat-Signature headers
+func VerifyWebhook(secret string, body []byte, timestamp string, signature string, now time.Time) bool {
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(sent, 0)); age > WebhookTolerance || age < -WebhookTolerance {
+		return false
+	}
+	return hmac.Equal([]byte(signWebhook(secret, timestamp, body)), []byte(signature))
+}
+
+//function that posts an event to a webhook, retrying with backoff until it is accepted, config.WebhookRetries retries have failed or the webhook is removed
+func deliverWebhook(client *http.Client, wh *webhook, p webhookPayload, roomDone chan struct{}) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Println("Error encoding webhook payload:", err)
+		return
+	}
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		status, err := postWebhook(client, wh, p, body)
+		result := webhookAttempt{Delivery: p.Delivery, Event: p.Event, Attempt: attempt, Time: time.Now(), Status: status}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		webhookHistory.add(wh.ID, result)
+		if err == nil || attempt > config.WebhookRetries {
+			if err != nil {
+				log.Println("webhook", wh.ID, "gave up on", p.Event, "delivery", p.Delivery + ":", err)
+			}
+			return
+		}
+		select {
+		case <-time.After(delay):
+		case <-wh.done:
+			return
+		case <-roomDone:
+			return
+		}
+		delay *= 2
+	}
+}
+
+//function that makes one delivery attempt, anything but a 2xx answer is a failure
+func postWebhook(client *http.Client, wh *webhook, p webhookPayload, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "multi-room-chat-webhook")
+	req.Header.Set("X-Chat-Event", p.Event)
+	req.Header.Set("X-Chat-Delivery", p.Delivery)
+	//signed again for each attempt, so a retry is not refused as a replay
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, signWebhook(wh.Secret, timestamp, body))
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1 << 16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+//function that finds the room a webhook belongs to (server goroutine only)
+func (s *ServerState) findWebhook(id string) (*Room, *webhook) {
+	for _, name := range sortedKeys(s.rooms) {
+		for _, wh := range s.rooms[name].hooks() {
+			if wh.ID == id {
+				return s.rooms[name], wh
+			}
+		}
+	}
+	return nil, nil
+}
+
+//function that formats a webhook's latest delivery attempts for /webhook log
+func formatWebhookLog(id string) string {
+	attempts := webhookHistory.get(id)
+	if len(attempts) == 0 {
+		return "SERVER: nothing has been delivered to webhook " + id + " yet"
+	}
+	lines := []string{fmt.Sprintf("SERVER: latest deliveries to webhook %s (%d):", id, len(attempts))}
+	for _, a := range attempts {
+		outcome := "ok"
+		if a.Error != "" {
+			outcome = a.Error
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s attempt %d: %s", a.Time.Format("2006-01-02 15:04:05"), a.Event, a.Delivery, a.Attempt, outcome))
+	}
+	return strings.Join(lines, "\n")
+}
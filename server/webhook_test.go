@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"multi-room_chat_system/shared"
+)
+
+//request a webhook stand-in received
+type hookRequest struct {
+	header http.Header
+	body []byte
+}
+
+//function that starts a webhook stand-in answering with the given statuses in turn, the last one from then on
+func newHookServer(t *testing.T, statuses ...int) (*httptest.Server, func() []hookRequest) {
+	var mu sync.Mutex
+	var received []hookRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, hookRequest{header: r.Header.Clone(), body: body})
+		status := statuses[min(len(received), len(statuses)) - 1]
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []hookRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]hookRequest(nil), received...)
+	}
+}
+
+//function that returns a webhook for the stand-in whose attempts are forgotten when the test ends
+func newTestWebhook(t *testing.T, url string) *webhook {
+	wh := &webhook{ID: newToken(), URL: url, Secret: "s3cret", queue: make(chan webhookPayload, webhookQueueSize), done: make(chan struct{})}
+	t.Cleanup(func() { webhookHistory.remove(wh.ID) })
+	return wh
+}
+
+//function that makes retries immediate for the test
+func fastRetries(t *testing.T, retries int) {
+	saved := webhookRetryDelay
+	webhookRetryDelay = time.Millisecond
+	config.WebhookRetries = retries
+	t.Cleanup(func() { webhookRetryDelay = saved })
+}
+
+func TestWebhookDeliveryIsSigned(t *testing.T) {
+	s := newTestServer(t)
+	rm := addTestRoom(t, s, "#general", RoleMember)
+	srv, received := newHookServer(t, http.StatusOK)
+	wh := newTestWebhook(t, srv.URL)
+	rm.addWebhook(wh)
+
+	rm.postExternal(&Message{Message: &shared.Message{MsgMetadata: shared.MsgMetadata{UserName: "bob", Timestamp: time.Now(), Content: "hello"}}})
+	deadline := time.Now().Add(5 * time.Second)
+	for len(received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	reqs := received()
+	if len(reqs) != 1 {
+		t.Fatalf("stand-in received %d requests", len(reqs))
+	}
+	req := reqs[0]
+	timestamp, signature := req.header.Get(webhookTimestampHeader), req.header.Get(webhookSignatureHeader)
+	if !VerifyWebhook(wh.Secret, req.body, timestamp, signature, time.Now()) {
+		t.Fatalf("signature %q for timestamp %q does not verify", signature, timestamp)
+	}
+	if VerifyWebhook("wrong", req.body, timestamp, signature, time.Now()) {
+		t.Fatal("verified with the wrong secret")
+	}
+	if VerifyWebhook(wh.Secret, append(req.body, ' '), timestamp, signature, time.Now()) {
+		t.Fatal("verified a changed body")
+	}
+	if VerifyWebhook(wh.Secret, req.body, timestamp, signature, time.Now().Add(WebhookTolerance + time.Minute)) {
+		t.Fatal("verified a replayed delivery")
+	}
+	var p webhookPayload
+	if err := json.Unmarshal(req.body, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Event != EventMessage || p.Room != "#general" || p.Message == nil || p.Message.Content != "hello" || req.header.Get("X-Chat-Event") != EventMessage {
+		t.Fatalf("payload %+v", p)
+	}
+}
+
+func TestWebhookRetriesServerErrors(t *testing.T) {
+	newTestServer(t)
+	fastRetries(t, 3)
+	srv, received := newHookServer(t, http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK)
+	wh := newTestWebhook(t, srv.URL)
+
+	deliverWebhook(srv.Client(), wh, webhookPayload{Delivery: "d1", Event: EventPing}, nil)
+	reqs := received()
+	if len(reqs) != 3 {
+		t.Fatalf("stand-in received %d requests, want 3", len(reqs))
+	}
+	for _, req := range reqs {
+		if req.header.Get("X-Chat-Delivery") != "d1" {
+			t.Fatalf("retry sent delivery %q", req.header.Get("X-Chat-Delivery"))
+		}
+	}
+	attempts := webhookHistory.get(wh.ID)
+	var statuses []int
+	for _, a := range attempts {
+		statuses = append(statuses, a.Status)
+	}
+	if len(attempts) != 3 || attempts[0].Error == "" || attempts[2].Error != "" || attempts[2].Attempt != 3 {
+		t.Fatalf("attempts %+v (statuses %v)", attempts, statuses)
+	}
+}
+
+func TestWebhookGivesUpAfterRetries(t *testing.T) {
+	newTestServer(t)
+	fastRetries(t, 2)
+	srv, received := newHookServer(t, http.StatusServiceUnavailable)
+	wh := newTestWebhook(t, srv.URL)
+
+	deliverWebhook(srv.Client(), wh, webhookPayload{Delivery: "d1", Event: EventPing}, nil)
+	if n := len(received()); n != 3 {
+		t.Fatalf("stand-in received %d requests, want the first and 2 retries", n)
+	}
+	attempts := webhookHistory.get(wh.ID)
+	if len(attempts) != 3 || attempts[2].Status != http.StatusServiceUnavailable || !strings.Contains(attempts[2].Error, "503") {
+		t.Fatalf("attempts %+v", attempts)
+	}
+}
+
+func TestWebhookStopsRetryingWhenRemoved(t *testing.T) {
+	newTestServer(t)
+	config.WebhookRetries = 3
+	srv, received := newHookServer(t, http.StatusInternalServerError)
+	wh := newTestWebhook(t, srv.URL)
+	close(wh.done)
+
+	deliverWebhook(srv.Client(), wh, webhookPayload{Delivery: "d1", Event: EventPing}, nil)
+	if n := len(received()); n != 1 {
+		t.Fatalf("stand-in received %d requests after the webhook was removed", n)
+	}
+}
+
+func TestWebhookDroppedWhenQueueFull(t *testing.T) {
+	wh := newTestWebhook(t, "http://127.0.0.1:0")
+	for i := 0; i <= webhookQueueSize; i++ {
+		wh.send(webhookPayload{Delivery: strconv.Itoa(i), Event: EventPing})
+	}
+	if len(wh.queue) != webhookQueueSize {
+		t.Fatalf("queue holds %d events", len(wh.queue))
+	}
+	log := formatWebhookLog(wh.ID)
+	if !strings.Contains(log, EventPing + " " + strconv.Itoa(webhookQueueSize) + " attempt 0: dropped, 100 deliveries were already waiting") {
+		t.Fatalf("/webhook log:\n%s", log)
+	}
+}
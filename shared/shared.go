@@ -20,8 +20,11 @@ type ClientUI interface {
 	RemoveRoom(room string)
 	ShowLobby()
 	UserQuit(msg string)
-	DisplayImage(room string, url string)
+	DisplayImage(room string, url string, thumbnails []Thumbnail)
 	DisplayJoin(room string, Messages []Message)
+	DisplayPreview(room string, preview LinkPreview)
+	DisplayAttachment(room string, attachment Attachment)
+	SetMembers(room string, members []Presence)
 }
 
 func Init() {
@@ -44,8 +47,41 @@ func Init() {
 	gob.Register(&UnBanCmd{})
 	gob.Register(&GetLog{})
 	gob.Register(&UpdateLobby{})
+	gob.Register(&Ping{})
+	gob.Register(&Session{})
+	gob.Register(&MessageUpdate{})
+	gob.Register(&PresenceUpdate{})
+	gob.Register(&UploadTokenCmd{})
+	gob.Register(&RetentionCmd{})
+	gob.Register(&ExportCmd{})
+	gob.Register(&BackupCmd{})
+	gob.Register(&AuditCmd{})
+	gob.Register(&APITokenCmd{})
+	gob.Register(&BotCmd{})
+	gob.Register(&WebhookCmd{})
+	gob.Register(&CommandReply{})
+	gob.Register(&RoleCmd{})
+	gob.Register(&TransferOwnerCmd{})
+	gob.Register(&StatusCmd{})
 }
 
+//presence a user can have, offline is only ever shown for users who are not logged in
+const (
+	PresenceOnline = "online"
+	PresenceAway = "away"
+	PresenceDND = "dnd"
+	PresenceOffline = "offline"
+)
+
+//sent instead of a username at the login prompt by bots: "/bot {token}"
+const BotLoginCmd = "/bot"
+
+//line a client sends back when it receives a Ping, keeps the connection alive without counting as input
+const PongCmd = "/pong"
+
+//sent instead of a username at the login prompt to resume a session: "/resume {token} {last message id}"
+const ResumeCmd = "/resume"
+
 type MsgMetadata struct {
 	UserName string
 	Timestamp time.Time
@@ -66,6 +102,60 @@ type Message struct {
 	Response ResponseMD
 	Image bool
 	URL bool
+	ID int64 //position in the room's log, used to fetch missed messages after a reconnect
+	Preview *LinkPreview //set once the server has inspected a link in the message
+	Attachment *Attachment //file posted with the message
+	Thumbnails []Thumbnail //smaller copies of an uploaded image, smallest first
+	Integration bool //posted by an incoming webhook, UserName is the integration's name
+}
+
+//file uploaded to the server and posted in a room
+type Attachment struct {
+	ID string
+	Name string
+	Size int64
+	Type string //sniffed from the file's content
+	URL string //where the file can be downloaded
+}
+
+//preview card for a link, scraped from the page's OpenGraph tags
+type LinkPreview struct {
+	URL string
+	Title string
+	Description string
+	Image string //thumbnail url
+}
+
+//follow-up to a message that was already posted, sent once the server has inspected its link
+type MessageUpdate struct {
+	Room string
+	ID int64
+	URL string
+	Image bool //the link turned out to be an image
+	Preview *LinkPreview
+}
+
+//whether a user is around, and the status text they set
+type Presence struct {
+	User string
+	State string //one of the Presence constants
+	Status string
+}
+
+//function that describes a presence for user lists, e.g. "alice [away] at lunch"
+func (p Presence) String() string {
+	text := p.User + " [" + p.State + "]"
+	if p.Status != "" {
+		text += " " + p.Status
+	}
+	return text
+}
+
+//presence of everyone in a room, sent to them when someone joins, leaves or changes their presence
+type PresenceUpdate struct {
+	Room string
+	Users []Presence
+	Changed *Presence //the user whose presence changed, nil when only who is in the room changed
 }
 
 type JoinCmd struct {
@@ -95,6 +185,7 @@ type LUResp struct {
 	ResponseMD
 	Room string
 	Users []string
+	Presence []Presence //presence of each of Users, in the same order
 }
 
 type HelpCmd struct {
@@ -110,6 +201,7 @@ type HelpResp struct {
 type QuitCmd struct {
 	MsgMetadata
 	CurrentRoom string
+	Reason string //set when the server ends the session (e.g. idle timeout)
 }
 
 type KickBanCmd struct {
@@ -117,6 +209,7 @@ type KickBanCmd struct {
 	ResponseMD //for displaying error message if not having permission
 	Ban bool
 	User string
+	Reason string //optional, given after the user
 	Sender bool
 	InRoom bool
 	Msg Message
@@ -148,6 +241,21 @@ type PromoteDemoteCmd struct {
 	ResponseMD
 	User string
 	Promote bool
+	Role string //role the user was given
+}
+
+//user showing or setting their presence, /away is a shorthand for setting away
+type StatusCmd struct {
+	MsgMetadata
+	ResponseMD
+	Away bool
+}
+
+//owner handing the server to another user, who becomes an owner while the sender becomes an admin
+type TransferOwnerCmd struct {
+	MsgMetadata
+	ResponseMD
+	User string
 }
 
 type BroadcastCmd struct {
@@ -184,4 +292,118 @@ type GetLog struct {
 
 type UpdateLobby struct {
 	Update string
-}
\ No newline at end of file
+}
+
+type Ping struct {
+	Timestamp time.Time
+}
+
+//session details sent to a client right after it logs in or resumes
+type Session struct {
+	Token string
+	HeartbeatInterval time.Duration
+	Resumed bool
+	Room string //room the resumed session is back in
+	Missed []Message //messages posted to Room while the client was disconnected
+}
+
+//short-lived token a client sends with its next upload to the file server
+type UploadTokenCmd struct {
+	MsgMetadata
+	ResponseMD
+	Token string
+	Expires time.Time
+}
+
+//shows or changes how long a room keeps its messages
+type RetentionCmd struct {
+	MsgMetadata
+	ResponseMD
+	Room string
+	MaxAge time.Duration //0 keeps messages of any age
+	MaxCount int //0 keeps any number of messages
+}
+
+//transcript of a room's log written for the user who asked for it
+type ExportCmd struct {
+	MsgMetadata
+	ResponseMD
+	Room string
+	Format string //"txt", "json", "html" or "md"
+	Count int //number of messages in the transcript
+	Transcript *Attachment //where the transcript can be downloaded
+}
+
+//archive of the whole server written by the owner
+type BackupCmd struct {
+	MsgMetadata
+	ResponseMD
+	Path string //where the archive was written on the server
+	Size int64
+}
+
+//search of the server log, the matching lines are in ErrMsg
+type AuditCmd struct {
+	MsgMetadata
+	ResponseMD
+	User string //done by or to this user
+	Action string
+	Since time.Time
+	Matches int
+}
+
+//token for the REST API, only shown when it is issued
+type APITokenCmd struct {
+	MsgMetadata
+	ResponseMD
+	Token string
+	Revoke bool
+}
+
+//bot account an admin created, listed or changed
+type BotCmd struct {
+	MsgMetadata
+	ResponseMD
+	Action string //"create", "token", "delete" or "list"
+	Bot string
+	Token string //set when a token was issued
+}
+
+//custom role the owner created, edited, deleted, assigned or listed
+type RoleCmd struct {
+	MsgMetadata
+	ResponseMD
+	Action string //"create", "edit", "delete", "assign" or "list"
+	Role string
+	User string //set for "assign"
+	Permissions []string
+}
+
+//reply to a command that only answers with text (e.g. one a plugin registered), or to one the user cannot run
+type CommandReply struct {
+	MsgMetadata
+	ResponseMD
+}
+
+//webhook of a room an admin added, removed, listed, tested or looked up the deliveries of
+type WebhookCmd struct {
+	MsgMetadata
+	ResponseMD
+	Action string //"add", "incoming", "remove", "list", "log" or "test"
+	Room string
+	ID string
+	URL string
+	Secret string //set when a webhook was added, the token in an incoming webhook's URL
+}
+
+//smaller copy of an uploaded image
+type Thumbnail struct {
+	Width int
+	URL string
+}
+
+//image stored by the file server, returned when an image is uploaded
+type UploadedImage struct {
+	URL string
+	Thumbnails []Thumbnail
+}